@@ -0,0 +1,80 @@
+// Package cleanup provides a process-global registry of functions that undo
+// what a suite created, e.g. deleting a template or cluster. Registering a
+// cleanup as soon as something is created means it is removed even if a later
+// spec fails, and suites share a single teardown rather than each deleting
+// their own resources.
+//
+// The registry is per process. Suites drain it from the last node function of
+// their SynchronizedAfterSuite, which runs on node 1, so resources must be
+// registered from node 1 when running in parallel.
+package cleanup
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+)
+
+type entry struct {
+	description string
+	fn          func() error
+}
+
+var (
+	mu       sync.Mutex
+	registry []entry
+
+	// runMu serializes Run so that a Run started while another is still
+	// cleaning up, e.g. the AfterSuite after a signal, waits for it to finish
+	runMu sync.Mutex
+)
+
+// Register adds fn to the registry. description is used when logging the
+// result of fn, e.g. "deleting cluster <id>". It is safe to call from
+// multiple goroutines.
+func Register(description string, fn func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry = append(registry, entry{
+		description: description,
+		fn:          fn,
+	})
+}
+
+// Run drains the registry, calling every registered function in the reverse
+// order of registration so that resources are removed before whatever they
+// depend on. A failed cleanup is logged and doesn't stop the remaining
+// cleanups from running. An error summarizing the failures is returned if any
+// cleanup failed. If another Run is in progress, Run waits for it to finish
+// first.
+func Run() error {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	mu.Lock()
+	entries := registry
+	registry = nil
+	mu.Unlock()
+
+	failed := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+
+		if err := e.fn(); err != nil {
+			log.Error(err, "cleanup failed", "cleanup", e.description)
+			failed++
+			continue
+		}
+
+		log.Info("cleanup succeeded", "cleanup", e.description)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d cleanups failed", failed, len(entries))
+	}
+
+	return nil
+}
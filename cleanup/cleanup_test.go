@@ -0,0 +1,106 @@
+package cleanup
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRun(t *testing.T) {
+	var order []string
+	record := func(name string, err error) func() error {
+		return func() error {
+			order = append(order, name)
+			return err
+		}
+	}
+
+	Register("template", record("template", nil))
+	Register("cluster", record("cluster", errors.New("failed")))
+	Register("node pool", record("node pool", nil))
+
+	if err := Run(); err == nil {
+		t.Error("expected error when a cleanup fails")
+	}
+
+	// Cleanups run in reverse order and a failure doesn't stop the rest
+	want := []string{"node pool", "cluster", "template"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("expected cleanups to run in order %v, got %v", want, order)
+	}
+
+	// The registry is drained
+	order = nil
+	if err := Run(); err != nil {
+		t.Errorf("unexpected error running empty registry: %v", err)
+	}
+	if len(order) != 0 {
+		t.Errorf("expected no cleanups to run again, got %v", order)
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	const n = 50
+
+	var mu sync.Mutex
+	ran := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Register("concurrent", func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				ran++
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if err := Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ran != n {
+		t.Errorf("expected %d cleanups to run, got %d", n, ran)
+	}
+}
+
+func TestRunOnSignal(t *testing.T) {
+	ran := false
+	Register("cluster", func() error {
+		ran = true
+		return nil
+	})
+
+	// Cleanups don't run once signal handling has stopped
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	close(done)
+	runOnSignal(sigCh, done)
+	if ran {
+		t.Error("expected cleanups not to run without a signal")
+	}
+
+	sigCh <- syscall.SIGTERM
+	runOnSignal(sigCh, make(chan struct{}))
+	if !ran {
+		t.Error("expected cleanups to run on signal")
+	}
+
+	// A Run after the signal, e.g. from the AfterSuite, has nothing left to do
+	ran = false
+	if err := Run(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ran {
+		t.Error("expected cleanups to run only once")
+	}
+}
@@ -0,0 +1,50 @@
+package cleanup
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+)
+
+// HandleSignals runs the registry as soon as the process receives SIGINT or
+// SIGTERM, so that e.g. a cluster that is still provisioning when a CI job is
+// cancelled is deleted rather than left running. Ginkgo runs the AfterSuite
+// and exits on the same signals; the AfterSuite's Run waits for this one to
+// finish, so the process doesn't exit partway through a cleanup. The returned
+// func stops handling signals and should be called once the suite is done.
+func HandleSignals() (stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		defer signal.Stop(sigCh)
+		runOnSignal(sigCh, done)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// runOnSignal runs the registry if a signal is received before done is
+// closed
+func runOnSignal(sigCh <-chan os.Signal, done <-chan struct{}) {
+	select {
+	case sig := <-sigCh:
+		log.Info("received signal; cleaning up resources created so far, results of this run are partial",
+			"signal", sig.String())
+
+		if err := Run(); err != nil {
+			log.Error(err, "cleanup triggered by signal failed; some resources may need to be deleted manually")
+			return
+		}
+
+		log.Info("cleanup triggered by signal succeeded")
+	case <-done:
+	}
+}
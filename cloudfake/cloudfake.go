@@ -0,0 +1,481 @@
+// Package cloudfake provides an in-memory fake of the parts of the
+// Containership cloud clientset that the tests use, with scriptable status
+// transitions so that polling logic can be unit tested without the network.
+package cloudfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision"
+	"github.com/containership/csctl/cloud/provision/types"
+)
+
+// Clientset is a fake cloud.Interface. Only the provision templates, CKE
+// clusters, and node pools clients are implemented; calling anything else
+// will panic.
+type Clientset struct {
+	cloud.Interface
+
+	// NewClusterStatuses is the status script assigned to clusters created
+	// through CKEClusters().Create(). Defaults to always RUNNING.
+	NewClusterStatuses []string
+
+	// ListTemplatesError is returned by Templates().List() if set
+	ListTemplatesError error
+
+	mu sync.Mutex
+
+	createdTemplates []*types.CreateTemplateRequest
+	createdClusters  []*types.CreateCKEClusterRequest
+
+	// Descriptions of templates created through Templates().Create(), kept
+	// in their JSON form
+	templates map[string]interface{}
+	clusters  map[string]*script
+	nodePools map[string]map[string]*nodePool
+
+	// Labels of clusters created through CKEClusters().Create(), kept in
+	// their JSON form
+	clusterLabels map[string]interface{}
+
+	// Creation timestamps of clusters, as Unix seconds
+	clusterCreatedAt map[string]int64
+
+	// Templates of clusters created through CKEClusters().Create()
+	clusterTemplates map[string]string
+}
+
+// NodePool describes a fake node pool
+type NodePool struct {
+	ID             string
+	Name           string
+	KubernetesMode string
+	Count          int32
+
+	// KubernetesVersion is left unset in the API response if empty
+	KubernetesVersion string
+
+	// ProviderConfig is returned as is in the API response if set
+	ProviderConfig map[string]interface{}
+
+	// Statuses are returned by successive observations of the pool; the last
+	// status is repeated forever
+	Statuses []string
+}
+
+type nodePool struct {
+	spec   NodePool
+	status *script
+}
+
+// script is a sequence of statuses that advances on every observation and
+// sticks on the final status
+type script struct {
+	statuses []string
+}
+
+func (s *script) next() string {
+	if len(s.statuses) == 0 {
+		return ""
+	}
+
+	status := s.statuses[0]
+	if len(s.statuses) > 1 {
+		s.statuses = s.statuses[1:]
+	}
+
+	return status
+}
+
+// HTTPError is returned by the fake for failed requests. It carries an HTTP
+// status code like the real client errors do.
+type HTTPError struct {
+	code    int
+	message string
+}
+
+func (e HTTPError) Error() string {
+	return fmt.Sprintf("%d: %s", e.code, e.message)
+}
+
+// Code returns the HTTP status code of the error
+func (e HTTPError) Code() int {
+	return e.code
+}
+
+// NewHTTPError returns an error carrying the given HTTP status code
+func NewHTTPError(code int, message string) HTTPError {
+	return HTTPError{
+		code:    code,
+		message: message,
+	}
+}
+
+func notFound(kind, id string) error {
+	return HTTPError{
+		code:    http.StatusNotFound,
+		message: fmt.Sprintf("%s %q not found", kind, id),
+	}
+}
+
+// New returns an empty fake clientset
+func New() *Clientset {
+	return &Clientset{
+		templates: make(map[string]interface{}),
+		clusters:  make(map[string]*script),
+		nodePools: make(map[string]map[string]*nodePool),
+
+		clusterLabels:    make(map[string]interface{}),
+		clusterCreatedAt: make(map[string]int64),
+		clusterTemplates: make(map[string]string),
+	}
+}
+
+// AddCluster adds a cluster with the given status script
+func (c *Clientset) AddCluster(clusterID string, statuses ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clusters[clusterID] = &script{statuses: statuses}
+}
+
+// SetClusterCreatedAt sets the creation timestamp reported for the given
+// cluster. Clusters created through CKEClusters().Create() are created now,
+// while clusters added through AddCluster have no creation timestamp unless
+// one is set.
+func (c *Clientset) SetClusterCreatedAt(clusterID string, createdAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clusterCreatedAt[clusterID] = createdAt.Unix()
+}
+
+// AddNodePool adds a node pool to the given cluster
+func (c *Clientset) AddNodePool(clusterID string, pool NodePool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nodePools[clusterID] == nil {
+		c.nodePools[clusterID] = make(map[string]*nodePool)
+	}
+
+	c.nodePools[clusterID][pool.ID] = &nodePool{
+		spec:   pool,
+		status: &script{statuses: pool.Statuses},
+	}
+}
+
+// CreatedTemplates returns all template create requests received
+func (c *Clientset) CreatedTemplates() []*types.CreateTemplateRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.createdTemplates
+}
+
+// CreatedClusters returns all cluster create requests received
+func (c *Clientset) CreatedClusters() []*types.CreateCKEClusterRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.createdClusters
+}
+
+// Provision returns the fake provision client
+func (c *Clientset) Provision() provision.Interface {
+	return &provisionClient{c: c}
+}
+
+type provisionClient struct {
+	provision.Interface
+
+	c *Clientset
+}
+
+func (p *provisionClient) Templates(organizationID string) provision.TemplateInterface {
+	return &templates{c: p.c}
+}
+
+func (p *provisionClient) CKEClusters(organizationID string) provision.CKEClusterInterface {
+	return &clusters{c: p.c}
+}
+
+func (p *provisionClient) NodePools(organizationID, clusterID string) provision.NodePoolInterface {
+	return &nodePools{c: p.c, clusterID: clusterID}
+}
+
+type templates struct {
+	provision.TemplateInterface
+
+	c *Clientset
+}
+
+func (t *templates) Create(req *types.CreateTemplateRequest) (*types.Template, error) {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+
+	t.c.createdTemplates = append(t.c.createdTemplates, req)
+
+	id := fmt.Sprintf("template-%d", len(t.c.createdTemplates))
+
+	var fields map[string]interface{}
+	if err := fromJSON(req, &fields); err != nil {
+		return nil, err
+	}
+	t.c.templates[id] = fields["description"]
+
+	return &types.Template{ID: types.UUID(id)}, nil
+}
+
+func (t *templates) List() ([]types.Template, error) {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+
+	if t.c.ListTemplatesError != nil {
+		return nil, t.c.ListTemplatesError
+	}
+
+	ids := make([]string, 0, len(t.c.templates))
+	for id := range t.c.templates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]types.Template, 0, len(ids))
+	for _, id := range ids {
+		fields := map[string]interface{}{
+			"id": id,
+		}
+		if description := t.c.templates[id]; description != nil {
+			fields["description"] = description
+		}
+
+		var template types.Template
+		if err := fromJSON(fields, &template); err != nil {
+			return nil, err
+		}
+		list = append(list, template)
+	}
+
+	return list, nil
+}
+
+func (t *templates) Delete(id string) error {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+
+	if _, ok := t.c.templates[id]; !ok {
+		return notFound("template", id)
+	}
+
+	delete(t.c.templates, id)
+	return nil
+}
+
+type clusters struct {
+	provision.CKEClusterInterface
+
+	c *Clientset
+}
+
+func (cl *clusters) Create(req *types.CreateCKEClusterRequest) (*types.CKECluster, error) {
+	cl.c.mu.Lock()
+	defer cl.c.mu.Unlock()
+
+	cl.c.createdClusters = append(cl.c.createdClusters, req)
+
+	statuses := cl.c.NewClusterStatuses
+	if len(statuses) == 0 {
+		statuses = []string{"RUNNING"}
+	}
+
+	id := fmt.Sprintf("cluster-%d", len(cl.c.createdClusters))
+	cl.c.clusters[id] = &script{statuses: statuses}
+
+	var fields map[string]interface{}
+	if err := fromJSON(req, &fields); err != nil {
+		return nil, err
+	}
+	if labels, ok := fields["labels"]; ok {
+		cl.c.clusterLabels[id] = labels
+	}
+	cl.c.clusterCreatedAt[id] = time.Now().Unix()
+	if req.TemplateID != "" {
+		cl.c.clusterTemplates[id] = string(req.TemplateID)
+	}
+
+	return &types.CKECluster{ID: types.UUID(id)}, nil
+}
+
+func (cl *clusters) Get(id string) (*types.CKECluster, error) {
+	cl.c.mu.Lock()
+	defer cl.c.mu.Unlock()
+
+	if _, ok := cl.c.clusters[id]; !ok {
+		return nil, notFound("cluster", id)
+	}
+
+	return cl.observe(id)
+}
+
+func (cl *clusters) List() ([]types.CKECluster, error) {
+	cl.c.mu.Lock()
+	defer cl.c.mu.Unlock()
+
+	ids := make([]string, 0, len(cl.c.clusters))
+	for id := range cl.c.clusters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]types.CKECluster, 0, len(ids))
+	for _, id := range ids {
+		cluster, err := cl.observe(id)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, *cluster)
+	}
+
+	return list, nil
+}
+
+// observe returns the given cluster, advancing its status script. The lock
+// must be held.
+func (cl *clusters) observe(id string) (*types.CKECluster, error) {
+	fields := map[string]interface{}{
+		"id": id,
+		"status": map[string]interface{}{
+			"type": cl.c.clusters[id].next(),
+		},
+	}
+	if labels, ok := cl.c.clusterLabels[id]; ok {
+		fields["labels"] = labels
+	}
+	if createdAt, ok := cl.c.clusterCreatedAt[id]; ok {
+		// Reported as a string of Unix seconds, as the API does
+		fields["created_at"] = strconv.FormatInt(createdAt, 10)
+	}
+	if templateID, ok := cl.c.clusterTemplates[id]; ok {
+		fields["template_id"] = templateID
+	}
+
+	cluster := &types.CKECluster{}
+	err := fromJSON(fields, cluster)
+
+	return cluster, err
+}
+
+func (cl *clusters) Delete(id string) error {
+	cl.c.mu.Lock()
+	defer cl.c.mu.Unlock()
+
+	if _, ok := cl.c.clusters[id]; !ok {
+		return notFound("cluster", id)
+	}
+
+	delete(cl.c.clusters, id)
+	delete(cl.c.clusterLabels, id)
+	delete(cl.c.clusterCreatedAt, id)
+	delete(cl.c.clusterTemplates, id)
+	delete(cl.c.nodePools, id)
+	return nil
+}
+
+type nodePools struct {
+	provision.NodePoolInterface
+
+	c         *Clientset
+	clusterID string
+}
+
+func (n *nodePools) Get(id string) (*types.NodePool, error) {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+
+	pool, ok := n.c.nodePools[n.clusterID][id]
+	if !ok {
+		return nil, notFound("node pool", id)
+	}
+
+	return pool.observe()
+}
+
+func (n *nodePools) List() ([]types.NodePool, error) {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+
+	if _, ok := n.c.clusters[n.clusterID]; !ok && len(n.c.nodePools[n.clusterID]) == 0 {
+		return nil, notFound("cluster", n.clusterID)
+	}
+
+	pools := make([]types.NodePool, 0, len(n.c.nodePools[n.clusterID]))
+	for _, pool := range n.c.nodePools[n.clusterID] {
+		p, err := pool.observe()
+		if err != nil {
+			return nil, err
+		}
+
+		pools = append(pools, *p)
+	}
+
+	return pools, nil
+}
+
+func (n *nodePools) Scale(id string, req *types.NodePoolScaleRequest) (*types.NodePool, error) {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+
+	pool, ok := n.c.nodePools[n.clusterID][id]
+	if !ok {
+		return nil, notFound("node pool", id)
+	}
+
+	pool.spec.Count = *req.Count
+
+	return pool.observe()
+}
+
+func (p *nodePool) observe() (*types.NodePool, error) {
+	fields := map[string]interface{}{
+		"id":              p.spec.ID,
+		"kubernetes_mode": p.spec.KubernetesMode,
+		"count":           p.spec.Count,
+		"status": map[string]interface{}{
+			"type": p.status.next(),
+		},
+	}
+	if p.spec.Name != "" {
+		fields["name"] = p.spec.Name
+	}
+	if p.spec.KubernetesVersion != "" {
+		fields["kubernetes_version"] = p.spec.KubernetesVersion
+	}
+	if p.spec.ProviderConfig != nil {
+		fields["provider_config"] = p.spec.ProviderConfig
+	}
+
+	pool := &types.NodePool{}
+	err := fromJSON(fields, pool)
+
+	return pool, err
+}
+
+// fromJSON fills in v from the given fields by round-tripping through JSON.
+// This avoids depending on the names of the generated nested types.
+func fromJSON(fields interface{}, v interface{}) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
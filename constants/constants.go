@@ -1,16 +1,185 @@
 package constants
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
-	// TODO this should be passed in
+	// TestOrganizationID is the organization to run against when neither
+	// -organization-id nor CONTAINERSHIP_ORGANIZATION_ID is set, see
+	// testcontext.ResolveOrganizationID
 	TestOrganizationID = "62e4e86f-fe2e-4740-a814-a950bf377daf"
+)
+
+// Environment is a Containership Cloud environment to run tests against
+type Environment string
+
+const (
+	// Stage is the default environment
+	Stage      Environment = "stage"
+	Production Environment = "production"
+)
+
+// EnvironmentConfig describes how to reach a Containership Cloud environment
+type EnvironmentConfig struct {
+	APIBaseURL       string
+	AuthBaseURL      string
+	ProvisionBaseURL string
+	ProxyBaseURL     string
+
+	// OrganizationID is the organization that tests run against by default,
+	// or empty if the environment has none and one must be given
+	OrganizationID string
+}
+
+// Environments are the known environments. Adding an environment only
+// requires adding it here.
+var Environments = map[Environment]EnvironmentConfig{
+	Stage: {
+		APIBaseURL:       "https://stage-api.containership.io",
+		AuthBaseURL:      "https://stage-auth.containership.io",
+		ProvisionBaseURL: "https://stage-provision.containership.io",
+		ProxyBaseURL:     "https://stage-proxy.containership.io",
+		OrganizationID:   TestOrganizationID,
+	},
+	Production: {
+		APIBaseURL:       "https://api.containership.io",
+		AuthBaseURL:      "https://auth.containership.io",
+		ProvisionBaseURL: "https://provision.containership.io",
+		ProxyBaseURL:     "https://proxy.containership.io",
+	},
+}
+
+// The per-environment URLs predate Environments and are kept so that
+// existing callers don't break.
+//
+// Deprecated: use ConfigForEnvironment instead.
+var (
+	StageAPIBaseURL       = Environments[Stage].APIBaseURL
+	StageAuthBaseURL      = Environments[Stage].AuthBaseURL
+	StageProvisionBaseURL = Environments[Stage].ProvisionBaseURL
+	StageProxyBaseURL     = Environments[Stage].ProxyBaseURL
+
+	ProductionAPIBaseURL       = Environments[Production].APIBaseURL
+	ProductionAuthBaseURL      = Environments[Production].AuthBaseURL
+	ProductionProvisionBaseURL = Environments[Production].ProvisionBaseURL
+	ProductionProxyBaseURL     = Environments[Production].ProxyBaseURL
+)
+
+// ConfigForEnvironment returns the configuration of the given environment,
+// or an error if the environment is unknown
+func ConfigForEnvironment(env Environment) (EnvironmentConfig, error) {
+	config, ok := Environments[env]
+	if !ok {
+		return EnvironmentConfig{}, errors.Errorf("unknown environment %q (must be one of %s)",
+			env, strings.Join(environmentNames(), ", "))
+	}
+
+	return config, nil
+}
+
+// URLsForEnvironment returns the API, auth, and provision base URLs for the
+// given environment, or an error if the environment is unknown
+func URLsForEnvironment(env Environment) (api, auth, provision string, err error) {
+	config, err := ConfigForEnvironment(env)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return config.APIBaseURL, config.AuthBaseURL, config.ProvisionBaseURL, nil
+}
+
+// ProxyBaseURLForEnvironment returns the Kubernetes API proxy base URL for
+// the given environment, or an error if the environment is unknown
+func ProxyBaseURLForEnvironment(env Environment) (string, error) {
+	config, err := ConfigForEnvironment(env)
+	if err != nil {
+		return "", err
+	}
+
+	return config.ProxyBaseURL, nil
+}
+
+// ClusterProxyURL returns the URL of the given cluster's Kubernetes API
+// through the proxy of the given environment, or an error if the environment
+// is unknown
+func ClusterProxyURL(env Environment, organizationID, clusterID string) (string, error) {
+	config, err := ConfigForEnvironment(env)
+	if err != nil {
+		return "", err
+	}
+
+	return ClusterProxyURLForBase(config.ProxyBaseURL, organizationID, clusterID), nil
+}
+
+// ClusterProxyURLForBase is the same as ClusterProxyURL but for the proxy at
+// the given base URL, e.g. a proxy base URL override
+func ClusterProxyURLForBase(proxyBaseURL, organizationID, clusterID string) string {
+	return fmt.Sprintf("%s/v3/organizations/%s/clusters/%s/k8sapi/proxy",
+		proxyBaseURL, organizationID, clusterID)
+}
+
+// environmentNames returns the quoted names of the known environments,
+// sorted so that error messages are stable
+func environmentNames() []string {
+	names := make([]string, 0, len(Environments))
+	for env := range Environments {
+		names = append(names, fmt.Sprintf("%q", env))
+	}
+	sort.Strings(names)
 
-	StageAPIBaseURL       = "https://stage-api.containership.io"
-	StageAuthBaseURL      = "https://stage-auth.containership.io"
-	StageProvisionBaseURL = "https://stage-provision.containership.io"
+	return names
+}
+
+const (
+	// ClusterIDLabelKey is the node label carrying the Containership cluster
+	// ID that the node belongs to
+	ClusterIDLabelKey = "containership.io/cluster-id"
+	// NodePoolIDLabelKey is the node label carrying the Containership node
+	// pool ID that the node belongs to. It is set by the Containership agent
+	// when the node is bootstrapped and is how Kubernetes nodes are mapped
+	// back to their cloud node pool.
+	NodePoolIDLabelKey = "containership.io/node-pool-id"
+
+	// MasterRoleLabelKey is the node label present on control plane nodes
+	MasterRoleLabelKey = "node-role.kubernetes.io/master"
+
+	// ZoneLabelKey is the well-known node label carrying the cloud zone the
+	// node is running in. It's only set by kubelets as of Kubernetes 1.17;
+	// older versions set BetaZoneLabelKey instead.
+	ZoneLabelKey     = "topology.kubernetes.io/zone"
+	BetaZoneLabelKey = "failure-domain.beta.kubernetes.io/zone"
+
+	// ClusterNameLabelKey is the cluster label holding the name the cluster
+	// is shown with in Containership Cloud
+	ClusterNameLabelKey = "cluster.containership.io/name"
+
+	// TestClusterLabelKey is the cluster label marking clusters created by
+	// these tests, so that clusters orphaned by failed runs can be found
+	TestClusterLabelKey   = "cluster.containership.io/e2e"
+	TestClusterLabelValue = "true"
+
+	// KeepClusterLabelKey is the cluster label marking test clusters that
+	// are kept on purpose, e.g. with -skip-teardown or for soak tests, so
+	// that they're never cleaned up as orphans
+	KeepClusterLabelKey   = "cluster.containership.io/e2e-keep"
+	KeepClusterLabelValue = "true"
+
+	// The Containership agents are configured via a configmap that includes
+	// the cluster ID
+	ClusterIDConfigMapNamespace = "containership-core"
+	ClusterIDConfigMapName      = "containership-env-configmap"
+	ClusterIDConfigMapKey       = "CONTAINERSHIP_CLOUD_CLUSTER_ID"
+
+	// The Containership agent deployment, whose image tag is the version of
+	// the agent running in the cluster
+	AgentDeploymentNamespace = "kube-system"
+	AgentDeploymentName      = "containership-agent"
 )
 
 const (
@@ -19,7 +188,77 @@ const (
 	DefaultPollInterval = 500 * time.Millisecond
 	DefaultTimeout      = 5 * time.Minute
 
+	// Provisioning a cluster takes a long time, so back off polling to avoid
+	// unnecessary API pressure while still noticing quick transitions early.
+	ProvisionInitialPollInterval = 1 * time.Second
+	ProvisionMaxPollInterval     = 30 * time.Second
+	ProvisionTimeout             = 20 * time.Minute
+
+	// Probing that the API server is reachable at all should be quick, and
+	// a probe that's only slow isn't treated as fatal
+	APIServerProbeTimeout = 5 * time.Second
+
+	// A kubeconfig written by a provision step running in parallel should be
+	// complete within seconds
+	KubeconfigLoadTimeout = 30 * time.Second
+
+	// The cluster ID may not be populated in Kubernetes for a few seconds
+	// after a cluster is provisioned
+	ClusterIDDiscoveryTimeout = 30 * time.Second
+
+	// Upgrading a node pool replaces its nodes one at a time
+	UpgradeTimeout = 30 * time.Minute
+
+	// A node can go NotReady and be replaced within a minute, so Ready nodes
+	// must be sampled often during an upgrade to catch a dip in availability
+	AvailabilitySampleInterval = 1 * time.Second
+
+	// The cluster-autoscaler has to notice pending pods and wait for the new
+	// node to boot before scaling up, and by default waits for a node to be
+	// unneeded for 10 minutes before scaling down
+	AutoscaleUpTimeout   = 15 * time.Minute
+	AutoscaleDownTimeout = 30 * time.Minute
+
+	// A suite with a deadline is aborted this long before it so that
+	// teardown has time to run before CI kills the process
+	DefaultSuiteDeadlineMargin = 5 * time.Minute
+
+	// Test clusters older than this are considered orphaned by the delete
+	// suite's -cleanup-orphans mode. It's well beyond the provision timeout
+	// so that clusters of runs still in progress aren't deleted.
+	DefaultOrphanedClusterAge = 2 * time.Hour
+
 	// A namespace delete can take a long time. This matches the equivalent
 	// Kubernetes e2e constant at the time of writing.
 	NamespaceDeleteTimeout = 15 * time.Minute
+
+	// Creating a template or cluster is retried on transient errors, since
+	// failing it fails the entire suite
+	DefaultCreateAttempts     = 3
+	DefaultCreateRetryBackoff = 5 * time.Second
+
+	// Provisioning many clusters at once puts a lot of load on the provision
+	// API, so a fleet is provisioned a few clusters at a time by default
+	DefaultMaxConcurrentProvisions = 3
 )
+
+// DefaultExpectedNamespaces are the namespaces that every Containership
+// cluster is bootstrapped with
+var DefaultExpectedNamespaces = []string{
+	"kube-system",
+	ClusterIDConfigMapNamespace,
+}
+
+// SupportedKubernetesVersions are the Kubernetes versions that may be
+// provisioned. The provision API doesn't expose this list, so it must be
+// kept up to date by hand as versions are added and removed.
+var SupportedKubernetesVersions = []string{
+	"1.12.9",
+	"1.12.10",
+	"1.13.7",
+	"1.13.8",
+	"1.14.3",
+	"1.14.4",
+	"1.15.0",
+	"1.15.1",
+}
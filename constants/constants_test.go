@@ -0,0 +1,95 @@
+package constants
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigForEnvironment(t *testing.T) {
+	for env := range Environments {
+		config, err := ConfigForEnvironment(env)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", env, err)
+			continue
+		}
+
+		for name, url := range map[string]string{
+			"API":       config.APIBaseURL,
+			"auth":      config.AuthBaseURL,
+			"provision": config.ProvisionBaseURL,
+			"proxy":     config.ProxyBaseURL,
+		} {
+			if !strings.HasPrefix(url, "https://") {
+				t.Errorf("%s: expected %s base URL to be https, got %q", env, name, url)
+			}
+		}
+	}
+
+	_, err := ConfigForEnvironment("dev")
+	if err == nil {
+		t.Fatal("expected error for unknown environment")
+	}
+	if !strings.Contains(err.Error(), `"production", "stage"`) {
+		t.Errorf("expected error to list known environments, got %v", err)
+	}
+}
+
+func TestClusterProxyURL(t *testing.T) {
+	tests := []struct {
+		env      Environment
+		expected string
+	}{
+		{Stage, "https://stage-proxy.containership.io/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"},
+		{Production, "https://proxy.containership.io/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"},
+	}
+
+	for _, test := range tests {
+		got, err := ClusterProxyURL(test.env, "org-id", "cluster-id")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.env, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.env, test.expected, got)
+		}
+	}
+
+	if _, err := ClusterProxyURL("dev", "org-id", "cluster-id"); err == nil {
+		t.Error("expected error for unknown environment")
+	}
+}
+
+func TestClusterProxyURLForBase(t *testing.T) {
+	tests := []struct {
+		proxyBaseURL string
+		expected     string
+	}{
+		{StageProxyBaseURL, "https://stage-proxy.containership.io/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"},
+		{ProductionProxyBaseURL, "https://proxy.containership.io/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"},
+	}
+
+	for _, test := range tests {
+		if got := ClusterProxyURLForBase(test.proxyBaseURL, "org-id", "cluster-id"); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.proxyBaseURL, test.expected, got)
+		}
+	}
+}
+
+func TestDeprecatedStageAliases(t *testing.T) {
+	api, auth, provision, err := URLsForEnvironment(Stage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if api != StageAPIBaseURL || auth != StageAuthBaseURL || provision != StageProvisionBaseURL {
+		t.Errorf("expected stage URLs to match the deprecated aliases, got %q, %q, %q", api, auth, provision)
+	}
+
+	proxy, err := ProxyBaseURLForEnvironment(Stage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proxy != StageProxyBaseURL {
+		t.Errorf("expected proxy URL %q, got %q", StageProxyBaseURL, proxy)
+	}
+}
@@ -0,0 +1,149 @@
+// Package debug captures the state of a cluster for post-mortem debugging of
+// a failed run, when the cluster may already be gone
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/onsi/ginkgo"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+)
+
+// maxSpecNameLength caps the part of a dump filename taken from the spec name
+// so that deeply nested specs don't exceed filesystem limits
+const maxSpecNameLength = 100
+
+// State is a snapshot of a cluster as written by DumpState
+type State struct {
+	Time           time.Time `json:"time"`
+	Spec           string    `json:"spec"`
+	OrganizationID string    `json:"organization_id"`
+	ClusterID      string    `json:"cluster_id"`
+
+	Cluster   *types.CKECluster `json:"cluster,omitempty"`
+	NodePools []types.NodePool  `json:"node_pools,omitempty"`
+	Nodes     []corev1.Node     `json:"nodes,omitempty"`
+
+	// Errors explains each part of the snapshot that couldn't be collected,
+	// keyed by the name of the part
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// DumpState writes the cluster, all of its node pools, and the Kubernetes
+// node list to a JSON file in dir named after the current time and the
+// running spec. Collection is best effort: a part that can't be fetched,
+// e.g. because the cluster was deleted, is recorded in the file's errors
+// rather than preventing the rest from being written. kubeClientset may be
+// nil if there is no Kubernetes access, in which case nodes are left out.
+func DumpState(cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID, dir string) error {
+	spec := ginkgo.CurrentGinkgoTestDescription().FullTestText
+	filename, err := dumpState(cs, kubeClientset, organizationID, clusterID, dir, spec, time.Now())
+	if err != nil {
+		return err
+	}
+
+	log.Info("dumped cluster state", "file", filename)
+	return nil
+}
+
+// dumpState is DumpState with the spec name and time given so that the file
+// written is predictable. It returns the name of the file written.
+func dumpState(cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID, dir, spec string, now time.Time) (string, error) {
+	state := collectState(cs, kubeClientset, organizationID, clusterID)
+	state.Time = now.UTC()
+	state.Spec = spec
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling cluster state")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "creating state dump directory")
+	}
+
+	filename := filepath.Join(dir, dumpFilename(spec, now))
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return "", errors.Wrap(err, "writing state dump")
+	}
+
+	return filename, nil
+}
+
+func collectState(cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID string) State {
+	state := State{
+		OrganizationID: organizationID,
+		ClusterID:      clusterID,
+		Errors:         make(map[string]string),
+	}
+
+	cluster, err := cs.Provision().
+		CKEClusters(organizationID).
+		Get(clusterID)
+	if err != nil {
+		state.Errors["cluster"] = err.Error()
+	} else {
+		state.Cluster = cluster
+	}
+
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		state.Errors["node_pools"] = err.Error()
+	} else {
+		state.NodePools = pools
+	}
+
+	if kubeClientset == nil {
+		state.Errors["nodes"] = "no Kubernetes clientset"
+	} else {
+		nodeList, err := kubeClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		if err != nil {
+			state.Errors["nodes"] = err.Error()
+		} else {
+			state.Nodes = nodeList.Items
+		}
+	}
+
+	if len(state.Errors) == 0 {
+		state.Errors = nil
+	}
+
+	return state
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// dumpFilename returns a filename that sorts by time and names the spec,
+// e.g. 20190823T141503.123Z-Scaling-a-node-pool-should-scale-up.json
+func dumpFilename(spec string, now time.Time) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(spec, "-"), "-.")
+	if len(name) > maxSpecNameLength {
+		name = strings.TrimRight(name[:maxSpecNameLength], "-.")
+	}
+	if name == "" {
+		name = "no-spec"
+	}
+
+	return fmt.Sprintf("%s-%s.json", now.UTC().Format("20060102T150405.000Z"), name)
+}
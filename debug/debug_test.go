@@ -0,0 +1,105 @@
+package debug
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestDumpFilename(t *testing.T) {
+	now := time.Date(2019, 8, 23, 14, 15, 3, 123000000, time.UTC)
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"Scaling a node pool should scale up", "20190823T141503.123Z-Scaling-a-node-pool-should-scale-up.json"},
+		{`Draining "node/a" [slow]`, "20190823T141503.123Z-Draining-node-a-slow.json"},
+		{"", "20190823T141503.123Z-no-spec.json"},
+		{strings.Repeat("a", 150), "20190823T141503.123Z-" + strings.Repeat("a", maxSpecNameLength) + ".json"},
+	}
+
+	for _, test := range tests {
+		if got := dumpFilename(test.spec, now); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.spec, test.want, got)
+		}
+	}
+}
+
+func TestDumpState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debug-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cs := cloudfake.New()
+	cs.AddCluster("cluster", "RUNNING")
+	cs.AddNodePool("cluster", cloudfake.NodePool{ID: "pool", KubernetesMode: "worker", Count: 1, Statuses: []string{"RUNNING"}})
+	kube := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+	})
+
+	now := time.Now()
+	filename, err := dumpState(cs, kube, "org", "cluster", filepath.Join(dir, "dumps"), "spec", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, "dumps", dumpFilename("spec", now)); filename != want {
+		t.Errorf("expected file %q, got %q", want, filename)
+	}
+
+	state := readState(t, filename)
+	if state.Spec != "spec" || state.ClusterID != "cluster" || state.OrganizationID != "org" {
+		t.Errorf("expected spec and IDs to be recorded, got %+v", state)
+	}
+	if state.Cluster == nil || state.Cluster.ID != "cluster" {
+		t.Errorf("expected cluster to be dumped, got %v", state.Cluster)
+	}
+	if len(state.NodePools) != 1 || state.NodePools[0].ID != "pool" {
+		t.Errorf("expected node pool to be dumped, got %v", state.NodePools)
+	}
+	if len(state.Nodes) != 1 || state.Nodes[0].Name != "node" {
+		t.Errorf("expected node to be dumped, got %v", state.Nodes)
+	}
+	if len(state.Errors) != 0 {
+		t.Errorf("expected no errors, got %v", state.Errors)
+	}
+
+	// A deleted cluster and no Kubernetes access still produce a dump
+	filename, err = dumpState(cs, nil, "org", "missing", dir, "missing", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	state = readState(t, filename)
+	for _, part := range []string{"cluster", "node_pools", "nodes"} {
+		if state.Errors[part] == "" {
+			t.Errorf("expected error for %s, got %v", part, state.Errors)
+		}
+	}
+}
+
+func readState(t *testing.T, filename string) State {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("reading dump: %v", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("unmarshalling dump: %v", err)
+	}
+
+	return state
+}
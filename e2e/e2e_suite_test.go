@@ -1,8 +1,7 @@
 package e2e_test
 
 import (
-	"fmt"
-	"os"
+	"flag"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -10,43 +9,73 @@ import (
 
 	"github.com/containership/csctl/cloud"
 
-	"github.com/mattkelly/containership-test-v2-experiment/context"
-	provisiontests "github.com/mattkelly/containership-test-v2-experiment/tests/provision"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
 )
 
-var testContext *context.TestContextDef
+var testContext *testcontext.E2eTest
+
+// Flags
+var (
+	logFormat string
+
+	junitOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+}
 
 func TestIntegration(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
 	// Hook up gomega to ginkgo
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "E2E Suite")
+	reporting.RunSpecs(t, "E2E Suite", junitOutputDir)
 }
 
 var _ = SynchronizedBeforeSuite(func() []byte {
 	// Run only on first node
-	token := os.Getenv("CONTAINERSHIP_TOKEN")
-	if token == "" {
-		// TODO logging?
-		fmt.Println("please specify a Containership Cloud token via CONTAINERSHIP_TOKEN env var")
-	}
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
+
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
+	Expect(err).NotTo(HaveOccurred())
 
 	clientset, err := cloud.New(cloud.Config{
 		Token:            token,
-		APIBaseURL:       "https://stage-api.containership.io",
-		AuthBaseURL:      "https://stage-auth.containership.io",
-		ProvisionBaseURL: "https://stage-provision.containership.io",
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	testContext = &context.TestContextDef{
-		Clientset:      clientset,
-		OrganizationID: testOrganizationID,
+	testContext = &testcontext.E2eTest{
+		ContainershipClientset: clientset,
+		AuthToken:              token,
+		OrganizationID:         orgID,
 	}
 
 	return nil
 }, func(_ []byte) {
 	// Run on all nodes after first node
-	provisiontests.TestContext = testContext
 })
 
 var _ = SynchronizedAfterSuite(func() {
@@ -0,0 +1,178 @@
+// Package log provides a minimal leveled, structured logger for progress
+// output from the suites. Output goes to the GinkgoWriter so that it is
+// interleaved correctly with ginkgo's own output.
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/pkg/errors"
+)
+
+// Logger is a leveled, structured logger. keysAndValues are alternating keys
+// and values, e.g. Info("created cluster", "id", clusterID).
+type Logger interface {
+	Info(msg string, keysAndValues ...interface{})
+	Error(err error, msg string, keysAndValues ...interface{})
+}
+
+const (
+	// FormatText logs human-readable lines
+	FormatText = "text"
+	// FormatJSON logs one JSON object per line for CI ingestion
+	FormatJSON = "json"
+)
+
+var (
+	mu     sync.Mutex
+	logger Logger = NewTextLogger(ginkgo.GinkgoWriter)
+)
+
+// SetLogger replaces the global logger
+func SetLogger(l Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	logger = l
+}
+
+// SetFormat replaces the global logger with one of the builtin loggers
+// writing to the GinkgoWriter in the given format
+func SetFormat(format string) error {
+	switch format {
+	case FormatText:
+		SetLogger(NewTextLogger(ginkgo.GinkgoWriter))
+	case FormatJSON:
+		SetLogger(NewJSONLogger(ginkgo.GinkgoWriter))
+	default:
+		return errors.Errorf("unknown log format %q (must be %q or %q)", format, FormatText, FormatJSON)
+	}
+
+	return nil
+}
+
+func current() Logger {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return logger
+}
+
+// Info logs an informational message using the global logger
+func Info(msg string, keysAndValues ...interface{}) {
+	current().Info(msg, keysAndValues...)
+}
+
+// Error logs an error using the global logger
+func Error(err error, msg string, keysAndValues ...interface{}) {
+	current().Error(err, msg, keysAndValues...)
+}
+
+// By logs the step using the global logger and then calls ginkgo's By so that
+// steps are reported consistently with all other output
+func By(text string, callbacks ...func()) {
+	Info(text, "step", true)
+	ginkgo.By(text, callbacks...)
+}
+
+type writerLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format func(level, msg string, err error, fields map[string]interface{}) string
+}
+
+func (l *writerLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log("info", msg, nil, keysAndValues)
+}
+
+func (l *writerLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, err, keysAndValues)
+}
+
+func (l *writerLogger) log(level, msg string, err error, keysAndValues []interface{}) {
+	line := l.format(level, msg, err, toFields(keysAndValues))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(l.w, line)
+}
+
+// NewTextLogger returns a Logger writing human-readable lines to w
+func NewTextLogger(w io.Writer) Logger {
+	return &writerLogger{w: w, format: formatText}
+}
+
+// NewJSONLogger returns a Logger writing one JSON object per line to w
+func NewJSONLogger(w io.Writer) Logger {
+	return &writerLogger{w: w, format: formatJSON}
+}
+
+func formatText(level, msg string, err error, fields map[string]interface{}) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", time.Now().UTC().Format(time.RFC3339), strings.ToUpper(level), msg)
+
+	if err != nil {
+		fmt.Fprintf(&b, " error=%q", err.Error())
+	}
+
+	// Sort for stable output
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+
+	return b.String()
+}
+
+func formatJSON(level, msg string, err error, fields map[string]interface{}) string {
+	entry := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		entry[k] = v
+	}
+
+	entry["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+	if err != nil {
+		entry["error"] = err.Error()
+	}
+
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		// Fall back to text rather than losing the message
+		return formatText(level, msg, err, fields)
+	}
+
+	return string(data)
+}
+
+// toFields converts alternating keys and values into a map. A trailing key
+// without a value is recorded with a nil value.
+func toFields(keysAndValues []interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+
+		var value interface{}
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+
+		fields[key] = value
+	}
+
+	return fields
+}
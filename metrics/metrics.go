@@ -0,0 +1,126 @@
+// Package metrics records how long named operations take, e.g. provisioning
+// a cluster or scaling a node pool, so that regressions can be tracked across
+// runs. Timings are reported at the end of a suite as a summary table and,
+// optionally, as a JSON file or to a Prometheus Pushgateway.
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Timing is a single recorded operation
+type Timing struct {
+	Name            string    `json:"name"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+
+	// Error is the error returned by the operation, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Duration returns how long the operation took
+func (t Timing) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
+
+// report is the top-level object written to the JSON output file
+type report struct {
+	Timings []Timing `json:"timings"`
+}
+
+var (
+	mu      sync.Mutex
+	timings []Timing
+)
+
+// Time runs fn and records how long it took under the given name, returning
+// the error from fn. Failed operations are recorded as well.
+func Time(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	end := time.Now()
+
+	t := Timing{
+		Name:            name,
+		Start:           start,
+		End:             end,
+		DurationSeconds: end.Sub(start).Seconds(),
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	timings = append(timings, t)
+
+	return err
+}
+
+// Timings returns all timings recorded so far in the order they completed
+func Timings() []Timing {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]Timing(nil), timings...)
+}
+
+// Reset discards all recorded timings
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	timings = nil
+}
+
+// WriteSummary writes a human-readable table of all recorded timings to w
+func WriteSummary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "OPERATION\tDURATION\tRESULT")
+	for _, t := range Timings() {
+		result := "ok"
+		if t.Error != "" {
+			result = "error"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, t.Duration().Round(time.Second), result)
+	}
+
+	return errors.Wrap(tw.Flush(), "writing timing summary")
+}
+
+// WriteJSON writes all recorded timings to the given file as JSON
+func WriteJSON(filename string) error {
+	data, err := json.MarshalIndent(report{Timings: Timings()}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling timings")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(filename, data, 0644), "writing timing output file")
+}
+
+// Report writes the summary table to w and, if filename is not empty, writes
+// the timings to filename as JSON. It is intended to be called once at the
+// end of a suite.
+func Report(w io.Writer, filename string) error {
+	if err := WriteSummary(w); err != nil {
+		return err
+	}
+
+	if filename == "" {
+		return nil
+	}
+
+	return WriteJSON(filename)
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestTime(t *testing.T) {
+	Reset()
+
+	if err := Time("succeeds", func() error { return nil }); err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+
+	fnErr := errors.New("failed")
+	if err := Time("fails", func() error { return fnErr }); err != fnErr {
+		t.Errorf("expected error from fn to be returned, got %v", err)
+	}
+
+	got := Timings()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 timings, got %d", len(got))
+	}
+
+	if got[0].Name != "succeeds" || got[0].Error != "" {
+		t.Errorf("unexpected first timing: %+v", got[0])
+	}
+	if got[1].Name != "fails" || got[1].Error != "failed" {
+		t.Errorf("unexpected second timing: %+v", got[1])
+	}
+	if got[0].End.Before(got[0].Start) {
+		t.Errorf("end %s is before start %s", got[0].End, got[0].Start)
+	}
+}
+
+func TestReport(t *testing.T) {
+	Reset()
+	_ = Time("cluster-provision", func() error { return nil })
+	_ = Time("nodepool-scale-up", func() error { return errors.New("timed out") })
+
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "timings.json")
+
+	var buf bytes.Buffer
+	if err := Report(&buf, filename); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	summary := buf.String()
+	for _, want := range []string{"OPERATION", "cluster-provision", "nodepool-scale-up", "error"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected summary to contain %q, got:\n%s", want, summary)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("unmarshalling timing output: %v", err)
+	}
+	if len(r.Timings) != 2 || r.Timings[1].Error != "timed out" {
+		t.Errorf("unexpected timings in output file: %+v", r.Timings)
+	}
+	if r.Timings[0].Start.IsZero() || r.Timings[0].End.IsZero() {
+		t.Errorf("expected start and end timestamps, got %+v", r.Timings[0])
+	}
+}
+
+func TestReportWithoutFile(t *testing.T) {
+	Reset()
+
+	var buf bytes.Buffer
+	if err := Report(&buf, ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
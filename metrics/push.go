@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DurationMetricName is the gauge holding the duration of the most
+	// recent run of each operation
+	DurationMetricName = "containership_e2e_operation_duration_seconds"
+
+	// FailuresMetricName is the gauge holding the number of failed runs of
+	// each operation
+	FailuresMetricName = "containership_e2e_operation_failures"
+)
+
+// pushTimeout bounds how long a push may take so that an unresponsive
+// Pushgateway can't hold up the end of a suite
+const pushTimeout = 10 * time.Second
+
+// Push replaces the metrics of the given job and environment in the
+// Prometheus Pushgateway at gatewayURL with the timings recorded so far. Each
+// operation is labeled with its name and the environment is part of the
+// grouping key, which the Pushgateway adds as a label to every metric.
+// Repeated runs of a soak test overwrite the previous values rather than
+// accumulating.
+func Push(gatewayURL, job, environment string) error {
+	if job == "" {
+		return errors.New("job must not be empty")
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(gatewayURL, "/"))
+	if err != nil {
+		return errors.Wrapf(err, "parsing Pushgateway URL %q", gatewayURL)
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return errors.Errorf("Pushgateway URL %q must be absolute", gatewayURL)
+	}
+
+	path := fmt.Sprintf("/metrics/job/%s", url.PathEscape(job))
+	if environment != "" {
+		path += fmt.Sprintf("/environment/%s", url.PathEscape(environment))
+	}
+
+	req, err := http.NewRequest(http.MethodPut, base.String()+path, bytes.NewReader(exposition(Timings())))
+	if err != nil {
+		return errors.Wrap(err, "building Pushgateway request")
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "pushing timings to Pushgateway")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("pushing timings to Pushgateway: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// exposition renders timings in the Prometheus text exposition format. Only
+// the most recent duration of each operation is kept since a gauge can only
+// hold a single value per label set.
+func exposition(timings []Timing) []byte {
+	durations := make(map[string]float64)
+	failures := make(map[string]int)
+	for _, t := range timings {
+		durations[t.Name] = t.DurationSeconds
+		if t.Error != "" {
+			failures[t.Name]++
+		}
+	}
+
+	names := make([]string, 0, len(durations))
+	for name := range durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", DurationMetricName)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s{operation=\"%s\"} %g\n", DurationMetricName, labelValueEscaper.Replace(name), durations[name])
+	}
+
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", FailuresMetricName)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s{operation=\"%s\"} %d\n", FailuresMetricName, labelValueEscaper.Replace(name), failures[name])
+	}
+
+	return buf.Bytes()
+}
+
+// labelValueEscaper escapes the characters that must be escaped in a label
+// value of the text exposition format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
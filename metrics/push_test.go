@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestPush(t *testing.T) {
+	Reset()
+	_ = Time("nodepool-scale-up", func() error { return errors.New("timed out") })
+	_ = Time("nodepool-scale-up", func() error { return nil })
+	_ = Time(`odd "name"`, func() error { return nil })
+
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.EscapedPath(), string(data)
+	}))
+	defer server.Close()
+
+	if err := Push(server.URL+"/", "scale", "stage"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("expected PUT, got %s", method)
+	}
+	if want := "/metrics/job/scale/environment/stage"; path != want {
+		t.Errorf("expected path %q, got %q", want, path)
+	}
+
+	for _, want := range []string{
+		"# TYPE " + DurationMetricName + " gauge\n",
+		DurationMetricName + `{operation="nodepool-scale-up"} `,
+		DurationMetricName + `{operation="odd \"name\""} `,
+		FailuresMetricName + `{operation="nodepool-scale-up"} 1` + "\n",
+		FailuresMetricName + `{operation="odd \"name\""} 0` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected body to contain %q, got:\n%s", want, body)
+		}
+	}
+	if n := strings.Count(body, DurationMetricName+`{operation="nodepool-scale-up"}`); n != 1 {
+		t.Errorf("expected one sample per operation, got %d:\n%s", n, body)
+	}
+}
+
+func TestPushErrors(t *testing.T) {
+	Reset()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := Push(server.URL, "scale", "stage"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected error for rejected push, got %v", err)
+	}
+	if err := Push("localhost:9091", "scale", "stage"); err == nil {
+		t.Error("expected error for relative URL")
+	}
+	if err := Push(server.URL, "", "stage"); err == nil {
+		t.Error("expected error for empty job")
+	}
+}
@@ -0,0 +1,83 @@
+package provision
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// AssertControlPlaneNodeCount returns an error if the number of Ready
+// Kubernetes nodes carrying the control plane role label in the cluster's
+// master node pools doesn't match the count configured for those pools. This
+// catches an HA control plane coming up with fewer masters than requested.
+func AssertControlPlaneNodeCount(cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID string) error {
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		return errors.Wrap(err, "listing node pools")
+	}
+
+	nodeList, err := kubeClientset.CoreV1().
+		Nodes().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing nodes")
+	}
+
+	return controlPlaneNodeCountMismatch(pools, nodeList.Items)
+}
+
+func controlPlaneNodeCountMismatch(pools []types.NodePool, nodes []corev1.Node) error {
+	expected := 0
+	observed := 0
+	var notCounted []string
+	for _, pool := range pools {
+		if *pool.KubernetesMode != "master" {
+			continue
+		}
+
+		if pool.Count != nil {
+			expected += int(*pool.Count)
+		}
+
+		for _, node := range util.FilterNodesByPool(nodes, string(pool.ID)) {
+			_, hasRole := node.Labels[constants.MasterRoleLabelKey]
+			switch {
+			case !hasRole:
+				notCounted = append(notCounted, node.Name+" (missing control plane role label)")
+			case !util.IsNodeReady(node):
+				notCounted = append(notCounted, node.Name+" (not Ready)")
+			default:
+				observed++
+			}
+		}
+	}
+
+	if expected == 0 {
+		return errors.New("cluster has no control plane nodes configured")
+	}
+
+	if observed == expected {
+		return nil
+	}
+
+	if len(notCounted) == 0 {
+		return errors.Errorf("expected %d Ready control plane nodes, observed %d", expected, observed)
+	}
+
+	sort.Strings(notCounted)
+	return errors.Errorf("expected %d Ready control plane nodes, observed %d; not counted: %s",
+		expected, observed, strings.Join(notCounted, ", "))
+}
@@ -0,0 +1,76 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func controlPlaneNode(name, poolID string, master, ready bool) *corev1.Node {
+	labels := map[string]string{
+		constants.NodePoolIDLabelKey: poolID,
+	}
+	if master {
+		labels[constants.MasterRoleLabelKey] = ""
+	}
+
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: status},
+			},
+		},
+	}
+}
+
+func TestAssertControlPlaneNodeCount(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("cluster", "RUNNING")
+	cs.AddNodePool("cluster", cloudfake.NodePool{ID: "master", KubernetesMode: "master", Count: 3})
+	cs.AddNodePool("cluster", cloudfake.NodePool{ID: "worker", KubernetesMode: "worker", Count: 2})
+
+	kube := fake.NewSimpleClientset(
+		controlPlaneNode("master-0", "master", true, true),
+		controlPlaneNode("master-1", "master", true, true),
+		controlPlaneNode("worker-0", "worker", false, true),
+		controlPlaneNode("worker-1", "worker", false, true),
+	)
+
+	err := AssertControlPlaneNodeCount(cs, kube, "org", "cluster")
+	if err == nil || !strings.Contains(err.Error(), "expected 3 Ready control plane nodes, observed 2") {
+		t.Errorf("expected error reporting expected and observed counts, got %v", err)
+	}
+
+	if _, err := kube.CoreV1().Nodes().Create(controlPlaneNode("master-2", "master", true, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = AssertControlPlaneNodeCount(cs, kube, "org", "cluster")
+	if err == nil || !strings.Contains(err.Error(), "master-2 (not Ready)") {
+		t.Errorf("expected error naming the unready master, got %v", err)
+	}
+
+	kube = fake.NewSimpleClientset(
+		controlPlaneNode("master-0", "master", true, true),
+		controlPlaneNode("master-1", "master", true, true),
+		controlPlaneNode("master-2", "master", true, true),
+	)
+	if err := AssertControlPlaneNodeCount(cs, kube, "org", "cluster"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package provision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+)
+
+// maxSummarizedEvents is the number of most recent events included in an
+// error by withClusterEvents
+const maxSummarizedEvents = 5
+
+// ClusterEvent is a provisioning event for a cluster
+type ClusterEvent struct {
+	Time    time.Time
+	Message string
+}
+
+// ClusterEventLister is implemented by cloud clientsets that can list the
+// provisioning events of a cluster. The csctl client doesn't expose events,
+// so this allows a clientset that does to be plugged in.
+type ClusterEventLister interface {
+	ClusterEvents(organizationID, clusterID string) ([]ClusterEvent, error)
+}
+
+// ErrClusterEventsUnavailable is returned by GetClusterEvents when the
+// clientset can't list cluster events
+var ErrClusterEventsUnavailable = errors.New("cluster events are not available from this client")
+
+// GetClusterEvents returns the provisioning events of the given cluster,
+// oldest first. ErrClusterEventsUnavailable is returned if the clientset
+// doesn't implement ClusterEventLister.
+func GetClusterEvents(cs cloud.Interface, organizationID, clusterID string) ([]ClusterEvent, error) {
+	lister, ok := cs.(ClusterEventLister)
+	if !ok {
+		return nil, ErrClusterEventsUnavailable
+	}
+
+	events, err := lister.ClusterEvents(organizationID, clusterID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing events for cluster %q", clusterID)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	return events, nil
+}
+
+// withClusterEvents appends a summary of the cluster's most recent events to
+// err so that a failed provision is actionable without the dashboard. If the
+// events can't be retrieved, err is returned as is.
+func withClusterEvents(err error, cs cloud.Interface, organizationID, clusterID string) error {
+	events, eventsErr := GetClusterEvents(cs, organizationID, clusterID)
+	if eventsErr != nil || len(events) == 0 {
+		return err
+	}
+
+	return errors.Errorf("%v; recent events:\n%s", err, summarizeClusterEvents(events))
+}
+
+// summarizeClusterEvents returns the most recent events, one per line
+func summarizeClusterEvents(events []ClusterEvent) string {
+	if len(events) > maxSummarizedEvents {
+		events = events[len(events)-maxSummarizedEvents:]
+	}
+
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("  %s %s", event.Time.UTC().Format(time.RFC3339), event.Message))
+	}
+
+	return strings.Join(lines, "\n")
+}
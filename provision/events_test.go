@@ -0,0 +1,98 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+// eventsClientset is a fake clientset that can list cluster events
+type eventsClientset struct {
+	*cloudfake.Clientset
+
+	events map[string][]ClusterEvent
+}
+
+func (c *eventsClientset) ClusterEvents(organizationID, clusterID string) ([]ClusterEvent, error) {
+	events, ok := c.events[clusterID]
+	if !ok {
+		return nil, errors.Errorf("cluster %q not found", clusterID)
+	}
+
+	return events, nil
+}
+
+func TestGetClusterEvents(t *testing.T) {
+	if _, err := GetClusterEvents(cloudfake.New(), "fake-org-id", "cluster"); err != ErrClusterEventsUnavailable {
+		t.Errorf("expected %v, got %v", ErrClusterEventsUnavailable, err)
+	}
+
+	start := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	cs := &eventsClientset{
+		Clientset: cloudfake.New(),
+		events: map[string][]ClusterEvent{
+			"cluster": {
+				{Time: start.Add(time.Minute), Message: "second"},
+				{Time: start, Message: "first"},
+			},
+		},
+	}
+
+	events, err := GetClusterEvents(cs, "fake-org-id", "cluster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 || events[0].Message != "first" || events[1].Message != "second" {
+		t.Errorf("expected events oldest first, got %v", events)
+	}
+
+	if _, err := GetClusterEvents(cs, "fake-org-id", "missing"); err == nil {
+		t.Error("expected error for missing cluster")
+	}
+}
+
+func TestWaitForClusterRunningIncludesEvents(t *testing.T) {
+	start := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	var events []ClusterEvent
+	for i := 0; i < maxSummarizedEvents+2; i++ {
+		events = append(events, ClusterEvent{
+			Time:    start.Add(time.Duration(i) * time.Minute),
+			Message: fmt.Sprintf("event %d", i),
+		})
+	}
+	events[len(events)-1].Message = "droplet quota exceeded"
+
+	cs := &eventsClientset{
+		Clientset: cloudfake.New(),
+		events: map[string][]ClusterEvent{
+			"errors": events,
+		},
+	}
+	cs.AddCluster("errors", "ERROR")
+
+	err := WaitForClusterRunning(cs, "fake-org-id", "errors")
+	if err == nil {
+		t.Fatal("expected error for cluster entering unexpected state")
+	}
+	if !strings.Contains(err.Error(), "ERROR") || !strings.Contains(err.Error(), "droplet quota exceeded") {
+		t.Errorf("expected error to include status and most recent event, got %v", err)
+	}
+	if strings.Contains(err.Error(), "event 0") {
+		t.Errorf("expected only the %d most recent events, got %v", maxSummarizedEvents, err)
+	}
+
+	// Without events, only the status is reported
+	plain := cloudfake.New()
+	plain.AddCluster("errors", "ERROR")
+
+	err = WaitForClusterRunning(plain, "fake-org-id", "errors")
+	if err == nil || !strings.Contains(err.Error(), "ERROR") {
+		t.Errorf("expected error including status, got %v", err)
+	}
+}
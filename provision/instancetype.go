@@ -0,0 +1,99 @@
+package provision
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// instanceTypeKeys are the provider config keys that hold the instance type,
+// which every provider names differently (e.g. DigitalOcean calls it size)
+var instanceTypeKeys = []string{"size", "instance_type", "machine_type", "vm_size"}
+
+// RequestedInstanceTypes returns the instance type requested for each node
+// pool in the template request, keyed by node pool name. Pools whose
+// resource doesn't specify an instance type are omitted.
+func RequestedInstanceTypes(req *types.CreateTemplateRequest) (map[string]string, error) {
+	// The resources are provider-specific, so the generated type leaves them
+	// untyped
+	var resourceTypes map[string]map[string]map[string]interface{}
+	if err := util.RoundTripJSON(req.Configuration.Resource, &resourceTypes); err != nil {
+		return nil, errors.Wrap(err, "reading template resources")
+	}
+
+	instanceTypes := make(map[string]string)
+	for _, resources := range resourceTypes {
+		for key, resource := range resources {
+			instanceType := instanceTypeFrom(resource)
+			if instanceType == "" {
+				continue
+			}
+
+			// The resource and variable share a key, but the node pool is
+			// identified by its name once provisioned
+			variable, ok := req.Configuration.Variable[key]
+			if !ok || variable.Default.Name == nil || *variable.Default.Name == "" {
+				return nil, errors.Errorf("template resource %q has no matching node pool variable", key)
+			}
+
+			instanceTypes[*variable.Default.Name] = instanceType
+		}
+	}
+
+	return instanceTypes, nil
+}
+
+// NodePoolName returns the name of the given node pool, or "" if it isn't set
+func NodePoolName(pool types.NodePool) string {
+	if pool.Name == nil {
+		return ""
+	}
+
+	return *pool.Name
+}
+
+// AssertNodePoolInstanceType returns an error if the given node pool wasn't
+// provisioned with the wanted instance type. This catches the API
+// substituting a different type, e.g. due to capacity.
+func AssertNodePoolInstanceType(cs cloud.Interface, organizationID, clusterID, nodePoolID, want string) error {
+	pool, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		Get(nodePoolID)
+	if err != nil {
+		return errors.Wrapf(err, "GETing node pool %q", nodePoolID)
+	}
+
+	// types.NodePool has no ProviderConfig field
+	var fields struct {
+		ProviderConfig map[string]interface{} `json:"provider_config"`
+	}
+	if err := util.RoundTripJSON(pool, &fields); err != nil {
+		return errors.Wrapf(err, "reading node pool %q", nodePoolID)
+	}
+
+	actual := instanceTypeFrom(fields.ProviderConfig)
+	if actual == "" {
+		return errors.Errorf("node pool %q does not report an instance type, expected %q", nodePoolID, want)
+	}
+
+	if actual != want {
+		return errors.Errorf("node pool %q was provisioned with instance type %q, expected %q", nodePoolID, actual, want)
+	}
+
+	return nil
+}
+
+// instanceTypeFrom returns the instance type in the given provider config,
+// or "" if there isn't one
+func instanceTypeFrom(config map[string]interface{}) string {
+	for _, key := range instanceTypeKeys {
+		if instanceType, ok := config[key].(string); ok && instanceType != "" {
+			return instanceType
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,68 @@
+package provision
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestRequestedInstanceTypes(t *testing.T) {
+	req, err := readCreateTemplateRequestFromFile("testdata/template.tmpl.yaml", TemplateValues{
+		InstanceSize: "s-2vcpu-2gb",
+		Extra:        map[string]string{"region": "sfo2"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error reading template: %v", err)
+	}
+
+	got, err := RequestedInstanceTypes(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{"worker-pool-0": "s-2vcpu-2gb"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected instance types %v, got %v", want, got)
+	}
+}
+
+func TestAssertNodePoolInstanceType(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("cluster", "RUNNING")
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:             "pool",
+		Name:           "worker-pool-0",
+		KubernetesMode: "worker",
+		ProviderConfig: map[string]interface{}{"size": "s-2vcpu-2gb"},
+		Statuses:       []string{"RUNNING"},
+	})
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:             "unreported",
+		KubernetesMode: "worker",
+		Statuses:       []string{"RUNNING"},
+	})
+
+	if err := AssertNodePoolInstanceType(cs, "org", "cluster", "pool", "s-2vcpu-2gb"); err != nil {
+		t.Errorf("expected matching instance type to pass, got %v", err)
+	}
+
+	err := AssertNodePoolInstanceType(cs, "org", "cluster", "pool", "s-4vcpu-8gb")
+	if err == nil {
+		t.Fatal("expected error for substituted instance type")
+	}
+	for _, want := range []string{"pool", "s-2vcpu-2gb", "s-4vcpu-8gb"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got %v", want, err)
+		}
+	}
+
+	if err := AssertNodePoolInstanceType(cs, "org", "cluster", "unreported", "s-2vcpu-2gb"); err == nil {
+		t.Error("expected error for node pool not reporting an instance type")
+	}
+
+	if err := AssertNodePoolInstanceType(cs, "org", "cluster", "missing", "s-2vcpu-2gb"); err == nil {
+		t.Error("expected error for missing node pool")
+	}
+}
@@ -0,0 +1,262 @@
+package provision
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+const (
+	kubeconfigClusterName = "cs-e2e-test-cluster"
+	kubeconfigUserName    = "cs-e2e-test-user"
+	kubeconfigContextName = "cs-e2e-test-ctx"
+)
+
+// resolveProxyBaseURL returns the given override if set, else the proxy base
+// URL for env. An override allows routing through a custom proxy or internal
+// load balancer; it must be an absolute http or https URL and may include a
+// path prefix.
+func resolveProxyBaseURL(override string, env constants.Environment) (string, error) {
+	if override == "" {
+		return constants.ProxyBaseURLForEnvironment(env)
+	}
+
+	u, err := url.Parse(override)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing proxy base URL %q", override)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.Errorf("proxy base URL %q must be an absolute http or https URL", override)
+	}
+
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.Errorf("proxy base URL %q must not have a query or fragment", override)
+	}
+
+	return strings.TrimSuffix(override, "/"), nil
+}
+
+const (
+	// authModeToken authenticates to the proxy with the Containership Cloud
+	// token
+	authModeToken = "token"
+
+	// authModeClientCert authenticates to the proxy with a client
+	// certificate, for proxy configurations that require one
+	authModeClientCert = "client-cert"
+)
+
+// kubeconfigAuth holds the credentials a generated kubeconfig authenticates
+// to the proxy with. Only the fields for its mode are set.
+type kubeconfigAuth struct {
+	mode string
+
+	token string
+
+	// PEM-encoded client certificate and key
+	clientCertData []byte
+	clientKeyData  []byte
+}
+
+// tokenAuth returns kubeconfig credentials using the given bearer token
+func tokenAuth(token string) kubeconfigAuth {
+	return kubeconfigAuth{
+		mode:  authModeToken,
+		token: token,
+	}
+}
+
+// newKubeconfigAuth returns kubeconfig credentials for the given mode,
+// reading the client certificate and key files in client-cert mode. It
+// returns an error if an input required by the mode is missing or if an
+// input is given that the mode would ignore, so that a misconfiguration is
+// caught before provisioning rather than when first using the kubeconfig.
+func newKubeconfigAuth(mode, token, certFilename, keyFilename string) (kubeconfigAuth, error) {
+	switch mode {
+	case authModeToken:
+		if token == "" {
+			return kubeconfigAuth{}, errors.New("a token is required for auth mode token")
+		}
+		if certFilename != "" || keyFilename != "" {
+			return kubeconfigAuth{}, errors.New("client certificate and key files are only used with auth mode client-cert")
+		}
+
+		return tokenAuth(token), nil
+
+	case authModeClientCert:
+		if certFilename == "" || keyFilename == "" {
+			return kubeconfigAuth{}, errors.New("client certificate and key files are both required for auth mode client-cert")
+		}
+
+		certData, err := ioutil.ReadFile(certFilename)
+		if err != nil {
+			return kubeconfigAuth{}, errors.Wrap(err, "reading client certificate file")
+		}
+
+		keyData, err := ioutil.ReadFile(keyFilename)
+		if err != nil {
+			return kubeconfigAuth{}, errors.Wrap(err, "reading client key file")
+		}
+
+		if _, err := tls.X509KeyPair(certData, keyData); err != nil {
+			return kubeconfigAuth{}, errors.Wrapf(err, "loading client certificate %q and key %q", certFilename, keyFilename)
+		}
+
+		return kubeconfigAuth{
+			mode:           authModeClientCert,
+			clientCertData: certData,
+			clientKeyData:  keyData,
+		}, nil
+
+	default:
+		return kubeconfigAuth{}, errors.Errorf("unknown auth mode %q, must be %s or %s", mode, authModeToken, authModeClientCert)
+	}
+}
+
+// readCAFile reads a PEM-encoded CA certificate bundle for verifying the
+// Containership Kubernetes API proxy. An empty filename results in nil data,
+// meaning that the system roots are used.
+func readCAFile(filename string) ([]byte, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading proxy CA file")
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("proxy CA file %q contains no valid PEM-encoded certificates", filename)
+	}
+
+	return data, nil
+}
+
+// writeKubeconfig writes a kubeconfig for accessing the given cluster through
+// the Containership Kubernetes API proxy. If caData is not empty, it is
+// embedded as the certificate-authority-data used to verify the proxy.
+func writeKubeconfig(filename, proxyBaseURL, organizationID, clusterID string, auth kubeconfigAuth, caData []byte) error {
+	config := buildKubeconfig(proxyBaseURL, organizationID, clusterID, auth, caData)
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return errors.Wrap(err, "serializing kubeconfig")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(filename, data, 0600), "writing kubeconfig")
+}
+
+// copyKubeconfig copies the kubeconfig at src to dst, creating any missing
+// parent directories. The copy is only readable by the owner since it
+// contains credentials.
+func copyKubeconfig(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return errors.Wrap(err, "reading kubeconfig")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return errors.Wrap(err, "creating kubeconfig output directory")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(dst, data, 0600), "writing kubeconfig copy")
+}
+
+// checkTokenAccepted makes a single request through the Kubernetes API proxy
+// using the kubeconfig at filename and returns an error if its credentials
+// were rejected. Other errors, e.g. the API server not being ready yet, are
+// ignored since waiting for the API is retried separately, and that retry
+// can't tell bad credentials from RBAC not being synced yet. The server version
+// is requested because every authenticated user may read it.
+func checkTokenAccepted(filename string) error {
+	cfg, err := clientcmd.BuildConfigFromFlags("", filename)
+	if err != nil {
+		return errors.Wrap(err, "loading kubeconfig")
+	}
+
+	kubeClientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "building Kubernetes clientset")
+	}
+
+	_, err = kubeClientset.Discovery().ServerVersion()
+	if util.IsAuthError(err) {
+		return errors.Wrapf(err, "proxy rejected the kubeconfig credentials for %s", cfg.Host)
+	}
+
+	return nil
+}
+
+// buildRestConfig builds a REST config for accessing the given cluster through
+// the Containership Kubernetes API proxy without going through a file on disk.
+// It has the same connection parameters as the file written by
+// writeKubeconfig.
+func buildRestConfig(proxyBaseURL, organizationID, clusterID string, auth kubeconfigAuth, caData []byte) (*rest.Config, error) {
+	config := buildKubeconfig(proxyBaseURL, organizationID, clusterID, auth, caData)
+
+	cfg, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "building REST config from kubeconfig")
+	}
+
+	return cfg, nil
+}
+
+// NewKubernetesClientset builds a Kubernetes clientset for accessing the
+// given cluster through the Containership Kubernetes API proxy, for suites
+// that run against a cluster they didn't discover from KUBECONFIG
+func NewKubernetesClientset(proxyBaseURL, organizationID, clusterID, authToken string, caData []byte) (kubernetes.Interface, error) {
+	cfg, err := buildRestConfig(proxyBaseURL, organizationID, clusterID, tokenAuth(authToken), caData)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeClientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "building Kubernetes clientset")
+	}
+
+	return kubeClientset, nil
+}
+
+func buildKubeconfig(proxyBaseURL, organizationID, clusterID string, auth kubeconfigAuth, caData []byte) *clientcmdapi.Config {
+	config := clientcmdapi.NewConfig()
+
+	config.Clusters[kubeconfigClusterName] = &clientcmdapi.Cluster{
+		Server: constants.ClusterProxyURLForBase(proxyBaseURL, organizationID, clusterID),
+		// clientcmd base64 encodes this when serializing
+		CertificateAuthorityData: caData,
+	}
+
+	// clientcmd base64 encodes the certificate data when serializing
+	config.AuthInfos[kubeconfigUserName] = &clientcmdapi.AuthInfo{
+		Token:                 auth.token,
+		ClientCertificateData: auth.clientCertData,
+		ClientKeyData:         auth.clientKeyData,
+	}
+
+	config.Contexts[kubeconfigContextName] = &clientcmdapi.Context{
+		Cluster:  kubeconfigClusterName,
+		AuthInfo: kubeconfigUserName,
+	}
+
+	config.CurrentContext = kubeconfigContextName
+
+	return config
+}
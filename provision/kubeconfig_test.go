@@ -0,0 +1,336 @@
+package provision
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestWriteKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "kube.conf")
+	// YAML-special characters must not corrupt the file
+	token := "tok\"en: 'with'\n- special # chars"
+
+	err = writeKubeconfig(filename, "https://proxy.example.com", "org-id", "cluster-id", tokenAuth(token), nil)
+	if err != nil {
+		t.Fatalf("writing kubeconfig: %v", err)
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", filename)
+	if err != nil {
+		t.Fatalf("loading written kubeconfig: %v", err)
+	}
+
+	if cfg.BearerToken != token {
+		t.Errorf("expected token %q, got %q", token, cfg.BearerToken)
+	}
+
+	wantHost := "https://proxy.example.com/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"
+	if cfg.Host != wantHost {
+		t.Errorf("expected host %q, got %q", wantHost, cfg.Host)
+	}
+}
+
+func TestCopyKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "kube.conf")
+	err = writeKubeconfig(src, "https://proxy.example.com", "org-id", "cluster-id", tokenAuth("token"), nil)
+	if err != nil {
+		t.Fatalf("writing kubeconfig: %v", err)
+	}
+
+	dst := filepath.Join(dir, "artifacts", "nested", "kube.conf")
+	if err := copyKubeconfig(src, dst); err != nil {
+		t.Fatalf("copying kubeconfig: %v", err)
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", dst)
+	if err != nil {
+		t.Fatalf("loading copied kubeconfig: %v", err)
+	}
+	if cfg.BearerToken != "token" {
+		t.Errorf("expected token %q, got %q", "token", cfg.BearerToken)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected copy to have mode 0600, got %o", perm)
+	}
+
+	if err := copyKubeconfig(filepath.Join(dir, "missing"), dst); err == nil {
+		t.Error("expected error copying missing kubeconfig")
+	}
+}
+
+func TestBuildRestConfig(t *testing.T) {
+	cfg, err := buildRestConfig("https://proxy.example.com", "org-id", "cluster-id", tokenAuth("token"), nil)
+	if err != nil {
+		t.Fatalf("building REST config: %v", err)
+	}
+
+	wantHost := "https://proxy.example.com/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"
+	if cfg.Host != wantHost {
+		t.Errorf("expected host %q, got %q", wantHost, cfg.Host)
+	}
+
+	if cfg.BearerToken != "token" {
+		t.Errorf("expected token %q, got %q", "token", cfg.BearerToken)
+	}
+}
+
+func TestCheckTokenAccepted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusOK, false},
+		{"rejected", http.StatusUnauthorized, true},
+		{"API not ready", http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		status := test.status
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"major": "1", "minor": "15", "gitVersion": "v1.15.0"}`))
+		}))
+
+		filename := filepath.Join(dir, "kube.conf")
+		err := writeKubeconfig(filename, server.URL, "org-id", "cluster-id", tokenAuth("token"), nil)
+		if err != nil {
+			server.Close()
+			t.Fatalf("writing kubeconfig: %v", err)
+		}
+
+		err = checkTokenAccepted(filename)
+		if test.wantErr && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+
+		server.Close()
+	}
+}
+
+func TestResolveProxyBaseURL(t *testing.T) {
+	tests := []struct {
+		override string
+		want     string
+		wantErr  bool
+	}{
+		{"", constants.StageProxyBaseURL, false},
+		{"https://lb.internal", "https://lb.internal", false},
+		{"http://lb.internal:8080/containership/", "http://lb.internal:8080/containership", false},
+		{"lb.internal", "", true},
+		{"ftp://lb.internal", "", true},
+		{"https://lb.internal?x=1", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := resolveProxyBaseURL(test.override, constants.Stage)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error", test.override)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.override, err)
+		}
+		if got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.override, test.want, got)
+		}
+	}
+}
+
+func TestBuildRestConfigWithProxyOverride(t *testing.T) {
+	proxyBaseURL, err := resolveProxyBaseURL("https://lb.internal/containership/", constants.Production)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg, err := buildRestConfig(proxyBaseURL, "org-id", "cluster-id", tokenAuth("token"), nil)
+	if err != nil {
+		t.Fatalf("building REST config: %v", err)
+	}
+
+	wantHost := "https://lb.internal/containership/v3/organizations/org-id/clusters/cluster-id/k8sapi/proxy"
+	if cfg.Host != wantHost {
+		t.Errorf("expected host %q, got %q", wantHost, cfg.Host)
+	}
+}
+
+func TestWriteKubeconfigWithCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caData, err := readCAFile("testdata/ca.crt")
+	if err != nil {
+		t.Fatalf("reading CA file: %v", err)
+	}
+
+	filename := filepath.Join(dir, "kube.conf")
+	err = writeKubeconfig(filename, "https://proxy.example.com", "org-id", "cluster-id", tokenAuth("token"), caData)
+	if err != nil {
+		t.Fatalf("writing kubeconfig: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "certificate-authority-data: "+base64.StdEncoding.EncodeToString(caData)) {
+		t.Errorf("expected certificate-authority-data in kubeconfig, got:\n%s", data)
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", filename)
+	if err != nil {
+		t.Fatalf("loading written kubeconfig: %v", err)
+	}
+
+	if !bytes.Equal(cfg.CAData, caData) {
+		t.Errorf("expected CA data to round trip, got %q", cfg.CAData)
+	}
+}
+
+func TestReadCAFile(t *testing.T) {
+	data, err := readCAFile("")
+	if err != nil || data != nil {
+		t.Errorf("expected no data and no error for empty filename, got %q and %v", data, err)
+	}
+
+	if _, err := readCAFile("testdata/missing.crt"); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	if _, err := readCAFile("testdata/template.json"); err == nil {
+		t.Error("expected error for file without certificates")
+	}
+}
+
+func TestWriteKubeconfigAuthModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certData, err := ioutil.ReadFile("testdata/client.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyData, err := ioutil.ReadFile("testdata/client.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode     string
+		token    string
+		certFile string
+		keyFile  string
+	}{
+		{authModeToken, "token", "", ""},
+		{authModeClientCert, "", "testdata/client.crt", "testdata/client.key"},
+	}
+
+	for _, test := range tests {
+		mode := test.mode
+		auth, err := newKubeconfigAuth(mode, test.token, test.certFile, test.keyFile)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+
+		filename := filepath.Join(dir, mode+".conf")
+		if err := writeKubeconfig(filename, "https://proxy.example.com", "org-id", "cluster-id", auth, nil); err != nil {
+			t.Fatalf("%s: writing kubeconfig: %v", mode, err)
+		}
+
+		cfg, err := clientcmd.BuildConfigFromFlags("", filename)
+		if err != nil {
+			t.Fatalf("%s: loading written kubeconfig: %v", mode, err)
+		}
+
+		switch mode {
+		case authModeToken:
+			if cfg.BearerToken != "token" {
+				t.Errorf("%s: expected token %q, got %q", mode, "token", cfg.BearerToken)
+			}
+			if len(cfg.CertData) != 0 || len(cfg.KeyData) != 0 {
+				t.Errorf("%s: expected no client certificate", mode)
+			}
+		case authModeClientCert:
+			if cfg.BearerToken != "" {
+				t.Errorf("%s: expected no token, got %q", mode, cfg.BearerToken)
+			}
+			if !bytes.Equal(cfg.CertData, certData) || !bytes.Equal(cfg.KeyData, keyData) {
+				t.Errorf("%s: expected client certificate and key to round trip", mode)
+			}
+		}
+	}
+}
+
+func TestNewKubeconfigAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		token    string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{"token", authModeToken, "token", "", "", false},
+		{"token missing", authModeToken, "", "", "", true},
+		{"token with cert", authModeToken, "token", "testdata/client.crt", "testdata/client.key", true},
+		{"client cert", authModeClientCert, "", "testdata/client.crt", "testdata/client.key", false},
+		{"client cert without key", authModeClientCert, "", "testdata/client.crt", "", true},
+		{"client cert missing file", authModeClientCert, "", "testdata/missing.crt", "testdata/client.key", true},
+		{"client cert mismatched key", authModeClientCert, "", "testdata/ca.crt", "testdata/client.key", true},
+		{"unknown mode", "basic", "token", "", "", true},
+	}
+
+	for _, test := range tests {
+		_, err := newKubeconfigAuth(test.mode, test.token, test.certFile, test.keyFile)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.name, test.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package provision
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// AssertClusterLabels returns an error listing any missing, unexpected, or
+// mismatched labels if the given cluster's labels in the cloud don't exactly
+// match expected. This catches labels from the create request being silently
+// dropped by the API.
+func AssertClusterLabels(cs cloud.Interface, organizationID, clusterID string, expected map[string]string) error {
+	cluster, err := cs.Provision().
+		CKEClusters(organizationID).
+		Get(clusterID)
+	if err != nil {
+		return errors.Wrap(err, "GETing cluster")
+	}
+
+	diff := util.DiffLabels(expected, cluster.Labels)
+	if len(diff) > 0 {
+		return errors.Errorf("cluster %q labels do not match the create request:\n%s",
+			clusterID, strings.Join(diff, "\n"))
+	}
+
+	return nil
+}
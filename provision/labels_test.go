@@ -0,0 +1,56 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestAssertClusterLabels(t *testing.T) {
+	cs := cloudfake.New()
+
+	req, err := readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := cs.Provision().
+		CKEClusters("org").
+		Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"cluster.containership.io/environment": "mk",
+		"cluster.containership.io/name":        "mk-e2e-tmpl",
+	}
+
+	if err := AssertClusterLabels(cs, "org", string(cluster.ID), expected); err != nil {
+		t.Errorf("expected labels to match, got error: %v", err)
+	}
+
+	expected["cluster.containership.io/owner"] = "e2e"
+	delete(expected, "cluster.containership.io/name")
+
+	err = AssertClusterLabels(cs, "org", string(cluster.ID), expected)
+	if err == nil {
+		t.Fatal("expected error for differing labels")
+	}
+
+	for _, want := range []string{
+		"- cluster.containership.io/owner=e2e",
+		"+ cluster.containership.io/name=mk-e2e-tmpl",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got: %v", want, err)
+		}
+	}
+
+	// Labels silently dropped by the API
+	cs.AddCluster("unlabeled", "RUNNING")
+	if err := AssertClusterLabels(cs, "org", "unlabeled", map[string]string{"a": "b"}); err == nil {
+		t.Error("expected error for cluster missing labels")
+	}
+}
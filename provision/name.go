@@ -0,0 +1,76 @@
+package provision
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+// clusterNameSuffixBytes is the number of random bytes appended to a cluster
+// name, hex encoded, when a random suffix is requested
+const clusterNameSuffixBytes = 3
+
+// BuildClusterName returns the name to create a cluster with, optionally
+// with a random suffix, e.g. "ci-e2e-3fa9c1", so that jobs provisioning
+// concurrently into the same organization don't collide. The name is stored
+// in a label, so it must be a valid label value.
+func BuildClusterName(name string, randomSuffix bool) (string, error) {
+	if name == "" {
+		return "", errors.New("cluster name must not be empty")
+	}
+
+	if randomSuffix {
+		suffix := make([]byte, clusterNameSuffixBytes)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", errors.Wrap(err, "generating cluster name suffix")
+		}
+
+		name += "-" + hex.EncodeToString(suffix)
+	}
+
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return "", errors.Errorf("invalid cluster name %q: %s", name, strings.Join(errs, "; "))
+	}
+
+	return name, nil
+}
+
+// applyClusterName overrides the name in the cluster create request, which
+// is held in the cluster name label
+func applyClusterName(req *types.CreateCKEClusterRequest, name string) {
+	if req.Labels == nil {
+		req.Labels = make(map[string]string)
+	}
+
+	req.Labels[constants.ClusterNameLabelKey] = name
+}
+
+// AssertClusterName returns an error if the given cluster's name in the cloud
+// isn't the expected name
+func AssertClusterName(cs cloud.Interface, organizationID, clusterID, expected string) error {
+	cluster, err := cs.Provision().
+		CKEClusters(organizationID).
+		Get(clusterID)
+	if err != nil {
+		return errors.Wrap(err, "GETing cluster")
+	}
+
+	name, ok := cluster.Labels[constants.ClusterNameLabelKey]
+	if !ok {
+		return errors.Errorf("cluster %q has no name, expected %q", clusterID, expected)
+	}
+	if name != expected {
+		return errors.Errorf("cluster %q is named %q, expected %q", clusterID, name, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package provision
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestBuildClusterName(t *testing.T) {
+	name, err := BuildClusterName("ci-e2e", false)
+	if err != nil || name != "ci-e2e" {
+		t.Errorf("expected name unchanged, got %q, %v", name, err)
+	}
+
+	suffixed := regexp.MustCompile(`^ci-e2e-[0-9a-f]{6}$`)
+	first, err := BuildClusterName("ci-e2e", true)
+	if err != nil || !suffixed.MatchString(first) {
+		t.Errorf("expected name with random suffix, got %q, %v", first, err)
+	}
+	second, _ := BuildClusterName("ci-e2e", true)
+	if first == second {
+		t.Errorf("expected random suffixes to differ, got %q twice", first)
+	}
+
+	for _, invalid := range []string{"", "has spaces", "-leading-dash", strings.Repeat("a", 60)} {
+		if _, err := BuildClusterName(invalid, true); err == nil {
+			t.Errorf("%q: expected error for invalid name", invalid)
+		}
+	}
+}
+
+func TestAssertClusterName(t *testing.T) {
+	cs := cloudfake.New()
+
+	req, err := readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyClusterName(req, "ci-e2e-3fa9c1")
+	if req.Labels["cluster.containership.io/environment"] != "mk" {
+		t.Errorf("expected other labels to be kept, got %v", req.Labels)
+	}
+
+	cluster, err := cs.Provision().
+		CKEClusters("org").
+		Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AssertClusterName(cs, "org", string(cluster.ID), "ci-e2e-3fa9c1"); err != nil {
+		t.Errorf("expected name to match, got error: %v", err)
+	}
+
+	err = AssertClusterName(cs, "org", string(cluster.ID), "mk-e2e-tmpl")
+	if err == nil || !strings.Contains(err.Error(), `is named "ci-e2e-3fa9c1"`) {
+		t.Errorf("expected error for differing name, got %v", err)
+	}
+
+	cs.AddCluster("unnamed", "RUNNING")
+	if err := AssertClusterName(cs, "org", "unnamed", "ci-e2e"); err == nil {
+		t.Error("expected error for cluster without a name")
+	}
+}
+
+func TestApplyClusterNameWithoutLabels(t *testing.T) {
+	req, err := readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Labels = nil
+
+	applyClusterName(req, "ci-e2e")
+	if req.Labels[constants.ClusterNameLabelKey] != "ci-e2e" {
+		t.Errorf("expected name label to be set, got %v", req.Labels)
+	}
+}
@@ -0,0 +1,54 @@
+package provision
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+)
+
+// nodePoolVariableType is the type of template variables defining a node pool
+const nodePoolVariableType = "node_pool"
+
+// TemplateNodePoolCount returns the number of node pools defined in the
+// template request, i.e. the number of node pools a cluster created from it
+// is expected to have
+func TemplateNodePoolCount(req *types.CreateTemplateRequest) int {
+	count := 0
+	for _, variable := range req.Configuration.Variable {
+		if t := variable.Default.Type; t != nil && *t == nodePoolVariableType {
+			count++
+		}
+	}
+
+	return count
+}
+
+// AssertNodePoolCount returns an error if the given cluster doesn't have
+// exactly the expected number of node pools. This catches node pool
+// definitions of the template being partially dropped, which would otherwise
+// go unnoticed since the pools that do exist come up fine.
+func AssertNodePoolCount(cs cloud.Interface, organizationID, clusterID string, expected int) error {
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		return errors.Wrap(err, "listing node pools")
+	}
+
+	if len(pools) == expected {
+		return nil
+	}
+
+	ids := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		ids = append(ids, string(pool.ID))
+	}
+	sort.Strings(ids)
+
+	return errors.Errorf("cluster %q has %d node pools, expected %d from the template (found: [%s])",
+		clusterID, len(pools), expected, strings.Join(ids, ", "))
+}
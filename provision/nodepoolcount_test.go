@@ -0,0 +1,68 @@
+package provision
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestTemplateNodePoolCount(t *testing.T) {
+	req, err := readCreateTemplateRequestFromFile("testdata/template.json", TemplateValues{})
+	if err != nil {
+		t.Fatalf("unexpected error reading template: %v", err)
+	}
+
+	if count := TemplateNodePoolCount(req); count != 1 {
+		t.Errorf("expected 1 node pool, got %d", count)
+	}
+
+	// Only node pool variables are counted
+	var mixed types.CreateTemplateRequest
+	err = json.Unmarshal([]byte(`{"configuration": {"variable": {
+		"np0": {"default": {"type": "node_pool"}},
+		"np1": {"default": {"type": "node_pool"}},
+		"other": {"default": {"type": "something_else"}},
+		"untyped": {"default": {}}
+	}}}`), &mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := TemplateNodePoolCount(&mixed); count != 2 {
+		t.Errorf("expected 2 node pools, got %d", count)
+	}
+}
+
+func TestAssertNodePoolCount(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("cluster", "RUNNING")
+	for _, id := range []string{"worker", "master"} {
+		cs.AddNodePool("cluster", cloudfake.NodePool{
+			ID:             id,
+			KubernetesMode: id,
+			Count:          1,
+			Statuses:       []string{"RUNNING"},
+		})
+	}
+
+	if err := AssertNodePoolCount(cs, "org", "cluster", 2); err != nil {
+		t.Errorf("expected matching count to pass, got %v", err)
+	}
+
+	err := AssertNodePoolCount(cs, "org", "cluster", 3)
+	if err == nil {
+		t.Fatal("expected error for missing node pool")
+	}
+	for _, want := range []string{"has 2 node pools", "expected 3", "[master, worker]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got %v", want, err)
+		}
+	}
+
+	if err := AssertNodePoolCount(cs, "org", "missing", 1); err == nil {
+		t.Error("expected error for missing cluster")
+	}
+}
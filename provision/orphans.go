@@ -0,0 +1,226 @@
+package provision
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// OrphanedCluster is a test cluster left behind by a failed run
+type OrphanedCluster struct {
+	ID        string
+	CreatedAt time.Time
+
+	// TemplateID is the template the cluster was created from, to delete
+	// along with it. It's empty if the template is still used by a cluster
+	// that isn't orphaned, or if another orphan is deleted after this one
+	// and takes the shared template with it.
+	TemplateID string
+}
+
+// applyTestClusterLabel marks the cluster in the create request as created
+// by these tests
+func applyTestClusterLabel(req *types.CreateCKEClusterRequest) {
+	if req.Labels == nil {
+		req.Labels = make(map[string]string)
+	}
+
+	req.Labels[constants.TestClusterLabelKey] = constants.TestClusterLabelValue
+}
+
+// applyKeepClusterLabel marks the cluster in the create request as kept on
+// purpose, e.g. for debugging or soak testing, so that it's never considered
+// orphaned
+func applyKeepClusterLabel(req *types.CreateCKEClusterRequest) {
+	if req.Labels == nil {
+		req.Labels = make(map[string]string)
+	}
+
+	req.Labels[constants.KeepClusterLabelKey] = constants.KeepClusterLabelValue
+}
+
+// ListClustersByLabel returns the clusters in the organization carrying every
+// label in selector. The selector must not be empty so that a mistake can't
+// select every cluster in the organization.
+func ListClustersByLabel(cs cloud.Interface, organizationID string, selector map[string]string) ([]types.CKECluster, error) {
+	if len(selector) == 0 {
+		return nil, errors.New("label selector must not be empty")
+	}
+
+	clusters, err := cs.Provision().
+		CKEClusters(organizationID).
+		List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing clusters")
+	}
+
+	return filterClustersByLabel(clusters, selector), nil
+}
+
+func filterClustersByLabel(clusters []types.CKECluster, selector map[string]string) []types.CKECluster {
+	var matched []types.CKECluster
+	for _, cluster := range clusters {
+		if hasLabels(cluster.Labels, selector) {
+			matched = append(matched, cluster)
+		}
+	}
+
+	return matched
+}
+
+func hasLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListOrphanedClusters returns the clusters matching selector that were
+// created more than olderThan ago, oldest first. Clusters labeled to be kept
+// (see constants.KeepClusterLabelKey) are left out, as are clusters already
+// being deleted and clusters without a creation timestamp since there's
+// nothing to do for them or no way to tell that they're orphaned. The
+// orphans are meant to be deleted in order with DeleteProvisionResult, see
+// OrphanedCluster.TemplateID.
+func ListOrphanedClusters(cs cloud.Interface, organizationID string, selector map[string]string, olderThan time.Duration) ([]OrphanedCluster, error) {
+	return listOrphanedClusters(cs, organizationID, selector, olderThan, time.Now())
+}
+
+func listOrphanedClusters(cs cloud.Interface, organizationID string, selector map[string]string, olderThan time.Duration, now time.Time) ([]OrphanedCluster, error) {
+	if olderThan <= 0 {
+		return nil, errors.Errorf("age threshold must be positive, got %s", olderThan)
+	}
+
+	if len(selector) == 0 {
+		return nil, errors.New("label selector must not be empty")
+	}
+
+	// Every cluster is listed, not only the matching ones, so that templates
+	// still in use by other clusters are kept
+	all, err := cs.Provision().
+		CKEClusters(organizationID).
+		List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing clusters")
+	}
+
+	templateIDs := make(map[string]string, len(all))
+	for i := range all {
+		id, err := clusterTemplateID(&all[i])
+		if err != nil {
+			return nil, err
+		}
+		templateIDs[string(all[i].ID)] = id
+	}
+
+	clusters := filterClustersByLabel(all, selector)
+
+	var orphans []OrphanedCluster
+	for i := range clusters {
+		cluster := &clusters[i]
+		id := string(cluster.ID)
+
+		if cluster.Labels[constants.KeepClusterLabelKey] == constants.KeepClusterLabelValue {
+			continue
+		}
+		if cluster.Status.Type != nil && *cluster.Status.Type == "DELETING" {
+			continue
+		}
+
+		createdAt, err := ClusterCreatedAt(cluster)
+		if err != nil {
+			log.Info("skipping cluster without a creation timestamp", "id", id, "error", err.Error())
+			continue
+		}
+
+		if now.Sub(createdAt) > olderThan {
+			orphans = append(orphans, OrphanedCluster{
+				ID:        id,
+				CreatedAt: createdAt,
+			})
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].CreatedAt.Before(orphans[j].CreatedAt)
+	})
+
+	assignOrphanedTemplates(orphans, templateIDs)
+
+	return orphans, nil
+}
+
+// assignOrphanedTemplates sets the template to delete along with each of the
+// given orphans, which are in deletion order. templateIDs holds the template
+// of every cluster in the organization, keyed by cluster ID. A template is
+// only deleted if no remaining cluster uses it, and only along with the last
+// orphan using it so that it isn't deleted from under another.
+func assignOrphanedTemplates(orphans []OrphanedCluster, templateIDs map[string]string) {
+	orphaned := make(map[string]bool, len(orphans))
+	for _, orphan := range orphans {
+		orphaned[orphan.ID] = true
+	}
+
+	inUse := make(map[string]bool)
+	for clusterID, templateID := range templateIDs {
+		if !orphaned[clusterID] {
+			inUse[templateID] = true
+		}
+	}
+
+	for i := len(orphans) - 1; i >= 0; i-- {
+		templateID := templateIDs[orphans[i].ID]
+		if templateID == "" || inUse[templateID] {
+			continue
+		}
+
+		orphans[i].TemplateID = templateID
+		inUse[templateID] = true
+	}
+}
+
+// clusterTemplateID returns the ID of the template the given cluster was
+// created from, or "" if the API doesn't report it
+func clusterTemplateID(cluster *types.CKECluster) (string, error) {
+	// types.CKECluster has no TemplateID field
+	var fields struct {
+		TemplateID string `json:"template_id"`
+	}
+	if err := util.RoundTripJSON(cluster, &fields); err != nil {
+		return "", errors.Wrapf(err, "reading cluster %q", cluster.ID)
+	}
+
+	return fields.TemplateID, nil
+}
+
+// ClusterCreatedAt returns when the given cluster was created. The API
+// reports it as a string of Unix seconds, or as an RFC 3339 timestamp.
+func ClusterCreatedAt(cluster *types.CKECluster) (time.Time, error) {
+	if cluster.CreatedAt == nil || *cluster.CreatedAt == "" {
+		return time.Time{}, errors.New("cluster has no creation timestamp")
+	}
+
+	value := *cluster.CreatedAt
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("unrecognized creation timestamp %q", value)
+	}
+
+	return createdAt, nil
+}
@@ -0,0 +1,171 @@
+package provision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+var testClusterSelector = map[string]string{
+	constants.TestClusterLabelKey: constants.TestClusterLabelValue,
+}
+
+func TestClusterCreatedAt(t *testing.T) {
+	want := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	createdAt := func(value string) *string {
+		return &value
+	}
+
+	tests := []struct {
+		createdAt *string
+		wantErr   bool
+	}{
+		{createdAt("1559390400"), false},
+		{createdAt("2019-06-01T12:00:00Z"), false},
+		{createdAt("yesterday"), true},
+		{createdAt(""), true},
+		{nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := ClusterCreatedAt(&types.CKECluster{ID: "cluster", CreatedAt: test.createdAt})
+		name := "<nil>"
+		if test.createdAt != nil {
+			name = *test.createdAt
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: expected error %t, got %v", name, test.wantErr, err)
+			continue
+		}
+
+		if !test.wantErr && !got.Equal(want) {
+			t.Errorf("%q: expected %s, got %s", name, want, got)
+		}
+	}
+}
+
+func TestListOrphanedClusters(t *testing.T) {
+	cs := cloudfake.New()
+	now := time.Now()
+
+	createTestCluster := func(createdAt time.Time) string {
+		req, err := readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := CreateCluster(cs, "org", "template", req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.SetClusterCreatedAt(result.ClusterID, createdAt)
+
+		return result.ClusterID
+	}
+
+	old := createTestCluster(now.Add(-3 * time.Hour))
+	oldest := createTestCluster(now.Add(-5 * time.Hour))
+	createTestCluster(now.Add(-time.Hour))
+
+	// Clusters kept on purpose are never orphans, however old
+	req, err := readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyKeepClusterLabel(req)
+	kept, err := CreateCluster(cs, "org", "kept-template", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SetClusterCreatedAt(kept.ClusterID, now.Add(-24*time.Hour))
+
+	// Clusters not created by the tests are never orphans, however old
+	req, err = readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	untagged, err := cs.Provision().
+		CKEClusters("org").
+		Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SetClusterCreatedAt(string(untagged.ID), now.Add(-24*time.Hour))
+
+	orphans, err := listOrphanedClusters(cs, "org", testClusterSelector, 2*time.Hour, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(orphans) != 2 || orphans[0].ID != oldest || orphans[1].ID != old {
+		t.Fatalf("expected orphans %q and %q, oldest first, got %v", oldest, old, orphans)
+	}
+
+	// Every test cluster shares the template, which is still in use by the
+	// cluster that isn't old enough yet
+	for _, orphan := range orphans {
+		if orphan.TemplateID != "" {
+			t.Errorf("expected template of %q to be kept while in use, got %q", orphan.ID, orphan.TemplateID)
+		}
+	}
+
+	if _, err := listOrphanedClusters(cs, "org", nil, 2*time.Hour, now); err == nil {
+		t.Error("expected error for empty selector")
+	}
+	if _, err := listOrphanedClusters(cs, "org", testClusterSelector, 0, now); err == nil {
+		t.Error("expected error for non-positive age")
+	}
+}
+
+func TestAssignOrphanedTemplates(t *testing.T) {
+	orphans := []OrphanedCluster{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}
+	assignOrphanedTemplates(orphans, map[string]string{
+		"a":       "shared",
+		"b":       "shared",
+		"c":       "in-use",
+		"d":       "",
+		"running": "in-use",
+	})
+
+	want := []string{"", "shared", "", ""}
+	for i, orphan := range orphans {
+		if orphan.TemplateID != want[i] {
+			t.Errorf("orphan %q: expected template %q, got %q", orphan.ID, want[i], orphan.TemplateID)
+		}
+	}
+}
+
+func TestListClustersByLabel(t *testing.T) {
+	cs := cloudfake.New()
+
+	req, err := readCreateCKEClusterRequestFromFile("../resources/clusters/digital_ocean/cluster.json", TemplateValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateCluster(cs, "org", "template", req); err != nil {
+		t.Fatal(err)
+	}
+	cs.AddCluster("unlabeled", "RUNNING")
+
+	clusters, err := ListClustersByLabel(cs, "org", map[string]string{
+		constants.TestClusterLabelKey:          constants.TestClusterLabelValue,
+		"cluster.containership.io/environment": "mk",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(clusters) != 1 || clusters[0].ID != "cluster-1" {
+		t.Errorf("expected only the labeled cluster, got %v", clusters)
+	}
+
+	clusters, err = ListClustersByLabel(cs, "org", map[string]string{
+		constants.TestClusterLabelKey: "false",
+	})
+	if err != nil || len(clusters) != 0 {
+		t.Errorf("expected no clusters for mismatched value, got %v, %v", clusters, err)
+	}
+}
@@ -0,0 +1,67 @@
+package provision
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// Status keys that may hold how far along provisioning is, in order of
+// preference
+var (
+	progressPercentKeys = []string{"percent_complete", "progress"}
+	progressPhaseKeys   = []string{"phase", "message"}
+)
+
+// ClusterProvisionProgress returns how far along provisioning the given
+// cluster is as a percentage and a description of the current phase, if the
+// provision API reports them. If the API doesn't report a percentage, -1 is
+// returned for it and the phase falls back to the cluster's status.
+func ClusterProvisionProgress(cs cloud.Interface, organizationID, clusterID string) (int, string, error) {
+	cluster, err := cs.Provision().
+		CKEClusters(organizationID).
+		Get(clusterID)
+	if err != nil {
+		return -1, "", errors.Wrap(err, "GETing cluster")
+	}
+
+	percent, phase := clusterProgress(cluster, *cluster.Status.Type)
+	return percent, phase, nil
+}
+
+// clusterProgress extracts provisioning progress from an already fetched
+// cluster with the given status, see ClusterProvisionProgress. The generated
+// cluster status only has a Type field, so the rest of it is read from JSON.
+func clusterProgress(cluster interface{}, status string) (int, string) {
+	var fields struct {
+		Status map[string]json.RawMessage `json:"status"`
+	}
+	if err := util.RoundTripJSON(cluster, &fields); err != nil {
+		return -1, status
+	}
+
+	percent := -1
+	for _, key := range progressPercentKeys {
+		var value float64
+		if raw, ok := fields.Status[key]; ok && json.Unmarshal(raw, &value) == nil && value >= 0 && value <= 100 {
+			percent = int(value)
+			break
+		}
+	}
+
+	phase := status
+	for _, key := range progressPhaseKeys {
+		var value string
+		if raw, ok := fields.Status[key]; ok && json.Unmarshal(raw, &value) == nil && strings.TrimSpace(value) != "" {
+			phase = strings.TrimSpace(value)
+			break
+		}
+	}
+
+	return percent, phase
+}
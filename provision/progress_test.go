@@ -0,0 +1,45 @@
+package provision
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestClusterProgress(t *testing.T) {
+	tests := []struct {
+		fields      string
+		wantPercent int
+		wantPhase   string
+	}{
+		{`{"id": "cluster", "status": {"type": "PROVISIONING"}}`, -1, "PROVISIONING"},
+		{`{"id": "cluster", "status": {"type": "PROVISIONING", "percent_complete": 42, "phase": "bootstrapping masters"}}`, 42, "bootstrapping masters"},
+		{`{"id": "cluster", "status": {"type": "PROVISIONING", "progress": 12.5}}`, 12, "PROVISIONING"},
+		{`{"id": "cluster", "status": {"type": "PROVISIONING", "percent_complete": "42", "message": "  joining workers "}}`, -1, "joining workers"},
+		{`{"id": "cluster", "status": {"type": "PROVISIONING", "percent_complete": 250, "phase": ""}}`, -1, "PROVISIONING"},
+		{`not json`, -1, "PROVISIONING"},
+	}
+
+	for _, test := range tests {
+		percent, phase := clusterProgress(json.RawMessage(test.fields), "PROVISIONING")
+		if percent != test.wantPercent || phase != test.wantPhase {
+			t.Errorf("%s: expected %d%% %q, got %d%% %q", test.fields, test.wantPercent, test.wantPhase, percent, phase)
+		}
+	}
+}
+
+func TestClusterProvisionProgress(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("cluster", "PROVISIONING")
+
+	// The fake doesn't report progress, so only the status is available
+	percent, phase, err := ClusterProvisionProgress(cs, "org", "cluster")
+	if err != nil || percent != -1 || phase != "PROVISIONING" {
+		t.Errorf("expected no percentage and the status as phase, got %d, %q, %v", percent, phase, err)
+	}
+
+	if _, _, err := ClusterProvisionProgress(cs, "org", "missing"); err == nil {
+		t.Error("expected error for missing cluster")
+	}
+}
@@ -0,0 +1,502 @@
+package provision
+
+import (
+	// Aliased because the provision suite declares a package-level context
+	gocontext "context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// ProvisionOverrides override values in the template and cluster files
+// used by ProvisionCluster. Zero values are ignored.
+type ProvisionOverrides struct {
+	// KubernetesVersion overrides the version of every node pool in the
+	// template
+	KubernetesVersion string
+
+	// TemplateName overrides the name (description) of the template
+	TemplateName string
+
+	// WorkerCount overrides the count of every worker node pool in the
+	// template. It must not be negative, and zero leaves the counts as is.
+	WorkerCount int32
+
+	// ReuseTemplate reuses an existing template with the same name instead
+	// of creating a new one
+	ReuseTemplate bool
+
+	// TemplateValues are executed against the template and cluster files
+	TemplateValues TemplateValues
+
+	// KeepCluster labels the cluster as kept on purpose so that it's never
+	// cleaned up as an orphan, see ListOrphanedClusters
+	KeepCluster bool
+}
+
+// ProvisionResult is what ProvisionCluster created. It carries everything
+// needed to verify and tear down the cluster, so that callers don't need any
+// state of their own.
+type ProvisionResult struct {
+	TemplateID string
+	ClusterID  string
+
+	// KubernetesVersion is the version the cluster reports once running, see
+	// GetClusterKubernetesVersion. It's empty if the cloud doesn't report one.
+	KubernetesVersion string
+
+	// NodePoolIDs are the IDs of the cluster's node pools, sorted
+	NodePoolIDs []string
+}
+
+// ProvisionCluster creates a template and a cluster from the given files and
+// waits for the cluster to report as running. The result is returned even on
+// error with whatever was created, so that the caller may clean it up.
+func ProvisionCluster(cs cloud.Interface, organizationID, templateFilename, clusterFilename string, overrides ProvisionOverrides) (*ProvisionResult, error) {
+	return ProvisionClusterWithContext(gocontext.Background(), cs, organizationID, templateFilename, clusterFilename, overrides)
+}
+
+// ProvisionClusterWithContext is the same as ProvisionCluster but stops
+// waiting for the cluster if ctx is done
+func ProvisionClusterWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, templateFilename, clusterFilename string, overrides ProvisionOverrides) (*ProvisionResult, error) {
+	result, err := CreateTemplateAndCluster(cs, organizationID, templateFilename, clusterFilename, overrides)
+	if err != nil {
+		return result, err
+	}
+
+	err = WaitForClusterRunningWithContext(ctx, cs, organizationID, result.ClusterID)
+	if err != nil {
+		return result, err
+	}
+
+	return result, PopulateProvisionResult(cs, organizationID, result)
+}
+
+// CreateTemplateAndCluster creates a template and a cluster from the given
+// files without waiting for the cluster, so that the caller can register
+// them for teardown before the long wait in ProvisionCluster. The result is
+// returned even on error with whatever was created.
+func CreateTemplateAndCluster(cs cloud.Interface, organizationID, templateFilename, clusterFilename string, overrides ProvisionOverrides) (*ProvisionResult, error) {
+	result := &ProvisionResult{}
+
+	if overrides.WorkerCount < 0 {
+		return result, errors.Errorf("worker count override must not be negative, got %d", overrides.WorkerCount)
+	}
+
+	templateReq, err := readCreateTemplateRequestFromFile(templateFilename, overrides.TemplateValues)
+	if err != nil {
+		return result, errors.Wrap(err, "building template create request")
+	}
+
+	applyTemplateOverrides(templateReq, overrides)
+
+	result.TemplateID, _, err = EnsureTemplate(cs, organizationID, templateReq, overrides.ReuseTemplate)
+	if err != nil {
+		return result, err
+	}
+
+	clusterReq, err := readCreateCKEClusterRequestFromFile(clusterFilename, overrides.TemplateValues)
+	if err != nil {
+		return result, errors.Wrap(err, "building cluster create request")
+	}
+
+	if overrides.KeepCluster {
+		applyKeepClusterLabel(clusterReq)
+	}
+
+	created, err := CreateCluster(cs, organizationID, result.TemplateID, clusterReq)
+	if err != nil {
+		return result, err
+	}
+	result.ClusterID = created.ClusterID
+
+	return result, nil
+}
+
+// CreateCluster creates a cluster from the given template and request and
+// returns a result with the template and cluster IDs set. The request's
+// template ID is overridden and the cluster is labeled as a test cluster,
+// see ListOrphanedClusters.
+func CreateCluster(cs cloud.Interface, organizationID, templateID string, req *types.CreateCKEClusterRequest) (*ProvisionResult, error) {
+	req.TemplateID = types.UUID(templateID)
+	applyTestClusterLabel(req)
+
+	cluster, err := cs.Provision().
+		CKEClusters(organizationID).
+		Create(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cluster")
+	}
+
+	return &ProvisionResult{
+		TemplateID: templateID,
+		ClusterID:  string(cluster.ID),
+	}, nil
+}
+
+// PopulateProvisionResult fills in the fields of result that are only known
+// once its cluster is running
+func PopulateProvisionResult(cs cloud.Interface, organizationID string, result *ProvisionResult) error {
+	version, err := GetClusterKubernetesVersion(cs, organizationID, result.ClusterID)
+	if err != nil && err != ErrKubernetesVersionNotSet {
+		return errors.Wrap(err, "getting cluster Kubernetes version")
+	}
+	result.KubernetesVersion = version
+
+	pools, err := cs.Provision().
+		NodePools(organizationID, result.ClusterID).
+		List()
+	if err != nil {
+		return errors.Wrap(err, "listing node pools")
+	}
+
+	ids := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		ids = append(ids, string(pool.ID))
+	}
+	sort.Strings(ids)
+	result.NodePoolIDs = ids
+
+	return nil
+}
+
+// EnsureTemplate creates a template from the given request and returns its
+// ID. If reuse is set and a template with the same name already exists, its
+// ID is returned instead and reused is true.
+func EnsureTemplate(cs cloud.Interface, organizationID string, req *types.CreateTemplateRequest, reuse bool) (id string, reused bool, err error) {
+	if reuse {
+		name := templateRequestName(req)
+		if name == "" {
+			return "", false, errors.New("template must have a name (description) to be reused")
+		}
+
+		existing, err := FindTemplateByName(cs, organizationID, name)
+		if err != nil {
+			return "", false, err
+		}
+		if existing != nil {
+			return string(existing.ID), true, nil
+		}
+	}
+
+	template, err := cs.Provision().
+		Templates(organizationID).
+		Create(req)
+	if err != nil {
+		return "", false, errors.Wrap(err, "creating template")
+	}
+
+	return string(template.ID), false, nil
+}
+
+// FindTemplateByName returns the first template in the organization whose
+// name (description) matches, or nil if there is none
+func FindTemplateByName(cs cloud.Interface, organizationID, name string) (*types.Template, error) {
+	templates, err := cs.Provision().
+		Templates(organizationID).
+		List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing templates")
+	}
+
+	for i := range templates {
+		if templates[i].Description != nil && *templates[i].Description == name {
+			return &templates[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// templateRequestName returns the name (description) the template will be created
+// with, or the empty string if it has none
+func templateRequestName(req *types.CreateTemplateRequest) string {
+	if req.Description == nil {
+		return ""
+	}
+
+	return *req.Description
+}
+
+// CheckTokenPermissions performs a cheap authorized read against the
+// provision API so that a token without access to the organization fails
+// immediately with a clear error, rather than with an opaque 403 partway
+// through provisioning
+func CheckTokenPermissions(cs cloud.Interface, organizationID string) error {
+	_, err := cs.Provision().
+		Templates(organizationID).
+		List()
+	if err != nil {
+		if util.IsAuthError(err) {
+			return errors.Wrapf(err, "token lacks provision access to organization %q", organizationID)
+		}
+
+		return errors.Wrap(err, "listing templates to check token permissions")
+	}
+
+	return nil
+}
+
+// listKubernetesVersions returns the Kubernetes versions that may be
+// provisioned in the given organization. It is a variable so that tests may
+// replace it.
+var listKubernetesVersions = func(cs cloud.Interface, organizationID string) ([]string, error) {
+	return constants.SupportedKubernetesVersions, nil
+}
+
+// ValidateKubernetesVersion returns a descriptive error if the given
+// Kubernetes version can't be provisioned, so that a typo fails fast rather
+// than after the create request is sent. A leading "v" is ignored.
+func ValidateKubernetesVersion(cs cloud.Interface, organizationID, version string) error {
+	supported, err := listKubernetesVersions(cs, organizationID)
+	if err != nil {
+		return errors.Wrap(err, "listing supported Kubernetes versions")
+	}
+
+	version = strings.TrimPrefix(version, "v")
+	for _, v := range supported {
+		if strings.TrimPrefix(v, "v") == version {
+			return nil
+		}
+	}
+
+	return errors.Errorf("unsupported Kubernetes version %q (must be one of %s)",
+		version, strings.Join(supported, ", "))
+}
+
+// DefaultClusterInProgressStatuses are the statuses WaitForClusterRunning
+// tolerates on the way to RUNNING if none are given, i.e. those of a cluster
+// being provisioned
+var DefaultClusterInProgressStatuses = []string{"PROVISIONING"}
+
+// UpgradeClusterInProgressStatuses are the statuses a cluster passes through
+// on the way back to RUNNING after an upgrade
+var UpgradeClusterInProgressStatuses = []string{"UPDATING", "RECONCILING"}
+
+// WaitForClusterRunning waits for the given cluster to report as running.
+// While the cluster is in one of inProgress it keeps waiting, and any other
+// status is an error. If inProgress is empty,
+// DefaultClusterInProgressStatuses is used.
+func WaitForClusterRunning(cs cloud.Interface, organizationID, clusterID string, inProgress ...string) error {
+	return WaitForClusterRunningWithContext(gocontext.Background(), cs, organizationID, clusterID, inProgress...)
+}
+
+// WaitForClusterRunningWithContext is the same as WaitForClusterRunning but
+// stops waiting if ctx is done. If the cluster enters an unexpected state,
+// e.g. ERROR, a summary of its recent events is included in the error when
+// they're available (see GetClusterEvents).
+func WaitForClusterRunningWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, clusterID string, inProgress ...string) error {
+	if len(inProgress) == 0 {
+		inProgress = DefaultClusterInProgressStatuses
+	}
+
+	err := util.WaitForStatusWithPoller("cluster",
+		util.BackoffPollerWithContext(ctx,
+			constants.ProvisionInitialPollInterval,
+			constants.ProvisionMaxPollInterval,
+			constants.ProvisionTimeout),
+		func() (string, error) {
+			cluster, err := cs.Provision().
+				CKEClusters(organizationID).
+				Get(clusterID)
+			if err != nil {
+				return "", errors.Wrap(err, "GETing cluster")
+			}
+
+			status := *cluster.Status.Type
+			logClusterProgress(clusterID, cluster, status)
+
+			return status, nil
+		},
+		"RUNNING", inProgress...)
+	if _, ok := err.(*util.UnexpectedStatusError); ok {
+		return withClusterEvents(err, cs, organizationID, clusterID)
+	}
+
+	return err
+}
+
+// logClusterProgress logs how far along provisioning the cluster is so that
+// a long wait shows forward motion. Only the status is logged if the API
+// doesn't report progress.
+func logClusterProgress(clusterID string, cluster *types.CKECluster, status string) {
+	percent, phase := clusterProgress(cluster, status)
+	if percent < 0 {
+		log.Info("waiting for cluster", "id", clusterID, "status", status)
+		return
+	}
+
+	log.Info("waiting for cluster", "id", clusterID, "status", status, "progress", fmt.Sprintf("%d%%", percent), "phase", phase)
+}
+
+// WaitForClusterDeleted waits for the given cluster to be removed from the
+// provision API, either by no longer existing or by reporting as DELETED.
+// Polling stops immediately if the cluster enters an unexpected state.
+func WaitForClusterDeleted(cs cloud.Interface, organizationID, clusterID string, poll, timeout time.Duration) error {
+	return WaitForClusterDeletedWithContext(gocontext.Background(), cs, organizationID, clusterID, poll, timeout)
+}
+
+// WaitForClusterDeletedWithContext is the same as WaitForClusterDeleted but
+// stops waiting if ctx is done
+func WaitForClusterDeletedWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, clusterID string, poll, timeout time.Duration) error {
+	return util.PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		cluster, err := cs.Provision().
+			CKEClusters(organizationID).
+			Get(clusterID)
+		if err != nil {
+			if util.IsCloudNotFoundError(err) {
+				return true, nil
+			}
+			if util.IsRetryableCloudError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "GETing cluster")
+		}
+
+		status := *cluster.Status.Type
+		switch status {
+		case "DELETED":
+			return true, nil
+		case "DELETING", "RUNNING", "PROVISIONING":
+			// The delete request may not have been picked up yet
+			return false, nil
+		default:
+			return false, errors.Errorf("cluster entered unexpected state %q while deleting", status)
+		}
+	})
+}
+
+// RemainingNodePools returns the sorted IDs of the node pools still listed
+// for the given cluster. A cluster that no longer exists has no node pools,
+// so a not found error while listing is not an error.
+func RemainingNodePools(cs cloud.Interface, organizationID, clusterID string) ([]string, error) {
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		if util.IsCloudNotFoundError(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "listing node pools of cluster %q", clusterID)
+	}
+
+	var ids []string
+	for _, pool := range pools {
+		ids = append(ids, string(pool.ID))
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// DeleteProvisionResult deletes whatever ProvisionCluster created, possibly
+// only partially: the cluster first, waiting for it to be removed since the
+// template can't be deleted while a cluster still references it, and then
+// the template. It ignores what's already gone.
+func DeleteProvisionResult(cs cloud.Interface, organizationID string, result *ProvisionResult, poll, timeout time.Duration) error {
+	if result.ClusterID != "" {
+		err := cs.Provision().
+			CKEClusters(organizationID).
+			Delete(result.ClusterID)
+		if err != nil && !util.IsCloudNotFoundError(err) {
+			return errors.Wrapf(err, "deleting cluster %q", result.ClusterID)
+		}
+
+		err = WaitForClusterDeleted(cs, organizationID, result.ClusterID, poll, timeout)
+		if err != nil {
+			return errors.Wrapf(err, "waiting for cluster %q to be deleted", result.ClusterID)
+		}
+	}
+
+	if result.TemplateID != "" {
+		err := cs.Provision().
+			Templates(organizationID).
+			Delete(result.TemplateID)
+		if err != nil && !util.IsCloudNotFoundError(err) {
+			return errors.Wrapf(err, "deleting template %q", result.TemplateID)
+		}
+	}
+
+	return nil
+}
+
+// WaitForAllNodePoolsRunning waits for every node pool in the given cluster
+// to report as running. Polling stops immediately if any pool enters a status
+// other than RUNNING or UPDATING.
+func WaitForAllNodePoolsRunning(cs cloud.Interface, organizationID, clusterID string, poll, timeout time.Duration) error {
+	return WaitForAllNodePoolsRunningWithContext(gocontext.Background(), cs, organizationID, clusterID, poll, timeout)
+}
+
+// WaitForAllNodePoolsRunningWithContext is the same as
+// WaitForAllNodePoolsRunning but stops waiting if ctx is done
+func WaitForAllNodePoolsRunningWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, clusterID string, poll, timeout time.Duration) error {
+	return util.PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		pools, err := cs.Provision().
+			NodePools(organizationID, clusterID).
+			List()
+		if err != nil {
+			if util.IsRetryableCloudError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "GETing node pools")
+		}
+
+		return allNodePoolsRunning(pools)
+	})
+}
+
+// allNodePoolsRunning returns true if every pool is RUNNING, false if any
+// pool is still UPDATING, and an error if any pool is in any other state.
+// Every pool is checked so that an unexpected state is never masked by an
+// UPDATING pool earlier in the list.
+func allNodePoolsRunning(pools []types.NodePool) (bool, error) {
+	running := true
+	for _, pool := range pools {
+		status := *pool.Status.Type
+		switch status {
+		case "RUNNING":
+		case "UPDATING":
+			running = false
+		default:
+			return false, errors.Errorf("node pool %q entered unexpected state %q", pool.ID, status)
+		}
+	}
+
+	return running, nil
+}
+
+// applyTemplateOverrides overrides defaults in the template request for files
+// that don't template these values in
+func applyTemplateOverrides(req *types.CreateTemplateRequest, overrides ProvisionOverrides) {
+	if overrides.TemplateName != "" {
+		name := overrides.TemplateName
+		req.Description = &name
+	}
+
+	for _, nodePool := range req.Configuration.Variable {
+		if overrides.KubernetesVersion != "" {
+			version := overrides.KubernetesVersion
+			nodePool.Default.KubernetesVersion = &version
+		}
+
+		// Masters are sized separately since etcd needs an odd count
+		mode := nodePool.Default.KubernetesMode
+		if overrides.WorkerCount > 0 && mode != nil && *mode == "worker" {
+			count := overrides.WorkerCount
+			nodePool.Default.Count = &count
+		}
+	}
+}
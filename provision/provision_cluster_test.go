@@ -0,0 +1,430 @@
+package provision
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestProvisionCluster(t *testing.T) {
+	cs := cloudfake.New()
+	cs.NewClusterStatuses = []string{"PROVISIONING", "RUNNING"}
+
+	// The fake assigns IDs to created clusters in order
+	cs.AddNodePool("cluster-1", cloudfake.NodePool{
+		ID:                "worker",
+		KubernetesMode:    "worker",
+		KubernetesVersion: "1.15.0",
+		Statuses:          []string{"RUNNING"},
+	})
+	cs.AddNodePool("cluster-1", cloudfake.NodePool{
+		ID:                "master",
+		KubernetesMode:    "master",
+		KubernetesVersion: "1.15.0",
+		Statuses:          []string{"RUNNING"},
+	})
+
+	result, err := ProvisionCluster(cs, "fake-org-id",
+		"testdata/template.json", "../resources/clusters/digital_ocean/cluster.json",
+		ProvisionOverrides{KubernetesVersion: "1.15.0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	templateID := result.TemplateID
+	if templateID == "" || result.ClusterID != "cluster-1" {
+		t.Fatalf("expected template and cluster IDs, got %q and %q", templateID, result.ClusterID)
+	}
+
+	if result.KubernetesVersion != "1.15.0" {
+		t.Errorf("expected Kubernetes version %q, got %q", "1.15.0", result.KubernetesVersion)
+	}
+
+	if want := []string{"master", "worker"}; !reflect.DeepEqual(result.NodePoolIDs, want) {
+		t.Errorf("expected node pool IDs %v, got %v", want, result.NodePoolIDs)
+	}
+
+	templateReqs := cs.CreatedTemplates()
+	if len(templateReqs) != 1 {
+		t.Fatalf("expected 1 template create request, got %d", len(templateReqs))
+	}
+
+	for name, nodePool := range templateReqs[0].Configuration.Variable {
+		if v := *nodePool.Default.KubernetesVersion; v != "1.15.0" {
+			t.Errorf("node pool %q: expected overridden Kubernetes version %q, got %q", name, "1.15.0", v)
+		}
+	}
+
+	clusterReqs := cs.CreatedClusters()
+	if len(clusterReqs) != 1 {
+		t.Fatalf("expected 1 cluster create request, got %d", len(clusterReqs))
+	}
+
+	if string(clusterReqs[0].TemplateID) != templateID {
+		t.Errorf("expected cluster request to reference template %q, got %q", templateID, clusterReqs[0].TemplateID)
+	}
+}
+
+func TestCreateTemplateAndCluster(t *testing.T) {
+	cs := cloudfake.New()
+	// Never running, so waiting for it would time out
+	cs.NewClusterStatuses = []string{"PROVISIONING"}
+
+	result, err := CreateTemplateAndCluster(cs, "fake-org-id",
+		"testdata/template.json", "../resources/clusters/digital_ocean/cluster.json",
+		ProvisionOverrides{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.TemplateID == "" || result.ClusterID != "cluster-1" {
+		t.Errorf("expected template and cluster IDs, got %q and %q", result.TemplateID, result.ClusterID)
+	}
+
+	// A failed cluster create still returns the template to tear down
+	result, err = CreateTemplateAndCluster(cs, "fake-org-id",
+		"testdata/template.json", "testdata/missing.json",
+		ProvisionOverrides{})
+	if err == nil {
+		t.Fatal("expected error for missing cluster file")
+	}
+	if result.TemplateID == "" || result.ClusterID != "" {
+		t.Errorf("expected only a template ID, got %q and %q", result.TemplateID, result.ClusterID)
+	}
+}
+
+func TestWaitForClusterRunning(t *testing.T) {
+	cs := cloudfake.New()
+	// Keep the scripts short since the waiter backs off starting at 1s
+	cs.AddCluster("provisions", "PROVISIONING", "RUNNING")
+	cs.AddCluster("errors", "PROVISIONING", "ERROR")
+
+	if err := WaitForClusterRunning(cs, "fake-org-id", "provisions"); err != nil {
+		t.Errorf("expected cluster to become running, got error: %v", err)
+	}
+
+	if err := WaitForClusterRunning(cs, "fake-org-id", "errors"); err == nil {
+		t.Error("expected error for cluster entering unexpected state")
+	}
+
+	if err := WaitForClusterRunning(cs, "fake-org-id", "missing"); err == nil {
+		t.Error("expected error for nonexistent cluster")
+	}
+
+	// UPDATING is only tolerated when asked for
+	cs.AddCluster("upgrades", "UPDATING", "RUNNING")
+	if err := WaitForClusterRunning(cs, "fake-org-id", "upgrades"); err == nil {
+		t.Error("expected error for cluster entering UPDATING by default")
+	}
+
+	cs.AddCluster("upgrades", "UPDATING", "RUNNING")
+	if err := WaitForClusterRunning(cs, "fake-org-id", "upgrades", UpgradeClusterInProgressStatuses...); err != nil {
+		t.Errorf("expected upgrading cluster to become running, got error: %v", err)
+	}
+}
+
+func TestWaitForAllNodePoolsRunning(t *testing.T) {
+	poll := time.Millisecond
+	timeout := time.Second
+
+	cs := cloudfake.New()
+	cs.AddNodePool("converges", cloudfake.NodePool{ID: "running", Statuses: []string{"RUNNING"}})
+	cs.AddNodePool("converges", cloudfake.NodePool{ID: "updating", Statuses: []string{"UPDATING", "UPDATING", "RUNNING"}})
+
+	if err := WaitForAllNodePoolsRunning(cs, "org", "converges", poll, timeout); err != nil {
+		t.Errorf("expected all node pools to become running, got error: %v", err)
+	}
+
+	// An unexpected state must fail even while other pools are still updating
+	cs.AddNodePool("fails", cloudfake.NodePool{ID: "updating", Statuses: []string{"UPDATING"}})
+	cs.AddNodePool("fails", cloudfake.NodePool{ID: "running", Statuses: []string{"RUNNING"}})
+	cs.AddNodePool("fails", cloudfake.NodePool{ID: "error", Statuses: []string{"UPDATING", "ERROR"}})
+
+	if err := WaitForAllNodePoolsRunning(cs, "org", "fails", poll, timeout); err == nil {
+		t.Error("expected error for node pool entering unexpected state")
+	}
+
+	// Pools that never finish updating must time out rather than succeed
+	cs.AddNodePool("stuck", cloudfake.NodePool{ID: "running", Statuses: []string{"RUNNING"}})
+	cs.AddNodePool("stuck", cloudfake.NodePool{ID: "updating", Statuses: []string{"UPDATING"}})
+
+	if err := WaitForAllNodePoolsRunning(cs, "org", "stuck", poll, 20*time.Millisecond); err == nil {
+		t.Error("expected timeout for node pool stuck updating")
+	}
+}
+
+func TestWaitForClusterDeleted(t *testing.T) {
+	poll := time.Millisecond
+	timeout := time.Second
+
+	cs := cloudfake.New()
+	cs.AddCluster("deleted", "RUNNING", "DELETING", "DELETED")
+	cs.AddCluster("error", "DELETING", "ERROR")
+
+	if err := WaitForClusterDeleted(cs, "org", "deleted", poll, timeout); err != nil {
+		t.Errorf("expected cluster reporting DELETED to succeed, got error: %v", err)
+	}
+
+	if err := WaitForClusterDeleted(cs, "org", "missing", poll, timeout); err != nil {
+		t.Errorf("expected cluster not found to succeed, got error: %v", err)
+	}
+
+	if err := WaitForClusterDeleted(cs, "org", "error", poll, timeout); err == nil {
+		t.Error("expected error for cluster entering unexpected state")
+	}
+}
+
+func TestRemainingNodePools(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("deleted", "RUNNING")
+	cs.AddNodePool("deleted", cloudfake.NodePool{ID: "master"})
+	cs.AddNodePool("orphaned", cloudfake.NodePool{ID: "worker-1"})
+	cs.AddNodePool("orphaned", cloudfake.NodePool{ID: "worker-0"})
+
+	if err := cs.Provision().CKEClusters("org").Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, clusterID := range []string{"deleted", "missing"} {
+		ids, err := RemainingNodePools(cs, "org", clusterID)
+		if err != nil || len(ids) != 0 {
+			t.Errorf("%s: expected no node pools, got %v, %v", clusterID, ids, err)
+		}
+	}
+
+	ids, err := RemainingNodePools(cs, "org", "orphaned")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"worker-0", "worker-1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected orphaned node pools %v, got %v", want, ids)
+	}
+}
+
+func TestValidateKubernetesVersion(t *testing.T) {
+	defer func(original func(cloud.Interface, string) ([]string, error)) {
+		listKubernetesVersions = original
+	}(listKubernetesVersions)
+
+	listKubernetesVersions = func(cs cloud.Interface, organizationID string) ([]string, error) {
+		return []string{"1.14.3", "1.15.0"}, nil
+	}
+
+	cs := cloudfake.New()
+
+	tests := []struct {
+		version string
+		valid   bool
+	}{
+		{"1.14.3", true},
+		{"v1.15.0", true},
+		{"1.15", false},
+		{"1.41.3", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		err := ValidateKubernetesVersion(cs, "org", test.version)
+		if test.valid && err != nil {
+			t.Errorf("%q: expected valid, got error: %v", test.version, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q: expected error", test.version)
+		}
+	}
+
+	listKubernetesVersions = func(cs cloud.Interface, organizationID string) ([]string, error) {
+		return nil, errors.New("unavailable")
+	}
+
+	if err := ValidateKubernetesVersion(cs, "org", "1.14.3"); err == nil {
+		t.Error("expected error when supported versions can't be listed")
+	}
+}
+
+func TestEnsureTemplate(t *testing.T) {
+	cs := cloudfake.New()
+
+	req, err := readCreateTemplateRequestFromFile("testdata/template.json", TemplateValues{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	firstID, reused, err := EnsureTemplate(cs, "org", req, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused {
+		t.Error("expected template to be created when none exists")
+	}
+
+	secondID, reused, err := EnsureTemplate(cs, "org", req, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reused || secondID != firstID {
+		t.Errorf("expected existing template %q to be reused, got %q (reused=%t)", firstID, secondID, reused)
+	}
+
+	thirdID, reused, err := EnsureTemplate(cs, "org", req, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused || thirdID == firstID {
+		t.Errorf("expected a new template without reuse, got %q (reused=%t)", thirdID, reused)
+	}
+
+	if n := len(cs.CreatedTemplates()); n != 2 {
+		t.Errorf("expected 2 template create requests, got %d", n)
+	}
+
+	applyTemplateOverrides(req, ProvisionOverrides{TemplateName: "other"})
+	otherID, reused, err := EnsureTemplate(cs, "org", req, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused || otherID == firstID || otherID == thirdID {
+		t.Errorf("expected a new template for a different name, got %q (reused=%t)", otherID, reused)
+	}
+
+	req.Description = nil
+	if _, _, err := EnsureTemplate(cs, "org", req, true); err == nil {
+		t.Error("expected error reusing a template without a name")
+	}
+}
+
+func TestFindTemplateByName(t *testing.T) {
+	cs := cloudfake.New()
+
+	template, err := FindTemplateByName(cs, "org", "e2e-fixture")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if template != nil {
+		t.Errorf("expected no template, got %q", template.ID)
+	}
+
+	req, err := readCreateTemplateRequestFromFile("testdata/template.json", TemplateValues{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	created, err := cs.Provision().Templates("org").Create(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	template, err = FindTemplateByName(cs, "org", "e2e-fixture")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if template == nil || template.ID != created.ID {
+		t.Errorf("expected to find template %q", created.ID)
+	}
+}
+
+func TestCheckTokenPermissions(t *testing.T) {
+	cs := cloudfake.New()
+
+	if err := CheckTokenPermissions(cs, "org"); err != nil {
+		t.Errorf("expected success, got error: %v", err)
+	}
+
+	cs.ListTemplatesError = cloudfake.NewHTTPError(http.StatusForbidden, "forbidden")
+	err := CheckTokenPermissions(cs, "org")
+	if err == nil || !strings.Contains(err.Error(), "lacks provision access") {
+		t.Errorf("expected permissions error, got %v", err)
+	}
+
+	cs.ListTemplatesError = cloudfake.NewHTTPError(http.StatusBadGateway, "bad gateway")
+	err = CheckTokenPermissions(cs, "org")
+	if err == nil || strings.Contains(err.Error(), "lacks provision access") {
+		t.Errorf("expected non-permissions error, got %v", err)
+	}
+}
+
+func TestProvisionClusterPartialResult(t *testing.T) {
+	cs := cloudfake.New()
+	cs.NewClusterStatuses = []string{"ERROR"}
+
+	// The IDs of what was created are returned for cleanup even on error
+	result, err := ProvisionCluster(cs, "fake-org-id",
+		"testdata/template.json", "../resources/clusters/digital_ocean/cluster.json",
+		ProvisionOverrides{})
+	if err == nil {
+		t.Fatal("expected error for cluster entering unexpected state")
+	}
+
+	if result == nil || result.TemplateID == "" || result.ClusterID == "" {
+		t.Errorf("expected template and cluster IDs in partial result, got %+v", result)
+	}
+}
+
+func TestDeleteProvisionResult(t *testing.T) {
+	cs := cloudfake.New()
+	cs.NewClusterStatuses = []string{"RUNNING"}
+
+	result, err := ProvisionCluster(cs, "fake-org-id",
+		"testdata/template.json", "../resources/clusters/digital_ocean/cluster.json",
+		ProvisionOverrides{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = DeleteProvisionResult(cs, "fake-org-id", result, time.Millisecond, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := cs.Provision().CKEClusters("fake-org-id").Get(result.ClusterID); err == nil {
+		t.Error("expected cluster to be deleted")
+	}
+	if err := cs.Provision().Templates("fake-org-id").Delete(result.TemplateID); err == nil {
+		t.Error("expected template to be deleted")
+	}
+
+	// Deleting again, or a partial result, is not an error
+	if err := DeleteProvisionResult(cs, "fake-org-id", result, time.Millisecond, 20*time.Millisecond); err != nil {
+		t.Errorf("expected deleting an already deleted result to succeed, got %v", err)
+	}
+	if err := DeleteProvisionResult(cs, "fake-org-id", &ProvisionResult{}, time.Millisecond, 20*time.Millisecond); err != nil {
+		t.Errorf("expected deleting an empty result to succeed, got %v", err)
+	}
+}
+
+func TestApplyTemplateOverridesWorkerCount(t *testing.T) {
+	req, err := readCreateTemplateRequest(RequestSource{Inline: `{
+  "configuration": {
+    "variable": {
+      "np0": {"default": {"count": 1, "kubernetes_mode": "master", "name": "master-pool-0"}},
+      "np1": {"default": {"count": 2, "kubernetes_mode": "worker", "name": "worker-pool-0"}}
+    }
+  }
+}`}, TemplateValues{})
+	if err != nil {
+		t.Fatalf("reading template: %v", err)
+	}
+
+	applyTemplateOverrides(req, ProvisionOverrides{WorkerCount: 5})
+
+	for name, want := range map[string]int32{"np0": 1, "np1": 5} {
+		if got := *req.Configuration.Variable[name].Default.Count; got != want {
+			t.Errorf("node pool %q: expected count %d, got %d", name, want, got)
+		}
+	}
+
+	_, err = ProvisionCluster(cloudfake.New(), "fake-org-id",
+		"testdata/template.json", "../resources/clusters/digital_ocean/cluster.json",
+		ProvisionOverrides{WorkerCount: -1})
+	if err == nil {
+		t.Error("expected error for negative worker count")
+	}
+}
@@ -1,161 +1,607 @@
 package provision
 
 import (
-	"encoding/json"
+	// Aliased because context is used for the suite context below
+	gocontext "context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
-	"text/template"
 	"time"
 
 	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/containership/csctl/cloud"
 	"github.com/containership/csctl/cloud/provision/types"
 
+	"github.com/mattkelly/containership-test-v2-experiment/cleanup"
 	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
 	"github.com/mattkelly/containership-test-v2-experiment/util"
 )
 
-// The provisionContext is different from the context required for other tests,
-// thus we split it out here
-type provisionContext struct {
-	ContainershipClientset cloud.Interface
-	KubernetesClientset    kubernetes.Interface
+// maxProviders is the number of providers that can be given with -provider.
+// Table entries must be declared before the flags are parsed, so one is
+// declared per index up to this limit and entries past the number of
+// providers are skipped.
+const maxProviders = 8
 
-	// AuthToken is only required because we can't pull the token back out of
-	// the Containership clientset to use it again
-	AuthToken string
+// The provisionContext extends the shared context with what's needed to
+// create a cluster and connect to it
+type provisionContext struct {
+	*testcontext.E2eTest
 
+	// KubeconfigFilename is the absolute path of the kubeconfig to write,
+	// from KUBECONFIG
 	KubeconfigFilename string
 
-	// These will be initialized at different times; however, once they are
-	// set, they should never be mutated again.
-	OrganizationID string
-	TemplateID     string
-	ClusterID      string
+	// KubeconfigOutputFilename is the absolute path to copy the kubeconfig
+	// to once written, if set
+	KubeconfigOutputFilename string
+
+	// KubeconfigWritten is set once the kubeconfig has been written
+	KubeconfigWritten bool
+
+	// ProxyBaseURL is the base URL of the Kubernetes API proxy, either for
+	// the selected environment or as overridden by flag
+	ProxyBaseURL string
+
+	// ProxyCAData is the PEM-encoded CA used to verify the proxy. If empty,
+	// the system roots are used.
+	ProxyCAData []byte
+
+	// KubeconfigAuth is what the generated kubeconfig authenticates to the
+	// proxy with
+	KubeconfigAuth kubeconfigAuth
+
+	// TemplateValues are executed against the template and cluster files
+	TemplateValues TemplateValues
+
+	// InstanceTypes are the instance types requested in the template, keyed
+	// by node pool name
+	InstanceTypes map[string]string
+
+	// NodePoolCount is the number of node pools defined in the template. It's
+	// only known if the template was created from a file rather than reused.
+	NodePoolCount      int
+	NodePoolCountKnown bool
+
+	// Result describes the primary cluster. It's created along with the
+	// cluster and populated once the cluster is running.
+	Result *ProvisionResult
+
+	// ClusterLabels are the labels from the cluster create request, which
+	// the created cluster is expected to carry
+	ClusterLabels map[string]string
+
+	// ClusterName is the name the primary cluster was created with, if
+	// overridden by flag, including any random suffix
+	ClusterName string
+
+	// TemplateSource is the template to create, if not using an existing one
+	TemplateSource RequestSource
+
+	// ClusterSources are the clusters to provision. The first is the primary
+	// cluster (ClusterID) that every spec runs against; the rest make up the
+	// fleet.
+	ClusterSources []RequestSource
+
+	// FleetClusterIDs are the IDs of the clusters created from every cluster
+	// file other than the first
+	FleetClusterIDs []string
+	fleetMu         sync.Mutex
+
+	// Providers are the providers to provision a cluster with, one each,
+	// instead of provisioning from -template and -cluster
+	Providers []string
+
+	// ProviderClusterIDs are the IDs of the clusters provisioned for each of
+	// Providers, keyed by provider
+	ProviderClusterIDs map[string]string
 }
 
 var context *provisionContext
 
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopCleanupOnSignal stops the registered cleanups from being run on SIGINT
+// or SIGTERM, see cleanup.HandleSignals
+var stopCleanupOnSignal func()
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
 // Flags
 var (
-	templateFilename string
-	clusterFilename  string
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	proxyBaseURLOverride string
+	proxyCAFilename      string
+
+	authMode           string
+	clientCertFilename string
+	clientKeyFilename  string
+
+	kubeconfigOutputFilename string
+
+	templateFilename       string
+	templateInline         string
+	clusterFilenameFlags   stringSliceFlag
+	clusterInline          string
+	clusterDir             string
+	templateValuesFilename string
+
+	providers   string
+	templateDir string
+
+	maxConcurrentProvisions int
 
 	kubernetesVersion string
+	templateName      string
+	workerCount       int
+
+	clusterName             string
+	clusterNameRandomSuffix bool
+
+	reuseTemplate bool
+	templateID    string
+
+	createAttempts int
+
+	expectedNamespaces string
+
+	checkUniformRuntime bool
+
+	expectedAgentVersion string
+
+	skipTeardown bool
+
+	pollInterval time.Duration
+	timeout      time.Duration
 )
 
 func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+	flag.StringVar(&proxyBaseURLOverride, "proxy-base-url", "", "base URL of the Kubernetes API proxy to route through (derived from -environment if not specified)")
+	flag.StringVar(&proxyCAFilename, "proxy-ca-file", "", "path to PEM-encoded CA certificate to verify the Kubernetes API proxy with (system roots are used if not specified)")
+	flag.StringVar(&authMode, "auth-mode", authModeToken, "how the generated kubeconfig authenticates to the Kubernetes API proxy (token or client-cert)")
+	flag.StringVar(&clientCertFilename, "client-cert-file", "", "path to PEM-encoded client certificate for -auth-mode client-cert")
+	flag.StringVar(&clientKeyFilename, "client-key-file", "", "path to PEM-encoded client key for -auth-mode client-cert")
+
+	flag.StringVar(&kubeconfigOutputFilename, "kubeconfig-output", "", "path to also write the provisioned cluster's kubeconfig to, e.g. to export it as a CI artifact")
+
 	// These are the base files to use
 	flag.StringVar(&templateFilename, "template", "", "path to template file to use")
-	flag.StringVar(&clusterFilename, "cluster", "", "path to cluster file to use")
+	flag.Var(&clusterFilenameFlags, "cluster", "path to cluster file to use (may be repeated to provision a fleet of clusters)")
+	flag.StringVar(&clusterDir, "cluster-dir", "", "directory of cluster files to provision a fleet of clusters from, in addition to any -cluster files")
+	flag.StringVar(&templateInline, "template-inline", "", "JSON or YAML template to use instead of -template (defaults to TEMPLATE_JSON env var)")
+	flag.StringVar(&clusterInline, "cluster-inline", "", "JSON or YAML cluster to use instead of -cluster or -cluster-dir (defaults to CLUSTER_JSON env var)")
+	flag.StringVar(&providers, "provider", "", fmt.Sprintf("comma-separated providers (up to %d) to provision a cluster with each, using the files from -template-dir, instead of using -template and -cluster", maxProviders))
+	flag.StringVar(&templateDir, "template-dir", "", "directory with a subdirectory per provider, each holding a template file and a cluster file (e.g. digital_ocean/template.json and digital_ocean/cluster.json), for use with -provider")
+	flag.StringVar(&templateValuesFilename, "template-values", "", "path to JSON or YAML file of values to execute the template and cluster files against")
 
 	// These override values in the base files
 	flag.StringVar(&kubernetesVersion, "kubernetes-version", "", "Kubernetes version to provision")
+	flag.StringVar(&templateName, "template-name", "", "name (description) to create the template with")
+	flag.IntVar(&workerCount, "worker-count", 0, "count to create every worker node pool in the template with")
+	flag.StringVar(&clusterName, "cluster-name", "", "name to create the primary cluster with instead of the one in the cluster file")
+	flag.BoolVar(&clusterNameRandomSuffix, "cluster-name-random-suffix", false, "append a random suffix to -cluster-name so that concurrent runs don't collide")
+
+	flag.BoolVar(&reuseTemplate, "reuse-template", false, "reuse an existing template with the same name instead of creating a new one (the template is then not deleted on teardown)")
+	flag.StringVar(&templateID, "template-id", "", "ID of an existing template to provision from instead of creating one from -template (the template is then not deleted on teardown)")
+
+	flag.IntVar(&maxConcurrentProvisions, "max-concurrent-provisions", constants.DefaultMaxConcurrentProvisions, "maximum number of fleet clusters to provision at once")
+	flag.IntVar(&createAttempts, "create-attempts", constants.DefaultCreateAttempts, "number of times to attempt creating the template and cluster on transient errors")
+
+	flag.StringVar(&expectedNamespaces, "expected-namespaces", strings.Join(constants.DefaultExpectedNamespaces, ","), "comma-separated namespaces that must exist after provisioning")
+	flag.StringVar(&expectedAgentVersion, "expected-agent-version", "", "version (image tag) the Containership agent is expected to be running (not checked if not specified)")
+	flag.BoolVar(&checkUniformRuntime, "check-uniform-runtime", false, "verify that every node reports the same container runtime, OS image, and kernel")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.DefaultTimeout, "timeout for waiting on node pools and the Kubernetes API")
+
+	flag.BoolVar(&skipTeardown, "skip-teardown", false, "skip deleting the provisioned cluster and template (useful for debugging)")
 }
 
 func TestProvision(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
 	// Hook up gomega to ginkgo
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "Provision Suite")
+	reporting.RunSpecs(t, "Provision Suite", junitOutputDir)
 }
 
 var _ = SynchronizedBeforeSuite(func() []byte {
 	// Run only on first node
-	token := os.Getenv("CONTAINERSHIP_TOKEN")
-	Expect(token).NotTo(BeEmpty(), "please specify a Containership Cloud token via CONTAINERSHIP_TOKEN env var")
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+	Expect(createAttempts).To(BeNumerically(">=", 1), "-create-attempts must be at least 1")
+	Expect(maxConcurrentProvisions).To(BeNumerically(">=", 1), "-max-concurrent-provisions must be at least 1")
+
+	if isFlagSet("worker-count") {
+		Expect(workerCount).To(BeNumerically(">=", 1),
+			"-worker-count must be at least 1; to provision without workers, remove the worker pools from the template")
+	}
+
+	if templateID != "" {
+		Expect(util.IsUUID(templateID)).To(BeTrue(), "-template-id must be a UUID")
+		Expect(reuseTemplate).To(BeFalse(), "-template-id and -reuse-template are mutually exclusive")
+		Expect(isFlagSet("worker-count")).To(BeFalse(), "-worker-count can't be applied to an existing template")
+	}
+
+	providerList := splitNonEmpty(providers)
+
+	var name string
+	if clusterName != "" {
+		Expect(providerList).To(BeEmpty(), "-cluster-name can't be used with -provider")
+
+		var err error
+		name, err = BuildClusterName(clusterName, clusterNameRandomSuffix)
+		Expect(err).NotTo(HaveOccurred())
+	} else {
+		Expect(clusterNameRandomSuffix).To(BeFalse(), "-cluster-name-random-suffix requires -cluster-name")
+	}
+
+	var templateSource RequestSource
+	var clusterSources []RequestSource
+	if len(providerList) > 0 {
+		Expect(templateDir).NotTo(BeEmpty(), "please specify the provider files via -template-dir")
+		Expect(len(providerList)).To(BeNumerically("<=", maxProviders), "too many providers given with -provider")
+		Expect(templateID).To(BeEmpty(), "-template-id can't be combined with -provider")
+
+		seen := make(map[string]bool)
+		for _, provider := range providerList {
+			Expect(seen[provider]).To(BeFalse(), "provider %q given more than once", provider)
+			seen[provider] = true
+
+			_, _, err := providerRequestFiles(templateDir, provider)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	} else {
+		if templateInline == "" {
+			templateInline = os.Getenv("TEMPLATE_JSON")
+		}
+		templateSource = RequestSource{
+			Filename: templateFilename,
+			Inline:   templateInline,
+		}
+		if templateID == "" {
+			Expect(templateSource.validate()).To(Succeed(), "please specify exactly one of -template or -template-inline")
+		}
+
+		if clusterInline == "" {
+			clusterInline = os.Getenv("CLUSTER_JSON")
+		}
+		var err error
+		clusterSources, err = resolveClusterSources(clusterFilenameFlags, clusterDir, clusterInline)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
 
 	kubeconfigFilename := os.Getenv("KUBECONFIG")
 	Expect(kubeconfigFilename).NotTo(BeEmpty(), "please set KUBECONFIG environment variable")
+	kubeconfigFilename, err = filepath.Abs(kubeconfigFilename)
+	Expect(err).NotTo(HaveOccurred())
+
+	if kubeconfigOutputFilename != "" {
+		kubeconfigOutputFilename, err = filepath.Abs(kubeconfigOutputFilename)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	env := constants.Environment(environment)
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(env)
+	Expect(err).NotTo(HaveOccurred())
+
+	proxyBaseURL, err := resolveProxyBaseURL(proxyBaseURLOverride, env)
+	Expect(err).NotTo(HaveOccurred())
+
+	proxyCAData, err := readCAFile(proxyCAFilename)
+	Expect(err).NotTo(HaveOccurred())
+
+	auth, err := newKubeconfigAuth(authMode, token, clientCertFilename, clientKeyFilename)
+	Expect(err).NotTo(HaveOccurred())
 
 	clientset, err := cloud.New(cloud.Config{
 		Token:            token,
-		APIBaseURL:       constants.StageAPIBaseURL,
-		AuthBaseURL:      constants.StageAuthBaseURL,
-		ProvisionBaseURL: constants.StageProvisionBaseURL,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
 	})
 	Expect(err).NotTo(HaveOccurred())
 
+	Expect(CheckTokenPermissions(clientset, orgID)).To(Succeed())
+
+	if kubernetesVersion != "" {
+		Expect(ValidateKubernetesVersion(clientset, orgID, kubernetesVersion)).
+			To(Succeed())
+	}
+
+	values, err := readTemplateValuesFromFile(templateValuesFilename)
+	Expect(err).NotTo(HaveOccurred())
+
+	// Flags take precedence over the values file
+	if kubernetesVersion != "" {
+		values.KubernetesVersion = kubernetesVersion
+	}
+	if values.OrganizationID == "" {
+		values.OrganizationID = orgID
+	}
+
 	context = &provisionContext{
-		ContainershipClientset: clientset,
-		AuthToken:              token,
-		KubeconfigFilename:     kubeconfigFilename,
-		OrganizationID:         constants.TestOrganizationID,
+		E2eTest: &testcontext.E2eTest{
+			ContainershipClientset: clientset,
+			AuthToken:              token,
+			OrganizationID:         orgID,
+		},
+		KubeconfigFilename:       kubeconfigFilename,
+		KubeconfigOutputFilename: kubeconfigOutputFilename,
+		ProxyBaseURL:             proxyBaseURL,
+		ProxyCAData:              proxyCAData,
+		KubeconfigAuth:           auth,
+		TemplateValues:           *values,
+		TemplateSource:           templateSource,
+		ClusterSources:           clusterSources,
+		ClusterName:              name,
+		Providers:                providerList,
+		ProviderClusterIDs:       make(map[string]string),
+	}
+
+	// An existing template is used as is, see the template spec
+	context.TemplateID = templateID
+
+	// Delete whatever was created so far if the run is interrupted
+	if !skipTeardown {
+		stopCleanupOnSignal = cleanup.HandleSignals()
 	}
 
 	return nil
 }, func(_ []byte) {
 	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if teardown fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "provision", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if context != nil && context.KubeconfigWritten {
+		log.Info("wrote kubeconfig", "path", context.KubeconfigFilename)
+		if context.KubeconfigOutputFilename != "" {
+			log.Info("copied kubeconfig", "path", context.KubeconfigOutputFilename)
+		}
+	}
+
+	if skipTeardown {
+		if context != nil {
+			for provider, id := range context.ProviderClusterIDs {
+				log.Info("skipping teardown of provider cluster", "provider", provider, "id", id)
+			}
+		}
+
+		return
+	}
+
+	if stopCleanupOnSignal != nil {
+		stopCleanupOnSignal()
+	}
+
+	Expect(cleanup.Run()).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
 })
 
 var _ = Describe("Provisioning a cluster", func() {
+	BeforeEach(func() {
+		if len(context.Providers) > 0 {
+			Skip("provisioning a cluster with each provider instead")
+		}
+	})
+
 	It("should successfully create the template", func() {
-		By("building template create request from file")
-		// TODO this should be reading a yaml.go template for which we template
-		// in values. Currently just reads a json file and then we override
-		// values.
-		req, err := readCreateTemplateRequestFromFile(templateFilename)
+		if templateID != "" {
+			Skip(fmt.Sprintf("using existing template %s", templateID))
+		}
+
+		log.By("building template create request from " + context.TemplateSource.String())
+		req, err := readCreateTemplateRequest(context.TemplateSource, context.TemplateValues)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(req).NotTo(BeNil())
 
-		// Override defaults
-		for name, nodePool := range req.Configuration.Variable {
-			nodePool.Default.KubernetesVersion = &kubernetesVersion
-		}
+		applyTemplateOverrides(req, ProvisionOverrides{
+			KubernetesVersion: kubernetesVersion,
+			TemplateName:      templateName,
+			WorkerCount:       int32(workerCount),
+		})
 
-		By("POSTing the template create request")
-		resp, err := context.ContainershipClientset.Provision().
-			Templates(context.OrganizationID).
-			Create(req)
+		instanceTypes, err := RequestedInstanceTypes(req)
+		Expect(err).NotTo(HaveOccurred())
+		context.InstanceTypes = instanceTypes
+
+		log.By("POSTing the template create request")
+		var id string
+		var reused bool
+		err = util.RetryOnTransient(createAttempts, constants.DefaultCreateRetryBackoff, func() error {
+			var err error
+			id, reused, err = EnsureTemplate(context.ContainershipClientset,
+				context.OrganizationID, req, reuseTemplate)
+			return err
+		})
 		Expect(err).NotTo(HaveOccurred())
-		Expect(resp).NotTo(BeNil())
+		if reused {
+			log.Info("reusing existing template", "id", id)
+		} else {
+			// A reused template may not be described by the local file
+			context.NodePoolCount = TemplateNodePoolCount(req)
+			context.NodePoolCountKnown = true
+		}
 
 		// Set template ID in global context - should never be mutated after this
-		context.TemplateID = string(resp.ID)
+		context.TemplateID = id
+
+		// A reused template may be shared with other runs
+		if !reused {
+			cleanup.Register("deleting template "+id, deleteTemplate)
+		}
 	})
 
 	It("should successfully initiate provisioning", func() {
-		By("building cluster create request from file")
-		req, err := readCreateCKEClusterRequestFromFile(clusterFilename)
+		log.By("POSTing the cluster create request")
+		result, req, err := createCluster(context.ClusterSources[0], context.ClusterName)
 		Expect(err).NotTo(HaveOccurred())
-		Expect(req).NotTo(BeNil())
 
-		// Override defaults
-		req.TemplateID = types.UUID(context.TemplateID)
+		// Set cluster ID in global context - should never be mutated after this
+		context.Result = result
+		context.ClusterID = result.ClusterID
+		context.ClusterLabels = req.Labels
+	})
+
+	// The primary cluster continues provisioning in the meantime
+	It("should successfully provision the rest of the fleet", func() {
+		if len(context.ClusterSources) < 2 {
+			Skip("only one cluster file was given")
+		}
 
-		By("POSTing the cluster create request")
-		resp, err := context.ContainershipClientset.Provision().
-			CKEClusters(context.OrganizationID).
-			Create(req)
-		Expect(err).NotTo(HaveOccurred())
-		Expect(resp).NotTo(BeNil())
+		provisions := make(map[string]func() error)
+		for i, source := range context.ClusterSources[1:] {
+			// The same file may be given more than once to provision
+			// identical clusters, so the index is what makes each unique
+			name := fmt.Sprintf("cluster %d (%s)", i+1, source)
+			timingName := fmt.Sprintf("cluster-provision/%d-%s", i+1, filepath.Base(source.Filename))
+			source := source
+
+			provisions[name] = func() error {
+				result, _, err := createCluster(source, "")
+				if err != nil {
+					return err
+				}
+				context.addFleetCluster(result.ClusterID)
 
-		// Set cluster ID in global context - should never be mutated after this
-		context.ClusterID = string(resp.ID)
+				return metrics.Time(timingName, func() error {
+					return WaitForClusterRunningWithContext(ctx, context.ContainershipClientset,
+						context.OrganizationID, result.ClusterID)
+				})
+			}
+		}
+
+		Expect(util.RunParallelLimit(maxConcurrentProvisions, provisions)).Should(Succeed())
 	})
 
 	It("should successfully write kubeconfig", func() {
+		requireSet("ClusterID", context.ClusterID)
+
 		Expect(writeKubeconfig(context.KubeconfigFilename,
+			context.ProxyBaseURL,
 			context.OrganizationID,
 			context.ClusterID,
-			context.AuthToken)).
+			context.KubeconfigAuth,
+			context.ProxyCAData)).
 			Should(Succeed())
+
+		if context.KubeconfigOutputFilename != "" {
+			Expect(copyKubeconfig(context.KubeconfigFilename, context.KubeconfigOutputFilename)).
+				Should(Succeed())
+		}
+
+		context.KubeconfigWritten = true
+
+		// Fail fast on a proxy host that's entirely wrong rather than timing
+		// out later waiting for an API that will never be ready
+		err := util.ProbeAPIServer(context.ProxyBaseURL, constants.APIServerProbeTimeout)
+		if unreachable, ok := err.(*util.UnreachableError); ok && unreachable.Timeout() {
+			log.Info("proxy is slow to respond, continuing", "host", unreachable.Host)
+		} else {
+			Expect(err).NotTo(HaveOccurred(), "proxy unreachable, check -environment and -proxy-base-url")
+		}
 	})
 
 	It("should successfully initialize a Kubernetes clientset", func() {
-		cfg, err := clientcmd.BuildConfigFromFlags("", context.KubeconfigFilename)
+		cfg, err := buildRestConfig(context.ProxyBaseURL,
+			context.OrganizationID,
+			context.ClusterID,
+			context.KubeconfigAuth,
+			context.ProxyCAData)
 		Expect(err).NotTo(HaveOccurred())
 
 		kubeClientset, err := kubernetes.NewForConfig(cfg)
@@ -166,119 +612,395 @@ var _ = Describe("Provisioning a cluster", func() {
 	})
 
 	It("should eventually attach properly (report as running)", func() {
-		Expect(waitForClusterRunning()).Should(Succeed())
+		requireSet("ClusterID", context.ClusterID)
+
+		Expect(metrics.Time("cluster-provision", waitForClusterRunning)).Should(Succeed())
+
+		Expect(PopulateProvisionResult(context.ContainershipClientset,
+			context.OrganizationID, context.Result)).
+			Should(Succeed())
+		log.Info("provisioned cluster",
+			"id", context.Result.ClusterID,
+			"kubernetesVersion", context.Result.KubernetesVersion,
+			"nodePools", strings.Join(context.Result.NodePoolIDs, ","))
+	})
+
+	// The API readiness wait ignores auth errors, so rejected credentials
+	// would otherwise only show up as a timeout
+	It("should have the kubeconfig token accepted by the proxy", func() {
+		requireSet("ClusterID", context.ClusterID)
+
+		Expect(checkTokenAccepted(context.KubeconfigFilename)).Should(Succeed())
+	})
+
+	It("should carry the labels from the create request", func() {
+		Expect(AssertClusterLabels(context.ContainershipClientset,
+			context.OrganizationID,
+			context.ClusterID,
+			context.ClusterLabels)).
+			Should(Succeed())
 	})
 
-	It("should eventually have all node pools report as running", func() {
-		Expect(waitForAllNodePoolsRunning()).Should(Succeed())
+	It("should have the requested cluster name", func() {
+		if context.ClusterName == "" {
+			Skip("-cluster-name is not set")
+		}
+		requireSet("ClusterID", context.ClusterID)
+
+		Expect(AssertClusterName(context.ContainershipClientset,
+			context.OrganizationID,
+			context.ClusterID,
+			context.ClusterName)).
+			Should(Succeed())
 	})
 
-	It("should eventually have a reachable API server", func() {
-		Expect(waitForKubernetesAPIReady()).Should(Succeed())
+	// These checks only read the context, which is no longer mutated at this
+	// point, so they're run concurrently to shorten the suite
+	It("should eventually have all node pools running, a reachable API server, and all nodes ready", func() {
+		requireSet("ClusterID", context.ClusterID)
+
+		Expect(util.RunParallel(map[string]func() error{
+			"node pools running": func() error {
+				return metrics.Time("nodepools-running", waitForAllNodePoolsRunning)
+			},
+			"API server reachable": func() error {
+				return metrics.Time("kubernetes-api-ready", waitForKubernetesAPIReady)
+			},
+			"nodes ready": func() error {
+				return metrics.Time("kubernetes-nodes-ready", waitForKubernetesNodesReady)
+			},
+		})).Should(Succeed())
 	})
 
-	It("should have all nodes ready in Kubernetes API", func() {
-		Expect(waitForKubernetesNodesReady()).Should(Succeed())
+	It("should have as many node pools as the template defines", func() {
+		if !context.NodePoolCountKnown {
+			Skip("node pools of an existing or reused template are unknown")
+		}
+		requireSet("ClusterID", context.ClusterID)
+
+		Expect(AssertNodePoolCount(context.ContainershipClientset,
+			context.OrganizationID,
+			context.ClusterID,
+			context.NodePoolCount)).
+			Should(Succeed())
+	})
+
+	It("should have as many Ready control plane nodes as the master pool is configured with", func() {
+		requireSet("ClusterID", context.ClusterID)
+
+		Expect(AssertControlPlaneNodeCount(context.ContainershipClientset,
+			context.KubernetesClientset,
+			context.OrganizationID,
+			context.ClusterID)).
+			Should(Succeed())
+	})
+
+	It("should run the same container runtime, OS image, and kernel on every node", func() {
+		if !checkUniformRuntime {
+			Skip("-check-uniform-runtime is not set")
+		}
+		requireSet("ClusterID", context.ClusterID)
+
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(util.AssertUniformRuntime(nodeList.Items)).To(Succeed())
+	})
+
+	It("should have every node pool provisioned with the requested instance type", func() {
+		if len(context.InstanceTypes) == 0 {
+			Skip("no instance types were requested")
+		}
+
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, pool := range pools {
+			want, ok := context.InstanceTypes[NodePoolName(pool)]
+			if !ok {
+				continue
+			}
+
+			Expect(AssertNodePoolInstanceType(context.ContainershipClientset,
+				context.OrganizationID,
+				context.ClusterID,
+				string(pool.ID),
+				want)).
+				Should(Succeed())
+		}
+	})
+
+	It("should eventually have all system pods ready", func() {
+		Expect(metrics.Time("system-pods-ready", func() error {
+			return util.WaitForSystemPodsReadyWithContext(ctx, context.KubernetesClientset, pollInterval, timeout)
+		})).Should(Succeed())
+	})
+
+	It("should have the expected namespaces", func() {
+		names := splitNonEmpty(expectedNamespaces)
+		if len(names) == 0 {
+			Skip("no expected namespaces were given")
+		}
+
+		Expect(util.AssertNamespacesExist(context.KubernetesClientset, names)).
+			To(Succeed())
+	})
+
+	It("should be running the expected Containership agent version", func() {
+		if expectedAgentVersion == "" {
+			Skip("no expected agent version was given")
+		}
+
+		version, err := util.GetContainershipAgentVersion(context.KubernetesClientset)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal(expectedAgentVersion), "unexpected Containership agent version")
+	})
+
+	It("should resolve cluster DNS from inside the cluster", func() {
+		deployed, err := util.IsCoreDNSDeployed(context.KubernetesClientset)
+		Expect(err).NotTo(HaveOccurred())
+		if !deployed {
+			Skip("CoreDNS is not deployed")
+		}
+
+		Expect(util.AssertDNSResolvesWithContext(ctx, context.KubernetesClientset, pollInterval, timeout)).
+			Should(Succeed())
+	})
+
+	It("should be running the requested Kubernetes version", func() {
+		version := context.TemplateValues.KubernetesVersion
+		if version == "" {
+			Skip("no Kubernetes version was requested")
+		}
+
+		Expect(AssertKubernetesVersion(context.ContainershipClientset,
+			context.KubernetesClientset,
+			context.OrganizationID,
+			context.ClusterID,
+			version)).
+			Should(Succeed())
 	})
 })
 
-func readCreateTemplateRequestFromFile(filename string) (*types.CreateTemplateRequest, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, errors.Wrap(err, "opening file")
+var _ = Describe("Provisioning a cluster with each provider", func() {
+	BeforeEach(func() {
+		if len(context.Providers) == 0 {
+			Skip("provisioning from -template and -cluster (use -provider to provision with each provider)")
+		}
+	})
+
+	entries := make([]table.TableEntry, maxProviders)
+	for i := range entries {
+		entries[i] = table.Entry(fmt.Sprintf("provider %d", i), i)
 	}
-	defer f.Close()
 
-	bytes, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading file")
+	table.DescribeTable("should provision a running cluster",
+		func(index int) {
+			if index >= len(context.Providers) {
+				Skip(fmt.Sprintf("only %d providers were given", len(context.Providers)))
+			}
+
+			provider := context.Providers[index]
+			templateFilename, clusterFilename, err := providerRequestFiles(templateDir, provider)
+			Expect(err).NotTo(HaveOccurred())
+
+			log.By(fmt.Sprintf("provisioning a %s cluster from %s and %s", provider, templateFilename, clusterFilename))
+			var result *ProvisionResult
+			err = metrics.Time("cluster-provision/"+provider, func() error {
+				var err error
+				result, err = CreateTemplateAndCluster(context.ContainershipClientset,
+					context.OrganizationID, templateFilename, clusterFilename, ProvisionOverrides{
+						TemplateValues:    context.TemplateValues,
+						KubernetesVersion: kubernetesVersion,
+						TemplateName:      templateName,
+						WorkerCount:       int32(workerCount),
+						KeepCluster:       skipTeardown,
+					})
+
+				// Whatever was created is torn down, even if provisioning
+				// failed. It's registered before waiting for the cluster so
+				// that it's also torn down if the run is interrupted.
+				if result.ClusterID != "" || result.TemplateID != "" {
+					context.ProviderClusterIDs[provider] = result.ClusterID
+					cleanup.Register(fmt.Sprintf("deleting %s cluster %s", provider, result.ClusterID), func() error {
+						return metrics.Time("cluster-delete/"+provider, func() error {
+							return DeleteProvisionResult(context.ContainershipClientset, context.OrganizationID,
+								result, constants.ProvisionInitialPollInterval, constants.ProvisionTimeout)
+						})
+					})
+				}
+				if err != nil {
+					return err
+				}
+
+				err = WaitForClusterRunningWithContext(ctx, context.ContainershipClientset,
+					context.OrganizationID, result.ClusterID)
+				if err != nil {
+					return err
+				}
+
+				return PopulateProvisionResult(context.ContainershipClientset, context.OrganizationID, result)
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			log.Info("provisioned cluster",
+				"provider", provider,
+				"id", result.ClusterID,
+				"kubernetesVersion", result.KubernetesVersion,
+				"nodePools", strings.Join(result.NodePoolIDs, ","))
+
+			Expect(WaitForAllNodePoolsRunningWithContext(ctx, context.ContainershipClientset,
+				context.OrganizationID, result.ClusterID, pollInterval, timeout)).
+				Should(Succeed())
+		},
+		entries...)
+})
+
+// requireSet skips the current spec if a value that an earlier spec is
+// responsible for setting in the context is unset. This happens when a spec
+// is run in isolation, e.g. via -ginkgo.focus.
+func requireSet(name string, value string) {
+	if value == "" {
+		Skip(fmt.Sprintf("%s not set; cannot run this spec in isolation", name))
 	}
+}
 
-	req := &types.CreateTemplateRequest{}
+func waitForClusterRunning() error {
+	return WaitForClusterRunningWithContext(ctx, context.ContainershipClientset, context.OrganizationID, context.ClusterID)
+}
 
-	err = json.Unmarshal(bytes, req)
-	if err != nil {
-		return nil, errors.Wrap(err, "unmarshalling file into request type")
+// deleteCluster deletes the cluster and waits for it to be removed, since the
+// template can't be deleted while a cluster still references it. A cluster
+// that's already gone, e.g. deleted by the delete spec, is done.
+func deleteCluster(id string) func() error {
+	return func() error {
+		err := context.ContainershipClientset.Provision().
+			CKEClusters(context.OrganizationID).
+			Delete(id)
+		if util.IsCloudNotFoundError(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "deleting cluster %q", id)
+		}
+
+		return metrics.Time("cluster-delete", func() error {
+			return waitForClusterDeleted(id)
+		})
+	}
+}
+
+func deleteTemplate() error {
+	err := context.ContainershipClientset.Provision().
+		Templates(context.OrganizationID).
+		Delete(context.TemplateID)
+	if err != nil && !util.IsCloudNotFoundError(err) {
+		return errors.Wrap(err, "deleting template")
 	}
 
-	return req, nil
+	return nil
 }
 
-func readCreateCKEClusterRequestFromFile(filename string) (*types.CreateCKEClusterRequest, error) {
-	f, err := os.Open(filename)
+// waitForClusterDeleted is only used during teardown, which must run to
+// completion even if the suite was interrupted, so it ignores ctx
+func waitForClusterDeleted(id string) error {
+	return WaitForClusterDeleted(context.ContainershipClientset,
+		context.OrganizationID, id,
+		constants.ProvisionInitialPollInterval, constants.ProvisionTimeout)
+}
+
+// createCluster creates a cluster from the given source using the suite's
+// template and registers it for deletion on teardown. If name is not empty,
+// it overrides the name in the cluster file. It's safe to call concurrently.
+func createCluster(source RequestSource, name string) (*ProvisionResult, *types.CreateCKEClusterRequest, error) {
+	req, err := readCreateCKEClusterRequest(source, context.TemplateValues)
 	if err != nil {
-		return nil, errors.Wrap(err, "opening file")
+		return nil, nil, errors.Wrap(err, "building cluster create request")
 	}
-	defer f.Close()
 
-	bytes, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading file")
+	if name != "" {
+		applyClusterName(req, name)
 	}
 
-	req := &types.CreateCKEClusterRequest{}
+	// Clusters skipping teardown are kept on purpose and aren't orphans
+	if skipTeardown {
+		applyKeepClusterLabel(req)
+	}
 
-	err = json.Unmarshal(bytes, req)
+	var result *ProvisionResult
+	err = util.RetryOnTransient(createAttempts, constants.DefaultCreateRetryBackoff, func() error {
+		var err error
+		result, err = CreateCluster(context.ContainershipClientset,
+			context.OrganizationID, context.TemplateID, req)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "unmarshalling file into request type")
+		return nil, nil, errors.Wrapf(err, "creating cluster from %s", source)
 	}
 
-	return req, nil
+	// Registered after the template so that it's deleted first
+	cleanup.Register("deleting cluster "+result.ClusterID, deleteCluster(result.ClusterID))
+
+	return result, req, nil
 }
 
-func waitForClusterRunning() error {
-	return wait.PollImmediate(1*time.Second, 20*time.Minute, func() (bool, error) {
-		cluster, err := context.ContainershipClientset.Provision().
-			CKEClusters(context.OrganizationID).
-			Get(context.ClusterID)
-		if err != nil {
-			return false, errors.Wrap(err, "GETing cluster")
-		}
+func (c *provisionContext) addFleetCluster(id string) {
+	c.fleetMu.Lock()
+	defer c.fleetMu.Unlock()
 
-		status := *cluster.Status.Type
-		switch status {
-		case "RUNNING":
-			return true, nil
-		case "PROVISIONING":
-			return false, nil
-		default:
-			return false, errors.Errorf("cluster entered unexpected state %q", status)
+	c.FleetClusterIDs = append(c.FleetClusterIDs, id)
+}
+
+// isFlagSet returns true if the named flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
 	})
+
+	return set
 }
 
-func waitForAllNodePoolsRunning() error {
-	return wait.PollImmediate(constants.DefaultPollInterval,
-		constants.DefaultTimeout,
-		func() (bool, error) {
-			pools, err := context.ContainershipClientset.Provision().
-				NodePools(context.OrganizationID, context.ClusterID).
-				List()
-			if err != nil {
-				return false, errors.Wrap(err, "GETing node pools")
-			}
+// stringSliceFlag is a flag that may be repeated, collecting every value
+type stringSliceFlag []string
 
-			running := true
-			for _, pool := range pools {
-				status := *pool.Status.Type
-				switch status {
-				case "RUNNING":
-					continue
-				case "UPDATING":
-					running = false
-					break
-				default:
-					return false, errors.Errorf("node pool %q entered unexpected state %q", pool.ID, status)
-				}
-			}
+func (f *stringSliceFlag) String() string {
+	return strings.Join(*f, ",")
+}
 
-			return running, nil
-		})
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// splitNonEmpty splits a comma-separated flag value, ignoring surrounding
+// whitespace and empty elements
+func splitNonEmpty(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func waitForAllNodePoolsRunning() error {
+	return WaitForAllNodePoolsRunningWithContext(ctx, context.ContainershipClientset,
+		context.OrganizationID, context.ClusterID, pollInterval, timeout)
 }
 
 func waitForKubernetesAPIReady() error {
-	return wait.PollImmediate(constants.DefaultPollInterval,
-		constants.DefaultTimeout,
+	return util.PollImmediateWithContext(ctx,
+		pollInterval,
+		timeout,
 		func() (bool, error) {
 			_, err := context.KubernetesClientset.CoreV1().
 				Pods(corev1.NamespaceDefault).
@@ -297,68 +1019,76 @@ func waitForKubernetesAPIReady() error {
 		})
 }
 
+// waitForKubernetesNodesReady waits for every node in every node pool to
+// register and be Ready. It runs concurrently with the other checks, so it
+// can't assume that the node pools are running or that the API is reachable
+// yet. On timeout, the error names the nodes that aren't Ready and why.
 func waitForKubernetesNodesReady() error {
-	return wait.PollImmediate(constants.DefaultPollInterval,
-		constants.DefaultTimeout,
+	expected := -1
+	registered := -1
+	var unready []util.NodeReadinessInfo
+	start := time.Now()
+
+	err := util.PollImmediateWithContext(ctx,
+		pollInterval,
+		timeout,
 		func() (bool, error) {
+			// Node pools are listed on every poll since their counts may
+			// not be reported yet while the cluster is provisioning
+			pools, err := context.ContainershipClientset.Provision().
+				NodePools(context.OrganizationID, context.ClusterID).
+				List()
+			if err != nil {
+				if util.IsRetryableCloudError(err) {
+					return false, nil
+				}
+
+				return false, errors.Wrap(err, "listing node pools to count expected nodes")
+			}
+
+			count := 0
+			for _, pool := range pools {
+				if pool.Count == nil {
+					return false, nil
+				}
+				count += int(*pool.Count)
+			}
+			expected = count
+
 			nodeList, err := context.KubernetesClientset.CoreV1().
 				Nodes().
 				List(metav1.ListOptions{})
 			if err != nil {
-				if util.IsRetryableAPIError(err) {
+				// Ignore auth errors for the same reason as when waiting
+				// for the API to be ready
+				if util.IsRetryableAPIError(err) || util.IsAuthError(err) {
 					return false, nil
 				}
 
 				return false, errors.Wrap(err, "listing nodes")
 			}
 
-			for _, node := range nodeList.Items {
-				if !util.IsNodeReady(node) {
-					return false, nil
-				}
-			}
+			registered = len(nodeList.Items)
+			unready = util.UnreadyNodes(nodeList.Items)
 
-			return true, nil
+			// Nodes may not have registered yet
+			return registered == expected && len(unready) == 0, nil
 		})
-}
 
-func writeKubeconfig(filename, organizationID, clusterID, authToken string) error {
-	const kubeconfigTemplate = `
-apiVersion: v1
-clusters:
-- cluster:
-    server: https://stage-proxy.containership.io/v3/organizations/{{.OrganizationID}}/clusters/{{.ClusterID}}/k8sapi/proxy
-  name: cs-e2e-test-cluster
-contexts:
-- context:
-    cluster: cs-e2e-test-cluster
-    user: cs-e2e-test-user
-  name: cs-e2e-test-ctx
-current-context: cs-e2e-test-ctx
-kind: Config
-preferences: {}
-users:
-- name: cs-e2e-test-user
-  user:
-    token: {{.AuthToken}}
-`
-
-	tmpl := template.Must(template.New("kubeconfig").Parse(kubeconfigTemplate))
-
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
+	if err == wait.ErrWaitTimeout {
+		waited := time.Since(start).Round(time.Second)
+		if registered < 0 {
+			return errors.Errorf("timed out after %s waiting for nodes to be ready; nodes never listed", waited)
+		}
+
+		descriptions := make([]string, 0, len(unready))
+		for _, info := range unready {
+			descriptions = append(descriptions, info.String())
+		}
 
-	values := struct {
-		OrganizationID string
-		ClusterID      string
-		AuthToken      string
-	}{
-		OrganizationID: organizationID,
-		ClusterID:      clusterID,
-		AuthToken:      authToken,
+		return errors.Errorf("timed out after %s waiting for nodes to be ready; %d of %d nodes registered, not ready: [%s]",
+			waited, registered, expected, strings.Join(descriptions, "; "))
 	}
 
-	return tmpl.Execute(f, values)
+	return err
 }
@@ -0,0 +1,279 @@
+package provision
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/containership/csctl/cloud/provision/types"
+)
+
+// TemplateValues are the values available to the template and cluster files,
+// which are executed as Go templates before being unmarshalled. For example,
+// a template file may contain "kubernetes_version": "{{.KubernetesVersion}}".
+type TemplateValues struct {
+	KubernetesVersion string `json:"kubernetes_version"`
+	OrganizationID    string `json:"organization_id"`
+	InstanceSize      string `json:"instance_size"`
+
+	// Extra holds any additional values, e.g. {{.Extra.region}}. Referencing
+	// a key that does not exist is an error.
+	Extra map[string]string `json:"extra"`
+}
+
+// RequestSource is where a template or cluster request is read from: either
+// a file or an inline JSON or YAML string, e.g. passed through an environment
+// variable in CI. Exactly one of Filename and Inline must be set.
+type RequestSource struct {
+	Filename string
+	Inline   string
+}
+
+// String returns the filename of the source, or "inline" for inline sources
+func (s RequestSource) String() string {
+	if s.Inline != "" {
+		return "inline"
+	}
+
+	return s.Filename
+}
+
+func (s RequestSource) validate() error {
+	switch {
+	case s.Filename == "" && s.Inline == "":
+		return errors.New("no request file or inline request given")
+	case s.Filename != "" && s.Inline != "":
+		return errors.Errorf("both request file %q and an inline request given; only one may be used", s.Filename)
+	}
+
+	return nil
+}
+
+func readCreateTemplateRequestFromFile(filename string, values TemplateValues) (*types.CreateTemplateRequest, error) {
+	return readCreateTemplateRequest(RequestSource{Filename: filename}, values)
+}
+
+func readCreateTemplateRequest(source RequestSource, values TemplateValues) (*types.CreateTemplateRequest, error) {
+	req := &types.CreateTemplateRequest{}
+
+	err := ReadRequest(source, values, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func readCreateCKEClusterRequestFromFile(filename string, values TemplateValues) (*types.CreateCKEClusterRequest, error) {
+	return readCreateCKEClusterRequest(RequestSource{Filename: filename}, values)
+}
+
+func readCreateCKEClusterRequest(source RequestSource, values TemplateValues) (*types.CreateCKEClusterRequest, error) {
+	req := &types.CreateCKEClusterRequest{}
+
+	err := ReadRequest(source, values, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// ReadRequestFromFile executes the given JSON or YAML file as a Go template
+// against values and unmarshals the result into req
+func ReadRequestFromFile(filename string, values TemplateValues, req interface{}) error {
+	data, err := renderFile(filename, values)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalRequest(data, req)
+}
+
+// ReadRequest reads req from source, which is executed as a Go template
+// against values and parsed as JSON or YAML like a file would be
+func ReadRequest(source RequestSource, values TemplateValues, req interface{}) error {
+	if err := source.validate(); err != nil {
+		return err
+	}
+
+	if source.Filename != "" {
+		return ReadRequestFromFile(source.Filename, values, req)
+	}
+
+	data, err := renderTemplate(source.String(), []byte(source.Inline), values)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalRequest(data, req)
+}
+
+// resolveClusterSources returns the sources of the clusters to provision,
+// either the given cluster files followed by those in dir, or the inline
+// cluster. Exactly one of the two must be given.
+func resolveClusterSources(filenames []string, dir, inline string) ([]RequestSource, error) {
+	if dir != "" {
+		dirFilenames, err := clusterFilesInDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, dirFilenames...)
+	}
+
+	switch {
+	case inline != "" && len(filenames) > 0:
+		return nil, errors.New("an inline cluster can't be combined with cluster files")
+	case inline != "":
+		return []RequestSource{{Inline: inline}}, nil
+	case len(filenames) == 0:
+		return nil, errors.New("please specify a cluster file via -cluster or -cluster-dir, or an inline cluster")
+	}
+
+	sources := make([]RequestSource, 0, len(filenames))
+	for _, filename := range filenames {
+		sources = append(sources, RequestSource{Filename: filename})
+	}
+
+	return sources, nil
+}
+
+// clusterFilesInDir returns the JSON and YAML files in dir, sorted by name.
+// Subdirectories are not searched.
+func clusterFilesInDir(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cluster file directory")
+	}
+
+	var filenames []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+			filenames = append(filenames, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// requestFileExtensions are the extensions of request files, in the order
+// they're looked for
+var requestFileExtensions = []string{".json", ".yaml", ".yml"}
+
+// providerRequestFiles returns the template and cluster files for the given
+// provider, which by convention are named template and cluster (with any
+// request file extension) in the provider's subdirectory of dir
+func providerRequestFiles(dir, provider string) (templateFilename, clusterFilename string, err error) {
+	providerDir := filepath.Join(dir, provider)
+
+	templateFilename, err = findRequestFile(providerDir, "template")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "provider %q", provider)
+	}
+
+	clusterFilename, err = findRequestFile(providerDir, "cluster")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "provider %q", provider)
+	}
+
+	return templateFilename, clusterFilename, nil
+}
+
+// findRequestFile returns the request file in dir with the given base name.
+// Exactly one must exist so that it's clear which is used.
+func findRequestFile(dir, base string) (string, error) {
+	var found []string
+	for _, ext := range requestFileExtensions {
+		filename := filepath.Join(dir, base+ext)
+		if _, err := os.Stat(filename); err == nil {
+			found = append(found, filename)
+		} else if !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "checking for %s", filename)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", errors.Errorf("no %s file in %s", base, dir)
+	case 1:
+		return found[0], nil
+	default:
+		return "", errors.Errorf("more than one %s file in %s: %s", base, dir, strings.Join(found, ", "))
+	}
+}
+
+// readTemplateValuesFromFile reads template values from a JSON or YAML file.
+// An empty filename results in empty values.
+func readTemplateValuesFromFile(filename string) (*TemplateValues, error) {
+	values := &TemplateValues{}
+	if filename == "" {
+		return values, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading template values file")
+	}
+
+	err = unmarshalRequest(data, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// renderFile reads the given file and executes it as a Go template against
+// values. Files without any template actions are returned unchanged.
+func renderFile(filename string, values TemplateValues) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening file")
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading file")
+	}
+
+	return renderTemplate(filepath.Base(filename), data, values)
+}
+
+// renderTemplate executes data as a Go template named name against values
+func renderTemplate(name string, data []byte, values TemplateValues) ([]byte, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Parse(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing template %q", name)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, values)
+	if err != nil {
+		return nil, errors.Wrapf(err, "executing template %q", name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalRequest unmarshals JSON or YAML data into v. YAML is a superset
+// of JSON, so both are decoded the same way whatever the source, and
+// nothing is decoded into v unless data parses.
+func unmarshalRequest(data []byte, v interface{}) error {
+	return errors.Wrap(yaml.Unmarshal(data, v), "unmarshalling JSON or YAML into request type")
+}
@@ -0,0 +1,211 @@
+package provision
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestReadCreateTemplateRequestFromFile(t *testing.T) {
+	want, err := readCreateTemplateRequestFromFile("testdata/template.json", TemplateValues{})
+	if err != nil {
+		t.Fatalf("reading JSON template: %v", err)
+	}
+
+	for _, filename := range []string{
+		"testdata/template.yaml",
+		"testdata/template.yml",
+		// Unrecognized extension should fall back to content detection
+		"testdata/template.txt",
+	} {
+		got, err := readCreateTemplateRequestFromFile(filename, TemplateValues{})
+		if err != nil {
+			t.Errorf("reading %s: %v", filename, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: request does not match JSON fixture\nwant: %+v\ngot:  %+v", filename, want, got)
+		}
+	}
+}
+
+func TestUnmarshalRequest(t *testing.T) {
+	for _, data := range []string{
+		`{"name": "template", "count": 3}`,
+		"name: template\ncount: 3\n",
+	} {
+		var v struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		if err := unmarshalRequest([]byte(data), &v); err != nil {
+			t.Errorf("%q: unexpected error: %v", data, err)
+			continue
+		}
+		if v.Name != "template" || v.Count != 3 {
+			t.Errorf("%q: expected name %q and count 3, got %+v", data, "template", v)
+		}
+	}
+
+	v := map[string]interface{}{}
+	if err := unmarshalRequest([]byte(`{"name": "template", "count": [`), &v); err == nil {
+		t.Fatal("expected error for content that is neither JSON nor YAML")
+	}
+	if len(v) != 0 {
+		t.Errorf("expected nothing to be decoded from invalid content, got %v", v)
+	}
+}
+
+func TestReadCreateTemplateRequestFromFileTemplated(t *testing.T) {
+	want, err := readCreateTemplateRequestFromFile("testdata/template.json", TemplateValues{})
+	if err != nil {
+		t.Fatalf("reading JSON template: %v", err)
+	}
+
+	values := TemplateValues{
+		KubernetesVersion: "1.14.3",
+		InstanceSize:      "s-2vcpu-2gb",
+		Extra: map[string]string{
+			"region": "sfo2",
+		},
+	}
+
+	got, err := readCreateTemplateRequestFromFile("testdata/template.tmpl.yaml", values)
+	if err != nil {
+		t.Fatalf("reading templated YAML template: %v", err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("templated request does not match JSON fixture\nwant: %+v\ngot:  %+v", want, got)
+	}
+
+	// Missing keys must be an error rather than silently rendering empty
+	values.Extra = nil
+	_, err = readCreateTemplateRequestFromFile("testdata/template.tmpl.yaml", values)
+	if err == nil {
+		t.Error("expected error for missing template value")
+	}
+}
+
+func TestClusterFilesInDir(t *testing.T) {
+	got, err := clusterFilesInDir("testdata")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"testdata/template.json",
+		"testdata/template.tmpl.yaml",
+		"testdata/template.yaml",
+		"testdata/template.yml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected files %v, got %v", want, got)
+	}
+
+	if _, err := clusterFilesInDir("testdata/missing"); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
+
+func TestReadCreateTemplateRequestInline(t *testing.T) {
+	want, err := readCreateTemplateRequestFromFile("testdata/template.json", TemplateValues{})
+	if err != nil {
+		t.Fatalf("reading JSON template: %v", err)
+	}
+
+	for _, filename := range []string{
+		"testdata/template.json",
+		"testdata/template.yaml",
+	} {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("reading %s: %v", filename, err)
+		}
+
+		got, err := readCreateTemplateRequest(RequestSource{Inline: string(data)}, TemplateValues{})
+		if err != nil {
+			t.Errorf("reading %s inline: %v", filename, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: inline request does not match JSON fixture\nwant: %+v\ngot:  %+v", filename, want, got)
+		}
+	}
+}
+
+func TestReadRequestSource(t *testing.T) {
+	var v map[string]interface{}
+
+	if err := ReadRequest(RequestSource{}, TemplateValues{}, &v); err == nil {
+		t.Error("expected error for empty source")
+	}
+
+	both := RequestSource{Filename: "testdata/template.json", Inline: "{}"}
+	if err := ReadRequest(both, TemplateValues{}, &v); err == nil {
+		t.Error("expected error for source with both file and inline request")
+	}
+
+	inline := RequestSource{Inline: `{"version": "{{.KubernetesVersion}}"}`}
+	if err := ReadRequest(inline, TemplateValues{KubernetesVersion: "1.14.3"}, &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v["version"] != "1.14.3" {
+		t.Errorf("expected inline request to be templated, got %v", v)
+	}
+}
+
+func TestResolveClusterSources(t *testing.T) {
+	got, err := resolveClusterSources([]string{"cluster.json"}, "testdata", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 5 || got[0].Filename != "cluster.json" || got[1].Filename != "testdata/template.json" {
+		t.Errorf("expected cluster files followed by directory files, got %v", got)
+	}
+
+	got, err = resolveClusterSources(nil, "", "{}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []RequestSource{{Inline: "{}"}}) {
+		t.Errorf("expected only the inline cluster, got %v", got)
+	}
+
+	if _, err := resolveClusterSources([]string{"cluster.json"}, "", "{}"); err == nil {
+		t.Error("expected error for both cluster files and inline cluster")
+	}
+
+	if _, err := resolveClusterSources(nil, "", ""); err == nil {
+		t.Error("expected error for no clusters")
+	}
+}
+
+func TestProviderRequestFiles(t *testing.T) {
+	templateFilename, clusterFilename, err := providerRequestFiles("testdata/providers", "digital_ocean")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if templateFilename != "testdata/providers/digital_ocean/template.json" {
+		t.Errorf("expected template file %q, got %q", "testdata/providers/digital_ocean/template.json", templateFilename)
+	}
+	if clusterFilename != "testdata/providers/digital_ocean/cluster.yaml" {
+		t.Errorf("expected cluster file %q, got %q", "testdata/providers/digital_ocean/cluster.yaml", clusterFilename)
+	}
+
+	// The files must be readable as requests
+	if _, err := readCreateTemplateRequestFromFile(templateFilename, TemplateValues{}); err != nil {
+		t.Errorf("reading provider template: %v", err)
+	}
+	if _, err := readCreateCKEClusterRequestFromFile(clusterFilename, TemplateValues{}); err != nil {
+		t.Errorf("reading provider cluster: %v", err)
+	}
+
+	for _, provider := range []string{"incomplete", "ambiguous", "missing"} {
+		if _, _, err := providerRequestFiles("testdata/providers", provider); err == nil {
+			t.Errorf("%s: expected error", provider)
+		}
+	}
+}
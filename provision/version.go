@@ -0,0 +1,132 @@
+package provision
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+)
+
+// ErrKubernetesVersionNotSet is returned by GetClusterKubernetesVersion when
+// the cloud doesn't report a version for the cluster
+var ErrKubernetesVersionNotSet = errors.New("cluster Kubernetes version not set")
+
+// GetClusterKubernetesVersion returns the Kubernetes version of the given
+// cluster as reported by the cloud. CKE clusters don't carry a version
+// themselves, so the version of the control plane (master node pool) is the
+// cluster version. ErrKubernetesVersionNotSet is returned if there is no
+// master pool or it has no version.
+func GetClusterKubernetesVersion(cs cloud.Interface, organizationID, clusterID string) (string, error) {
+	_, err := cs.Provision().
+		CKEClusters(organizationID).
+		Get(clusterID)
+	if err != nil {
+		return "", errors.Wrap(err, "GETing cluster")
+	}
+
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		return "", errors.Wrap(err, "listing node pools")
+	}
+
+	for _, pool := range pools {
+		if *pool.KubernetesMode != "master" {
+			continue
+		}
+
+		if pool.KubernetesVersion == nil || *pool.KubernetesVersion == "" {
+			return "", ErrKubernetesVersionNotSet
+		}
+
+		return *pool.KubernetesVersion, nil
+	}
+
+	return "", ErrKubernetesVersionNotSet
+}
+
+// versionMismatch is a node pool or node running a version other than the
+// requested one
+type versionMismatch struct {
+	kind   string
+	name   string
+	actual string
+}
+
+// AssertKubernetesVersion returns an error with a table of every node pool
+// (as reported by the cloud) and Kubernetes node (by kubelet version) whose
+// version doesn't match want. A leading "v" is ignored when comparing. This
+// catches the API silently falling back to a default version.
+func AssertKubernetesVersion(cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID, want string) error {
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		return errors.Wrap(err, "listing node pools")
+	}
+
+	nodeList, err := kubeClientset.CoreV1().
+		Nodes().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing nodes")
+	}
+
+	mismatches := kubernetesVersionMismatches(want, pools, nodeList.Items)
+	if len(mismatches) > 0 {
+		return errors.Errorf("Kubernetes version does not match the requested version %q:\n%s",
+			want, formatVersionMismatches(mismatches))
+	}
+
+	return nil
+}
+
+func kubernetesVersionMismatches(want string, pools []types.NodePool, nodes []corev1.Node) []versionMismatch {
+	var mismatches []versionMismatch
+	for _, pool := range pools {
+		actual := ""
+		if pool.KubernetesVersion != nil {
+			actual = *pool.KubernetesVersion
+		}
+
+		if !sameKubernetesVersion(want, actual) {
+			mismatches = append(mismatches, versionMismatch{"node pool", string(pool.ID), actual})
+		}
+	}
+
+	for _, node := range nodes {
+		actual := node.Status.NodeInfo.KubeletVersion
+		if !sameKubernetesVersion(want, actual) {
+			mismatches = append(mismatches, versionMismatch{"node", node.Name, actual})
+		}
+	}
+
+	return mismatches
+}
+
+func sameKubernetesVersion(a, b string) bool {
+	return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+}
+
+func formatVersionMismatches(mismatches []versionMismatch) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "KIND\tNAME\tVERSION")
+	for _, m := range mismatches {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.kind, m.name, m.actual)
+	}
+
+	w.Flush()
+	return buf.String()
+}
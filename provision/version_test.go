@@ -0,0 +1,102 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestKubernetesVersionMismatches(t *testing.T) {
+	pool := func(id, version string) types.NodePool {
+		return types.NodePool{
+			ID:                types.UUID(id),
+			KubernetesVersion: &version,
+		}
+	}
+
+	node := func(name, version string) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				NodeInfo: corev1.NodeSystemInfo{KubeletVersion: version},
+			},
+		}
+	}
+
+	pools := []types.NodePool{
+		pool("master", "1.15.0"),
+		pool("worker", "v1.15.0"),
+	}
+	nodes := []corev1.Node{
+		node("master-0", "v1.15.0"),
+		node("worker-0", "v1.15.0"),
+	}
+
+	if mismatches := kubernetesVersionMismatches("1.15.0", pools, nodes); len(mismatches) != 0 {
+		t.Errorf("expected no mismatches ignoring the v prefix, got %v", mismatches)
+	}
+
+	pools = append(pools, pool("fallback", "1.14.3"))
+	nodes = append(nodes, node("fallback-0", "v1.14.3"))
+
+	mismatches := kubernetesVersionMismatches("v1.15.0", pools, nodes)
+	if len(mismatches) != 2 {
+		t.Fatalf("expected 2 mismatches, got %v", mismatches)
+	}
+
+	table := formatVersionMismatches(mismatches)
+	for _, want := range []string{"node pool", "fallback", "node", "fallback-0", "1.14.3"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("expected mismatch table to contain %q, got:\n%s", want, table)
+		}
+	}
+	if strings.Contains(table, "worker-0") {
+		t.Errorf("expected only mismatches in table, got:\n%s", table)
+	}
+}
+
+func TestGetClusterKubernetesVersion(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("versioned", "RUNNING")
+	cs.AddNodePool("versioned", cloudfake.NodePool{
+		ID:                "master",
+		KubernetesMode:    "master",
+		KubernetesVersion: "1.15.0",
+		Statuses:          []string{"RUNNING"},
+	})
+	cs.AddNodePool("versioned", cloudfake.NodePool{
+		ID:                "worker",
+		KubernetesMode:    "worker",
+		KubernetesVersion: "1.14.3",
+		Statuses:          []string{"RUNNING"},
+	})
+
+	cs.AddCluster("unversioned", "RUNNING")
+	cs.AddNodePool("unversioned", cloudfake.NodePool{
+		ID:             "master",
+		KubernetesMode: "master",
+		Statuses:       []string{"RUNNING"},
+	})
+
+	version, err := GetClusterKubernetesVersion(cs, "org", "versioned")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "1.15.0" {
+		t.Errorf("expected the master pool version %q, got %q", "1.15.0", version)
+	}
+
+	if _, err := GetClusterKubernetesVersion(cs, "org", "unversioned"); err != ErrKubernetesVersionNotSet {
+		t.Errorf("expected ErrKubernetesVersionNotSet, got %v", err)
+	}
+
+	if _, err := GetClusterKubernetesVersion(cs, "org", "missing"); err == nil {
+		t.Error("expected error for missing cluster")
+	}
+}
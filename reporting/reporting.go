@@ -0,0 +1,46 @@
+// Package reporting wires up additional ginkgo reporters so that CI can
+// ingest per-spec results rather than relying on the process exit code.
+package reporting
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/reporters"
+)
+
+var nonAlphanumericRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// RunSpecs is the same as ginkgo.RunSpecs but additionally writes JUnit XML
+// results to junitOutputDir if it is not empty. See JUnitFilename for how the
+// file is named.
+func RunSpecs(t ginkgo.GinkgoTestingT, description string, junitOutputDir string) bool {
+	if junitOutputDir == "" {
+		return ginkgo.RunSpecs(t, description)
+	}
+
+	filename := JUnitFilename(junitOutputDir, description,
+		config.GinkgoConfig.ParallelNode, config.GinkgoConfig.ParallelTotal)
+
+	return ginkgo.RunSpecsWithDefaultAndCustomReporters(t, description,
+		[]ginkgo.Reporter{reporters.NewJUnitReporter(filename)})
+}
+
+// JUnitFilename returns the path of the JUnit XML file for the given suite
+// description in dir, e.g. "Provision Suite" results in
+// dir/junit_provision_suite.xml. When running in parallel, the node index is
+// included so that nodes don't clobber each other's results, e.g.
+// dir/junit_provision_suite_2.xml.
+func JUnitFilename(dir, description string, parallelNode, parallelTotal int) string {
+	name := strings.Trim(nonAlphanumericRegexp.ReplaceAllString(strings.ToLower(description), "_"), "_")
+
+	if parallelTotal > 1 {
+		name = fmt.Sprintf("%s_%d", name, parallelNode)
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("junit_%s.xml", name))
+}
@@ -0,0 +1,27 @@
+package reporting
+
+import (
+	"testing"
+)
+
+func TestJUnitFilename(t *testing.T) {
+	tests := []struct {
+		description   string
+		parallelNode  int
+		parallelTotal int
+		expected      string
+	}{
+		{"Provision Suite", 1, 1, "out/junit_provision_suite.xml"},
+		{"Node Pool Suite", 1, 1, "out/junit_node_pool_suite.xml"},
+		{"E2E Suite", 2, 3, "out/junit_e2e_suite_2.xml"},
+		{" Weird -- Name! ", 1, 1, "out/junit_weird_name.xml"},
+	}
+
+	for _, test := range tests {
+		got := JUnitFilename("out", test.description, test.parallelNode, test.parallelTotal)
+		if got != test.expected {
+			t.Errorf("%q (node %d of %d): expected %q, got %q",
+				test.description, test.parallelNode, test.parallelTotal, test.expected, got)
+		}
+	}
+}
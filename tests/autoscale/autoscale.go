@@ -0,0 +1,208 @@
+package autoscale
+
+import (
+	// Aliased because the autoscale suite declares a package-level context
+	gocontext "context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/scale"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+const (
+	// ClusterAutoscalerDeploymentName is the name of the cluster-autoscaler
+	// deployment in kube-system
+	ClusterAutoscalerDeploymentName = "cluster-autoscaler"
+
+	// LoadDeploymentName is the name of the deployment used to generate load
+	LoadDeploymentName = "cs-e2e-autoscale-load"
+)
+
+// Bounds are the autoscaling bounds of a node pool
+type Bounds struct {
+	Min int
+	Max int
+}
+
+func (b Bounds) String() string {
+	return fmt.Sprintf("[%d, %d]", b.Min, b.Max)
+}
+
+// NodePoolBounds returns the autoscaling bounds configured for the given node
+// pool, or nil if autoscaling isn't enabled for it
+func NodePoolBounds(pool types.NodePool) (*Bounds, error) {
+	autoscaling := pool.Autoscaling
+	if autoscaling == nil || autoscaling.Enabled == nil || !*autoscaling.Enabled {
+		return nil, nil
+	}
+
+	if autoscaling.MinCount == nil || autoscaling.MaxCount == nil {
+		return nil, errors.Errorf("node pool %q has autoscaling enabled without bounds", pool.ID)
+	}
+
+	bounds := &Bounds{
+		Min: int(*autoscaling.MinCount),
+		Max: int(*autoscaling.MaxCount),
+	}
+	if bounds.Min < 0 || bounds.Max < bounds.Min {
+		return nil, errors.Errorf("node pool %q has invalid autoscaling bounds %s", pool.ID, bounds)
+	}
+
+	return bounds, nil
+}
+
+// FirstAutoscalingNodePool returns the first worker pool by ID that has
+// autoscaling enabled along with its bounds, or nil if there is none
+func FirstAutoscalingNodePool(pools []types.NodePool) (*types.NodePool, *Bounds, error) {
+	workers := scale.WorkerNodePools(pools)
+	for i := range workers {
+		bounds, err := NodePoolBounds(workers[i])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if bounds != nil {
+			return &workers[i], bounds, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// IsClusterAutoscalerDeployed returns true if the cluster-autoscaler
+// deployment exists in kube-system
+func IsClusterAutoscalerDeployed(kubeClientset kubernetes.Interface) (bool, error) {
+	_, err := kubeClientset.AppsV1().
+		Deployments(metav1.NamespaceSystem).
+		Get(ClusterAutoscalerDeploymentName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "getting cluster-autoscaler deployment")
+	}
+
+	return true, nil
+}
+
+// NewLoadDeployment returns a deployment of pause pods that each request cpu,
+// pinned to the given node pool. Requesting more than the pool can fit leaves
+// pods pending, which is what triggers the cluster-autoscaler to scale up.
+func NewLoadDeployment(nodePoolID string, replicas int32, cpu resource.Quantity) *appsv1.Deployment {
+	labels := map[string]string{
+		"app": LoadDeploymentName,
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: LoadDeploymentName,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector: map[string]string{
+						constants.NodePoolIDLabelKey: nodePoolID,
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "pause",
+							Image: "k8s.gcr.io/pause:3.1",
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU: cpu,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// WaitForNodePoolScaleUp polls the given node pool's Kubernetes nodes until
+// there are more than from, returning the new count. An error is returned
+// immediately if the pool ever has more nodes than bounds allow.
+func WaitForNodePoolScaleUp(kubeClientset kubernetes.Interface, nodePoolID string, from int, bounds Bounds, poll, timeout time.Duration) (int, error) {
+	return WaitForNodePoolScaleUpWithContext(gocontext.Background(), kubeClientset, nodePoolID, from, bounds, poll, timeout)
+}
+
+// WaitForNodePoolScaleUpWithContext is the same as WaitForNodePoolScaleUp but
+// stops waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForNodePoolScaleUpWithContext(ctx gocontext.Context, kubeClientset kubernetes.Interface, nodePoolID string, from int, bounds Bounds, poll, timeout time.Duration) (int, error) {
+	return waitForNodePoolNodeCount(ctx, kubeClientset, nodePoolID, "scale up", bounds, poll, timeout,
+		func(count int) bool {
+			return count > from
+		})
+}
+
+// WaitForNodePoolScaleDown polls the given node pool's Kubernetes nodes until
+// there are fewer than from, returning the new count. An error is returned
+// immediately if the pool ever has fewer nodes than bounds allow.
+func WaitForNodePoolScaleDown(kubeClientset kubernetes.Interface, nodePoolID string, from int, bounds Bounds, poll, timeout time.Duration) (int, error) {
+	return WaitForNodePoolScaleDownWithContext(gocontext.Background(), kubeClientset, nodePoolID, from, bounds, poll, timeout)
+}
+
+// WaitForNodePoolScaleDownWithContext is the same as WaitForNodePoolScaleDown
+// but stops waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForNodePoolScaleDownWithContext(ctx gocontext.Context, kubeClientset kubernetes.Interface, nodePoolID string, from int, bounds Bounds, poll, timeout time.Duration) (int, error) {
+	return waitForNodePoolNodeCount(ctx, kubeClientset, nodePoolID, "scale down", bounds, poll, timeout,
+		func(count int) bool {
+			return count < from
+		})
+}
+
+// waitForNodePoolNodeCount waits for done to return true for the number of
+// Kubernetes nodes in the given pool, failing as soon as the count is outside
+// of bounds
+func waitForNodePoolNodeCount(ctx gocontext.Context, kubeClientset kubernetes.Interface, nodePoolID, action string, bounds Bounds, poll, timeout time.Duration, done func(count int) bool) (int, error) {
+	last := -1
+	start := time.Now()
+
+	err := util.PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		count, err := util.NodePoolNodeCount(kubeClientset, nodePoolID)
+		if err != nil {
+			if util.IsRetryableAPIError(errors.Cause(err)) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		last = count
+		if count < bounds.Min || count > bounds.Max {
+			return false, errors.Errorf("node pool %q has %d nodes, outside of its autoscaling bounds %s",
+				nodePoolID, count, bounds)
+		}
+
+		return done(count), nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return last, errors.Errorf("timed out after %s waiting for node pool %q to %s; last observed %d nodes",
+			time.Since(start).Round(time.Second), nodePoolID, action, last)
+	}
+
+	return last, err
+}
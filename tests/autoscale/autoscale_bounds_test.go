@@ -0,0 +1,132 @@
+package autoscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func nodePoolFromJSON(t *testing.T, fields string) types.NodePool {
+	var pool types.NodePool
+	if err := json.Unmarshal([]byte(fields), &pool); err != nil {
+		t.Fatalf("unmarshalling node pool: %v", err)
+	}
+
+	return pool
+}
+
+func TestNodePoolBounds(t *testing.T) {
+	tests := []struct {
+		fields  string
+		want    *Bounds
+		wantErr bool
+	}{
+		{`{"id": "pool"}`, nil, false},
+		{`{"id": "pool", "autoscaling": {"enabled": false, "min_count": 1, "max_count": 3}}`, nil, false},
+		{`{"id": "pool", "autoscaling": {"enabled": true, "min_count": 1, "max_count": 3}}`, &Bounds{Min: 1, Max: 3}, false},
+		{`{"id": "pool", "autoscaling": {"enabled": true, "min_count": 3, "max_count": 1}}`, nil, true},
+		{`{"id": "pool", "autoscaling": {"enabled": true}}`, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := NodePoolBounds(nodePoolFromJSON(t, test.fields))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.fields, test.wantErr, err)
+			continue
+		}
+
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("%s: expected bounds %v, got %v", test.fields, test.want, got)
+		}
+	}
+}
+
+func TestFirstAutoscalingNodePool(t *testing.T) {
+	pools := []types.NodePool{
+		nodePoolFromJSON(t, `{"id": "master", "kubernetes_mode": "master", "autoscaling": {"enabled": true, "min_count": 1, "max_count": 1}}`),
+		nodePoolFromJSON(t, `{"id": "worker-2", "kubernetes_mode": "worker", "autoscaling": {"enabled": true, "min_count": 2, "max_count": 4}}`),
+		nodePoolFromJSON(t, `{"id": "worker-0", "kubernetes_mode": "worker"}`),
+		nodePoolFromJSON(t, `{"id": "worker-1", "kubernetes_mode": "worker", "autoscaling": {"enabled": true, "min_count": 1, "max_count": 3}}`),
+	}
+
+	pool, bounds, err := FirstAutoscalingNodePool(pools)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pool == nil || pool.ID != "worker-1" {
+		t.Fatalf("expected autoscaling worker pool %q, got %v", "worker-1", pool)
+	}
+	if *bounds != (Bounds{Min: 1, Max: 3}) {
+		t.Errorf("expected bounds [1, 3], got %v", bounds)
+	}
+
+	pool, _, err = FirstAutoscalingNodePool(pools[:1])
+	if err != nil || pool != nil {
+		t.Errorf("expected no autoscaling worker pool, got %v, %v", pool, err)
+	}
+}
+
+func addPoolNodes(t *testing.T, kube *fake.Clientset, poolID string, n int) {
+	for i := 0; i < n; i++ {
+		_, err := kube.CoreV1().Nodes().Create(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("%s-%d", poolID, i),
+				Labels: map[string]string{
+					constants.NodePoolIDLabelKey: poolID,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("creating node: %v", err)
+		}
+	}
+}
+
+func TestWaitForNodePoolScale(t *testing.T) {
+	poll := time.Millisecond
+	timeout := 20 * time.Millisecond
+	bounds := Bounds{Min: 1, Max: 3}
+
+	kube := fake.NewSimpleClientset()
+	addPoolNodes(t, kube, "pool", 2)
+
+	count, err := WaitForNodePoolScaleUp(kube, "pool", 1, bounds, poll, timeout)
+	if err != nil || count != 2 {
+		t.Errorf("expected scale up to 2 nodes, got %d, %v", count, err)
+	}
+
+	_, err = WaitForNodePoolScaleUp(kube, "pool", 2, bounds, poll, timeout)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout without a scale up, got %v", err)
+	}
+
+	count, err = WaitForNodePoolScaleDown(kube, "pool", 3, bounds, poll, timeout)
+	if err != nil || count != 2 {
+		t.Errorf("expected scale down to 2 nodes, got %d, %v", count, err)
+	}
+
+	// Leaving the bounds fails immediately rather than waiting out the timeout
+	start := time.Now()
+	_, err = WaitForNodePoolScaleUp(kube, "pool", 0, Bounds{Min: 0, Max: 1}, poll, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "outside of its autoscaling bounds") {
+		t.Errorf("expected error for exceeding max, got %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("expected exceeding max to fail promptly, waited %s", waited)
+	}
+
+	_, err = WaitForNodePoolScaleDown(kube, "pool", 2, Bounds{Min: 3, Max: 5}, poll, timeout)
+	if err == nil || !strings.Contains(err.Error(), "outside of its autoscaling bounds") {
+		t.Errorf("expected error for going below min, got %v", err)
+	}
+}
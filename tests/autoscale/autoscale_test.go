@@ -0,0 +1,316 @@
+package autoscale
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cleanup"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+type autoscaleContext struct {
+	*testcontext.E2eTest
+
+	// Node pool ID and autoscaling bounds of the pool being autoscaled, only
+	// set if there is one and the cluster-autoscaler is deployed
+	nodePoolID string
+	bounds     Bounds
+
+	// The number of Kubernetes nodes in the pool before generating load
+	initialNodeCount int
+
+	// The number of Kubernetes nodes in the pool after scaling up
+	scaledUpNodeCount int
+
+	// Namespace created to hold the load deployment
+	namespace string
+}
+
+var context *autoscaleContext
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopCleanupOnSignal stops the registered cleanups from being run on SIGINT
+// or SIGTERM, see cleanup.HandleSignals
+var stopCleanupOnSignal func()
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	loadReplicas int
+	loadCPU      string
+
+	pollInterval     time.Duration
+	scaleUpTimeout   time.Duration
+	scaleDownTimeout time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+
+	flag.IntVar(&loadReplicas, "load-replicas", 10, "number of pods to generate load with")
+	flag.StringVar(&loadCPU, "load-cpu", "500m", "CPU requested by each load pod")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&scaleUpTimeout, "scale-up-timeout", constants.AutoscaleUpTimeout, "timeout for waiting on the node pool to scale up under load")
+	flag.DurationVar(&scaleDownTimeout, "scale-down-timeout", constants.AutoscaleDownTimeout, "timeout for waiting on the node pool to scale down once the load is removed")
+}
+
+func TestAutoscale(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Autoscale Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, scaleUpTimeout)).To(Succeed())
+	Expect(util.ValidatePollOptions(pollInterval, scaleDownTimeout)).To(Succeed())
+	Expect(loadReplicas).To(BeNumerically(">=", 1), "-load-replicas must be at least 1")
+	_, err := resource.ParseQuantity(loadCPU)
+	Expect(err).NotTo(HaveOccurred(), "-load-cpu must be a quantity, e.g. 500m")
+
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
+
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
+	Expect(err).NotTo(HaveOccurred())
+
+	clientset, err := cloud.New(cloud.Config{
+		Token:            token,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	e2eTest := &testcontext.E2eTest{
+		ContainershipClientset: clientset,
+		OrganizationID:         orgID,
+	}
+
+	kubeClientset, err := e2eTest.InitKubernetesClientset()
+	Expect(err).NotTo(HaveOccurred())
+
+	e2eTest.ClusterID, err = util.GetClusterIDFromKubernetes(kubeClientset)
+	Expect(err).NotTo(HaveOccurred())
+
+	context = &autoscaleContext{
+		E2eTest: e2eTest,
+	}
+
+	// Delete the load if the run is interrupted
+	stopCleanupOnSignal = cleanup.HandleSignals()
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if cleanup fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "autoscale", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if stopCleanupOnSignal != nil {
+		stopCleanupOnSignal()
+	}
+
+	Expect(cleanup.Run()).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
+})
+
+var _ = Describe("Autoscaling a worker node pool", func() {
+	It("should have a worker pool with autoscaling enabled", func() {
+		deployed, err := IsClusterAutoscalerDeployed(context.KubernetesClientset)
+		Expect(err).NotTo(HaveOccurred())
+		if !deployed {
+			Skip("the cluster-autoscaler is not deployed")
+		}
+
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		pool, bounds, err := FirstAutoscalingNodePool(pools)
+		Expect(err).NotTo(HaveOccurred())
+		if pool == nil {
+			Skip("no worker pools have autoscaling enabled")
+		}
+
+		count, err := util.NodePoolNodeCount(context.KubernetesClientset, string(pool.ID))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(BeNumerically(">=", bounds.Min),
+			"node pool %q starts below its autoscaling bounds %s", pool.ID, bounds)
+		Expect(count).To(BeNumerically("<=", bounds.Max),
+			"node pool %q starts above its autoscaling bounds %s", pool.ID, bounds)
+
+		log.Info("autoscaling node pool", "id", pool.ID, "bounds", bounds.String(), "nodes", count)
+
+		context.nodePoolID = string(pool.ID)
+		context.bounds = *bounds
+		context.initialNodeCount = count
+	})
+
+	It("should scale up within its bounds under load", func() {
+		skipIfNotAutoscaling()
+		if context.initialNodeCount >= context.bounds.Max {
+			Skip(fmt.Sprintf("node pool is already at its maximum of %d nodes", context.bounds.Max))
+		}
+
+		ns, err := context.KubernetesClientset.CoreV1().
+			Namespaces().
+			Create(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "cs-e2e-autoscale-",
+				},
+			})
+		Expect(err).NotTo(HaveOccurred())
+
+		context.namespace = ns.Name
+		name := ns.Name
+		cleanup.Register("deleting namespace "+name, func() error {
+			return util.DeleteNamespace(context.KubernetesClientset, name,
+				pollInterval, constants.NamespaceDeleteTimeout)
+		})
+
+		log.By(fmt.Sprintf("generating load with %d pods requesting %s CPU each", loadReplicas, loadCPU))
+		_, err = context.KubernetesClientset.AppsV1().
+			Deployments(context.namespace).
+			Create(NewLoadDeployment(context.nodePoolID, int32(loadReplicas), resource.MustParse(loadCPU)))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(metrics.Time("nodepool-autoscale-up", func() error {
+			count, err := WaitForNodePoolScaleUpWithContext(ctx, context.KubernetesClientset,
+				context.nodePoolID, context.initialNodeCount, context.bounds, pollInterval, scaleUpTimeout)
+			if err != nil {
+				return err
+			}
+
+			context.scaledUpNodeCount = count
+			return nil
+		})).Should(Succeed())
+	})
+
+	It("should scale down within its bounds once the load is removed", func() {
+		skipIfNotAutoscaling()
+		if context.scaledUpNodeCount == 0 {
+			Skip("node pool was not scaled up")
+		}
+
+		log.By("removing load")
+		err := context.KubernetesClientset.AppsV1().
+			Deployments(context.namespace).
+			Delete(LoadDeploymentName, &metav1.DeleteOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(metrics.Time("nodepool-autoscale-down", func() error {
+			_, err := WaitForNodePoolScaleDownWithContext(ctx, context.KubernetesClientset,
+				context.nodePoolID, context.scaledUpNodeCount, context.bounds, pollInterval, scaleDownTimeout)
+			return err
+		})).Should(Succeed())
+	})
+})
+
+// Each spec must skip itself because skipping one spec doesn't skip the
+// remaining specs
+func skipIfNotAutoscaling() {
+	if context.nodePoolID == "" {
+		Skip("no worker pool to autoscale")
+	}
+}
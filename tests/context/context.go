@@ -1,20 +1,111 @@
 package context
 
 import (
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
 )
 
 // The E2e test context holds state for the entire
-// e2e test run; it is not specific to any suite.
+// e2e test run; it is not specific to any suite. Suites that need extra state
+// embed it in their own context struct.
 type E2eTest struct {
 	// These should be fully initialized immediately
 	ContainershipClientset cloud.Interface
-	KubernetesClientset    kubernetes.Interface
+
+	// AuthToken is only required because we can't pull the token back out of
+	// the Containership clientset to use it again
+	AuthToken string
 
 	// These will be initialized at different times by different suites; however,
-	// once they are set, they should never be mutated again.
-	OrganizationID string
-	ClusterID      string
+	// once they are set, they should never be mutated again. For example, the
+	// provision suite only knows the cluster ID and can only build a
+	// Kubernetes clientset once it has created the cluster, whereas other
+	// suites discover everything up front.
+	KubernetesClientset kubernetes.Interface
+	OrganizationID      string
+	TemplateID          string
+	ClusterID           string
+
+	// Guards lazy initialization of KubernetesClientset
+	kubeMu sync.Mutex
+}
+
+// InitKubernetesClientset returns KubernetesClientset, first building it
+// from the kubeconfig at KUBECONFIG if it hasn't been set yet. The clientset
+// is built at most once and is safe to initialize from multiple goroutines.
+func (e *E2eTest) InitKubernetesClientset() (kubernetes.Interface, error) {
+	e.kubeMu.Lock()
+	defer e.kubeMu.Unlock()
+
+	if e.KubernetesClientset != nil {
+		return e.KubernetesClientset, nil
+	}
+
+	kubeconfigFilename := os.Getenv("KUBECONFIG")
+	if kubeconfigFilename == "" {
+		return nil, errors.New("please set KUBECONFIG environment variable")
+	}
+
+	// The kubeconfig may still be being written by a provision step
+	cfg, err := util.LoadRestConfig(kubeconfigFilename,
+		constants.DefaultPollInterval, constants.KubeconfigLoadTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "building REST config from KUBECONFIG")
+	}
+
+	kubeClientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "building Kubernetes clientset")
+	}
+
+	e.KubernetesClientset = kubeClientset
+	return kubeClientset, nil
+}
+
+// DefaultTokenEnv is the environment variable the Containership Cloud token
+// is read from by default
+const DefaultTokenEnv = "CONTAINERSHIP_TOKEN"
+
+// ReadToken returns the Containership Cloud token from the named environment
+// variable, or from DefaultTokenEnv if envVar is empty. An error naming the
+// variable is returned if it isn't set.
+func ReadToken(envVar string) (string, error) {
+	if envVar == "" {
+		envVar = DefaultTokenEnv
+	}
+
+	token := os.Getenv(envVar)
+	if token == "" {
+		return "", errors.Errorf("please specify a Containership Cloud token via %s env var", envVar)
+	}
+
+	return token, nil
+}
+
+// ResolveOrganizationID returns the organization to run against: the given
+// value (typically from a flag) if set, else the CONTAINERSHIP_ORGANIZATION_ID
+// environment variable if set, else the test organization. An error is
+// returned if the result isn't a valid UUID.
+func ResolveOrganizationID(organizationID string) (string, error) {
+	if organizationID == "" {
+		organizationID = os.Getenv("CONTAINERSHIP_ORGANIZATION_ID")
+	}
+	if organizationID == "" {
+		organizationID = constants.TestOrganizationID
+	}
+
+	if !util.IsUUID(organizationID) {
+		return "", errors.Errorf("organization ID %q is not a valid UUID", organizationID)
+	}
+
+	return organizationID, nil
 }
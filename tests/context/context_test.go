@@ -0,0 +1,178 @@
+package context
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+  user:
+    token: token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+
+func setKubeconfigEnv(t *testing.T, value string) func() {
+	return setEnv(t, "KUBECONFIG", value)
+}
+
+func setEnv(t *testing.T, key, value string) func() {
+	original, wasSet := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		if wasSet {
+			os.Setenv(key, original)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestInitKubernetesClientset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "kube.conf")
+	if err := ioutil.WriteFile(filename, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setKubeconfigEnv(t, filename)()
+
+	e := &E2eTest{}
+
+	// Parallel callers must all get the same clientset
+	var wg sync.WaitGroup
+	clientsets := make([]kubernetes.Interface, 5)
+	for i := range clientsets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			cs, err := e.InitKubernetesClientset()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			clientsets[i] = cs
+		}(i)
+	}
+	wg.Wait()
+
+	for i, cs := range clientsets {
+		if cs == nil || cs != e.KubernetesClientset {
+			t.Errorf("caller %d: expected cached clientset", i)
+		}
+	}
+}
+
+func TestInitKubernetesClientsetAlreadySet(t *testing.T) {
+	defer setKubeconfigEnv(t, "")()
+
+	kube := fake.NewSimpleClientset()
+	e := &E2eTest{KubernetesClientset: kube}
+
+	cs, err := e.InitKubernetesClientset()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs != kube {
+		t.Error("expected existing clientset to be returned")
+	}
+}
+
+func TestInitKubernetesClientsetNoKubeconfig(t *testing.T) {
+	defer setKubeconfigEnv(t, "")()
+
+	if _, err := (&E2eTest{}).InitKubernetesClientset(); err == nil {
+		t.Error("expected error when KUBECONFIG is not set")
+	}
+}
+
+func TestResolveOrganizationID(t *testing.T) {
+	const (
+		flagID = "11111111-1111-1111-1111-111111111111"
+		envID  = "22222222-2222-2222-2222-222222222222"
+	)
+
+	tests := []struct {
+		name    string
+		flag    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"flag takes precedence", flagID, envID, flagID, false},
+		{"falls back to env var", "", envID, envID, false},
+		{"falls back to test organization", "", "", constants.TestOrganizationID, false},
+		{"invalid flag", "not-a-uuid", envID, "", true},
+		{"invalid env var", "", "not-a-uuid", "", true},
+	}
+
+	for _, test := range tests {
+		restore := setEnv(t, "CONTAINERSHIP_ORGANIZATION_ID", test.env)
+		got, err := ResolveOrganizationID(test.flag)
+		restore()
+
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	restore := setEnv(t, DefaultTokenEnv, "default-token")
+	defer restore()
+	restoreOther := setEnv(t, "CS_E2E_OTHER_TOKEN", "")
+	defer restoreOther()
+
+	token, err := ReadToken("")
+	if err != nil || token != "default-token" {
+		t.Errorf("expected token from %s, got %q, %v", DefaultTokenEnv, token, err)
+	}
+
+	_, err = ReadToken("CS_E2E_OTHER_TOKEN")
+	if err == nil || !strings.Contains(err.Error(), "CS_E2E_OTHER_TOKEN") {
+		t.Errorf("expected error naming the unset variable, got %v", err)
+	}
+
+	os.Setenv("CS_E2E_OTHER_TOKEN", "other-token")
+	token, err = ReadToken("CS_E2E_OTHER_TOKEN")
+	if err != nil || token != "other-token" {
+		t.Errorf("expected token from CS_E2E_OTHER_TOKEN, got %q, %v", token, err)
+	}
+}
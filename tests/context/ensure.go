@@ -0,0 +1,71 @@
+package context
+
+import (
+	"github.com/pkg/errors"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// EnsureOptions configure how EnsureCluster finds or creates a cluster
+type EnsureOptions struct {
+	// ClusterID is an existing cluster to use. It takes precedence over
+	// discovery.
+	ClusterID string
+
+	// KubernetesClientset is used to discover an existing cluster (see
+	// util.GetClusterIDFromKubernetes). It may be nil, e.g. if KUBECONFIG
+	// isn't set.
+	KubernetesClientset kubernetes.Interface
+
+	// Provision creates a new cluster and returns its ID, e.g. by calling
+	// provision.ProvisionCluster, which can't be called from here directly
+	// since the provision suite imports this package. The ID must be returned
+	// even on error if the cluster was created, so that it can be torn down.
+	// If nil, an existing cluster is required.
+	Provision func() (clusterID string, err error)
+}
+
+// EnsureCluster returns a cluster to run against: the cluster given in opts
+// if any, else the cluster discovered from Kubernetes, else a newly
+// provisioned one. created is true if the cluster was provisioned, in which
+// case the caller is responsible for tearing it down.
+func EnsureCluster(cs cloud.Interface, organizationID string, opts EnsureOptions) (clusterID string, created bool, err error) {
+	if opts.ClusterID != "" {
+		_, err := cs.Provision().
+			CKEClusters(organizationID).
+			Get(opts.ClusterID)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "GETing cluster %q", opts.ClusterID)
+		}
+
+		return opts.ClusterID, false, nil
+	}
+
+	if opts.KubernetesClientset != nil {
+		clusterID, err := util.GetClusterIDFromKubernetes(opts.KubernetesClientset)
+		if err == nil {
+			return clusterID, false, nil
+		}
+
+		// Any other error means there is a cluster that can't be used, which
+		// shouldn't be papered over by provisioning another
+		if err != util.ErrClusterIDNotFound || opts.Provision == nil {
+			return "", false, errors.Wrap(err, "discovering cluster from Kubernetes")
+		}
+	}
+
+	if opts.Provision == nil {
+		return "", false, errors.New("no cluster given or discovered, and provisioning one is not configured")
+	}
+
+	clusterID, err = opts.Provision()
+	if err != nil {
+		return clusterID, clusterID != "", errors.Wrap(err, "provisioning cluster")
+	}
+
+	return clusterID, true, nil
+}
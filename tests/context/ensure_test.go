@@ -0,0 +1,116 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestEnsureCluster(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("existing", "RUNNING")
+
+	// Discovered IDs must be UUIDs
+	discoveredID := "3f1c8a52-6f2e-4c1d-9b7a-2d5e8f0a4c61"
+	clusterIDConfigMap := func(id string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: constants.ClusterIDConfigMapNamespace,
+				Name:      constants.ClusterIDConfigMapName,
+			},
+			Data: map[string]string{
+				constants.ClusterIDConfigMapKey: id,
+			},
+		}
+	}
+
+	discoverable := fake.NewSimpleClientset(clusterIDConfigMap(discoveredID))
+	invalid := fake.NewSimpleClientset(clusterIDConfigMap("discovered"))
+
+	provisioned := func() (string, error) {
+		return "provisioned", nil
+	}
+
+	tests := []struct {
+		name        string
+		opts        EnsureOptions
+		wantID      string
+		wantCreated bool
+		wantErr     bool
+	}{
+		{
+			name:   "given cluster",
+			opts:   EnsureOptions{ClusterID: "existing", KubernetesClientset: discoverable, Provision: provisioned},
+			wantID: "existing",
+		},
+		{
+			name:    "given cluster missing",
+			opts:    EnsureOptions{ClusterID: "missing", Provision: provisioned},
+			wantErr: true,
+		},
+		{
+			name:   "discovered cluster",
+			opts:   EnsureOptions{KubernetesClientset: discoverable, Provision: provisioned},
+			wantID: discoveredID,
+		},
+		{
+			// An unusable cluster must not be papered over by provisioning
+			name:    "invalid discovered cluster ID",
+			opts:    EnsureOptions{KubernetesClientset: invalid, Provision: provisioned},
+			wantErr: true,
+		},
+		{
+			name:        "nothing discovered",
+			opts:        EnsureOptions{KubernetesClientset: fake.NewSimpleClientset(), Provision: provisioned},
+			wantID:      "provisioned",
+			wantCreated: true,
+		},
+		{
+			name:        "no kubeconfig",
+			opts:        EnsureOptions{Provision: provisioned},
+			wantID:      "provisioned",
+			wantCreated: true,
+		},
+		{
+			name:    "provisioning not configured",
+			opts:    EnsureOptions{KubernetesClientset: fake.NewSimpleClientset()},
+			wantErr: true,
+		},
+		{
+			name: "provisioning failed after create",
+			opts: EnsureOptions{Provision: func() (string, error) {
+				return "partial", errors.New("cluster never became ready")
+			}},
+			wantID:      "partial",
+			wantCreated: true,
+			wantErr:     true,
+		},
+		{
+			name: "provisioning failed before create",
+			opts: EnsureOptions{Provision: func() (string, error) {
+				return "", errors.New("creating template")
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		id, created, err := EnsureCluster(cs, "org", test.opts)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.name, test.wantErr, err)
+		}
+		if id != test.wantID {
+			t.Errorf("%s: expected cluster %q, got %q", test.name, test.wantID, id)
+		}
+		if created != test.wantCreated {
+			t.Errorf("%s: expected created %t, got %t", test.name, test.wantCreated, created)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package delete
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/containership/csctl/cloud"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/provision"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+type deleteContext struct {
+	*testcontext.E2eTest
+
+	// IDs of the node pools belonging to the cluster before it was deleted,
+	// so that we can verify they're removed along with it
+	nodePoolIDs []string
+
+	// Label selector of test clusters for -cleanup-orphans
+	orphanSelector map[string]string
+
+	// Orphaned clusters found by -cleanup-orphans
+	orphans []provision.OrphanedCluster
+}
+
+var context *deleteContext
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	clusterID string
+
+	cleanupOrphans bool
+	olderThan      time.Duration
+	orphanSelector string
+	confirmDelete  bool
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+
+	flag.StringVar(&clusterID, "cluster-id", "", "ID of the cluster to delete (discovered from KUBECONFIG if not specified)")
+
+	flag.BoolVar(&cleanupOrphans, "cleanup-orphans", false, "instead of deleting a single cluster, find test clusters left behind by failed runs and delete them if -confirm-delete is given")
+	flag.DurationVar(&olderThan, "older-than", constants.DefaultOrphanedClusterAge, "minimum age of a test cluster for -cleanup-orphans to consider it orphaned")
+	flag.StringVar(&orphanSelector, "orphan-selector", constants.TestClusterLabelKey+"="+constants.TestClusterLabelValue, "comma-separated key=value labels identifying test clusters for -cleanup-orphans")
+	flag.BoolVar(&confirmDelete, "confirm-delete", false, "actually delete the clusters found by -cleanup-orphans (they're only listed otherwise)")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.ProvisionTimeout, "timeout for waiting on cluster deletion")
+}
+
+func TestDelete(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Delete Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+
+	var selector map[string]string
+	if cleanupOrphans {
+		Expect(clusterID).To(BeEmpty(), "-cluster-id can't be used with -cleanup-orphans")
+		Expect(olderThan).To(BeNumerically(">", 0), "-older-than must be positive")
+
+		var err error
+		selector, err = labels.ConvertSelectorToLabelsMap(orphanSelector)
+		Expect(err).NotTo(HaveOccurred(), "-orphan-selector must be comma-separated key=value labels")
+		Expect(selector).NotTo(BeEmpty(), "-orphan-selector must not be empty")
+	} else {
+		Expect(confirmDelete).To(BeFalse(), "-confirm-delete requires -cleanup-orphans")
+	}
+
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
+
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
+	Expect(err).NotTo(HaveOccurred())
+
+	clientset, err := cloud.New(cloud.Config{
+		Token:            token,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	context = &deleteContext{
+		E2eTest: &testcontext.E2eTest{
+			ContainershipClientset: clientset,
+			OrganizationID:         orgID,
+			ClusterID:              clusterID,
+		},
+		orphanSelector: selector,
+	}
+
+	// Kubernetes is only required if we have to discover the cluster ID
+	if context.ClusterID == "" && !cleanupOrphans {
+		kubeClientset, err := context.InitKubernetesClientset()
+		Expect(err).NotTo(HaveOccurred(), "please specify a cluster via -cluster-id or set KUBECONFIG environment variable")
+
+		context.ClusterID, err = util.GetClusterIDFromKubernetes(kubeClientset)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	if pushgatewayURL != "" {
+		// Best effort so that a metrics outage doesn't fail the suite
+		if err := metrics.Push(pushgatewayURL, "delete", environment); err != nil {
+			log.Error(err, "pushing timings", "url", pushgatewayURL)
+		}
+	}
+	Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
+})
+
+var _ = Describe("Deleting a cluster", func() {
+	BeforeEach(func() {
+		if cleanupOrphans {
+			Skip("only cleaning up orphaned clusters")
+		}
+	})
+
+	It("should exist with its node pools before deletion", func() {
+		_, err := context.ContainershipClientset.Provision().
+			CKEClusters(context.OrganizationID).
+			Get(context.ClusterID)
+		Expect(err).NotTo(HaveOccurred())
+
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pools).NotTo(BeEmpty())
+
+		for _, pool := range pools {
+			context.nodePoolIDs = append(context.nodePoolIDs, string(pool.ID))
+		}
+	})
+
+	It("should successfully request to delete the cluster", func() {
+		log.By(fmt.Sprintf("deleting cluster %q", context.ClusterID))
+		err := context.ContainershipClientset.Provision().
+			CKEClusters(context.OrganizationID).
+			Delete(context.ClusterID)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should go into DELETING state", func() {
+		Expect(waitForClusterDeleting()).Should(Succeed())
+	})
+
+	It("should eventually be removed from the provision API", func() {
+		Expect(metrics.Time("cluster-delete", func() error {
+			return provision.WaitForClusterDeletedWithContext(ctx, context.ContainershipClientset,
+				context.OrganizationID, context.ClusterID, pollInterval, timeout)
+		})).Should(Succeed())
+	})
+
+	It("should have all of its node pools removed", func() {
+		for _, id := range context.nodePoolIDs {
+			_, err := context.ContainershipClientset.Provision().
+				NodePools(context.OrganizationID, context.ClusterID).
+				Get(id)
+			Expect(util.IsCloudNotFoundError(err)).To(BeTrue(),
+				"expected node pool %q to be removed, got error: %v", id, err)
+		}
+	})
+
+	It("should not have any node pools left listed for the cluster", func() {
+		ids, err := provision.RemainingNodePools(context.ContainershipClientset,
+			context.OrganizationID, context.ClusterID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids).To(BeEmpty(), "node pools orphaned by deleting cluster %q", context.ClusterID)
+	})
+})
+
+var _ = Describe("Cleaning up orphaned clusters", func() {
+	BeforeEach(func() {
+		if !cleanupOrphans {
+			Skip("-cleanup-orphans not given")
+		}
+	})
+
+	It("should find the test clusters older than the threshold", func() {
+		orphans, err := provision.ListOrphanedClusters(context.ContainershipClientset,
+			context.OrganizationID, context.orphanSelector, olderThan)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, orphan := range orphans {
+			log.Info("found orphaned cluster",
+				"id", orphan.ID,
+				"template", orphan.TemplateID,
+				"createdAt", orphan.CreatedAt.UTC().Format(time.RFC3339),
+				"age", time.Since(orphan.CreatedAt).Round(time.Minute).String())
+		}
+		log.Info("found orphaned clusters", "count", len(orphans), "olderThan", olderThan.String())
+
+		context.orphans = orphans
+	})
+
+	It("should delete the orphaned clusters along with their unused templates", func() {
+		if len(context.orphans) == 0 {
+			Skip("no orphaned clusters found")
+		}
+		if !confirmDelete {
+			Skip(fmt.Sprintf("not deleting %d orphaned clusters without -confirm-delete", len(context.orphans)))
+		}
+
+		// In order since a template shared by several orphans is deleted
+		// along with the last of them. Keep going on failure so that one
+		// stuck cluster doesn't keep the rest around.
+		var failed []string
+		for _, orphan := range context.orphans {
+			log.By(fmt.Sprintf("deleting orphaned cluster %q", orphan.ID))
+			err := metrics.Time("orphaned-cluster-delete", func() error {
+				return provision.DeleteProvisionResult(context.ContainershipClientset, context.OrganizationID,
+					&provision.ProvisionResult{
+						ClusterID:  orphan.ID,
+						TemplateID: orphan.TemplateID,
+					}, pollInterval, timeout)
+			})
+			if err != nil {
+				log.Error(err, "deleting orphaned cluster", "id", orphan.ID, "template", orphan.TemplateID)
+				failed = append(failed, orphan.ID)
+			}
+		}
+
+		Expect(failed).To(BeEmpty(), "failed to delete orphaned clusters: %s", strings.Join(failed, ", "))
+	})
+})
+
+func waitForClusterDeleting() error {
+	return util.WaitForStatusOfWithContext(ctx, "cluster", pollInterval, timeout,
+		func() (string, error) {
+			cluster, err := context.ContainershipClientset.Provision().
+				CKEClusters(context.OrganizationID).
+				Get(context.ClusterID)
+			if err != nil {
+				return "", errors.Wrap(err, "GETing cluster")
+			}
+
+			return *cluster.Status.Type, nil
+		},
+		"DELETING", "RUNNING")
+}
@@ -0,0 +1,660 @@
+package drain
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+const (
+	deploymentName  = "cs-e2e-drain"
+	initialReplicas = 3
+
+	// The workload protected by a PodDisruptionBudget, which allows only one
+	// of its pods to be unavailable at a time
+	pdbDeploymentName = "cs-e2e-drain-pdb"
+	pdbReplicas       = 3
+	pdbMinAvailable   = 2
+)
+
+type drainContext struct {
+	*testcontext.E2eTest
+
+	// Namespace created to hold the test workload
+	namespace string
+
+	// Name of the worker node being drained
+	nodeName string
+
+	// Whether there are enough worker nodes to run the suite at all
+	enoughWorkers bool
+
+	// Namespace created to hold the workload protected by a
+	// PodDisruptionBudget
+	pdbNamespace string
+
+	// Name of the worker node drained while the budget is in place
+	pdbNodeName string
+}
+
+var context *drainContext
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+	environment          string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.StringVar(&environment, "environment", "", "Containership Cloud environment the cluster is in (stage or production), to label pushed timings with (unlabeled if empty)")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.DefaultTimeout, "timeout for waiting on pods and nodes")
+}
+
+func TestDrain(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Drain Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+	if environment != "" {
+		_, err := constants.ConfigForEnvironment(constants.Environment(environment))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// Only Kubernetes is required for this suite
+	e2eTest := &testcontext.E2eTest{}
+	_, err := e2eTest.InitKubernetesClientset()
+	Expect(err).NotTo(HaveOccurred())
+
+	context = &drainContext{
+		E2eTest: e2eTest,
+	}
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if teardown fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "drain", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if context == nil {
+		return
+	}
+
+	// Never leave a node cordoned, even if the suite failed
+	if context.nodeName != "" {
+		log.By(fmt.Sprintf("ensuring node %q is uncordoned", context.nodeName))
+		Expect(setNodeUnschedulable(context.nodeName, false)).To(Succeed())
+	}
+
+	if context.pdbNodeName != "" {
+		log.By(fmt.Sprintf("ensuring node %q is uncordoned", context.pdbNodeName))
+		Expect(setNodeUnschedulable(context.pdbNodeName, false)).To(Succeed())
+	}
+
+	// Deleting the namespace deletes the workload and its budget with it
+	for _, ns := range []string{context.namespace, context.pdbNamespace} {
+		if ns != "" {
+			log.By(fmt.Sprintf("deleting namespace %q", ns))
+			Expect(util.DeleteNamespace(context.KubernetesClientset, ns,
+				pollInterval, constants.NamespaceDeleteTimeout)).To(Succeed())
+		}
+	}
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
+})
+
+var _ = Describe("Draining a worker node", func() {
+	AfterEach(func() {
+		if CurrentGinkgoTestDescription().Failed && context.namespace != "" {
+			dumpWorkloadPodLogs()
+		}
+	})
+
+	It("should have at least two schedulable worker nodes", func() {
+		workers, err := listSchedulableWorkers()
+		Expect(err).NotTo(HaveOccurred())
+
+		// Evicted pods need somewhere else to go
+		context.enoughWorkers = len(workers) >= 2
+		skipIfNotEnoughWorkers()
+	})
+
+	It("should successfully deploy a workload", func() {
+		skipIfNotEnoughWorkers()
+
+		ns, err := context.KubernetesClientset.CoreV1().
+			Namespaces().
+			Create(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "cs-e2e-drain-",
+				},
+			})
+		Expect(err).NotTo(HaveOccurred())
+
+		context.namespace = ns.Name
+
+		_, err = context.KubernetesClientset.AppsV1().
+			Deployments(context.namespace).
+			Create(newDeployment(deploymentName, initialReplicas))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(util.WaitForDeploymentAvailableWithContext(ctx, context.KubernetesClientset,
+			context.namespace, deploymentName, pollInterval, timeout)).Should(Succeed())
+	})
+
+	It("should successfully cordon a worker node hosting the workload", func() {
+		skipIfNotEnoughWorkers()
+
+		pods, err := listWorkloadPods()
+		Expect(err).NotTo(HaveOccurred())
+
+		workers, err := listSchedulableWorkers()
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, pod := range pods {
+			if _, ok := workers[pod.Spec.NodeName]; ok {
+				context.nodeName = pod.Spec.NodeName
+				break
+			}
+		}
+		Expect(context.nodeName).NotTo(BeEmpty(), "no worker node is hosting the workload")
+
+		Expect(setNodeUnschedulable(context.nodeName, true)).To(Succeed())
+
+		node, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			Get(context.nodeName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(util.IsNodeCordoned(*node)).To(BeTrue())
+		Expect(util.IsNodeSchedulable(*node)).To(BeFalse())
+	})
+
+	It("should successfully evict the workload from the cordoned node", func() {
+		skipIfNotEnoughWorkers()
+
+		pods, err := listWorkloadPods()
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, pod := range pods {
+			if pod.Spec.NodeName != context.nodeName {
+				continue
+			}
+
+			log.By(fmt.Sprintf("evicting pod %q", pod.Name))
+			err := context.KubernetesClientset.CoreV1().
+				Pods(pod.Namespace).
+				Evict(&policyv1beta1.Eviction{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: pod.Namespace,
+						Name:      pod.Name,
+					},
+				})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(metrics.Time("node-drain", func() error {
+			return waitForNoWorkloadPodsOnNode(context.nodeName)
+		})).Should(Succeed())
+		Expect(util.WaitForDeploymentAvailableWithContext(ctx, context.KubernetesClientset,
+			context.namespace, deploymentName, pollInterval, timeout)).Should(Succeed())
+	})
+
+	It("should not schedule new pods onto the cordoned node", func() {
+		skipIfNotEnoughWorkers()
+
+		Expect(scaleDeployment(initialReplicas * 2)).To(Succeed())
+		Expect(util.WaitForDeploymentAvailableWithContext(ctx, context.KubernetesClientset,
+			context.namespace, deploymentName, pollInterval, timeout)).Should(Succeed())
+
+		pods, err := listWorkloadPods()
+		Expect(err).NotTo(HaveOccurred())
+		for _, pod := range pods {
+			Expect(pod.Spec.NodeName).NotTo(Equal(context.nodeName),
+				"pod %q was scheduled onto cordoned node", pod.Name)
+		}
+	})
+
+	It("should become schedulable again once uncordoned", func() {
+		skipIfNotEnoughWorkers()
+
+		Expect(setNodeUnschedulable(context.nodeName, false)).To(Succeed())
+
+		Expect(util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+			node, err := context.KubernetesClientset.CoreV1().
+				Nodes().
+				Get(context.nodeName, metav1.GetOptions{})
+			if err != nil {
+				return false, errors.Wrapf(err, "getting node %q", context.nodeName)
+			}
+
+			return !util.IsNodeCordoned(*node) && util.IsNodeSchedulable(*node), nil
+		})).Should(Succeed())
+	})
+})
+
+var _ = Describe("Draining a worker node hosting a workload with a PodDisruptionBudget", func() {
+	It("should have enough schedulable worker nodes to satisfy the budget elsewhere", func() {
+		workers, err := listSchedulableWorkers()
+		Expect(err).NotTo(HaveOccurred())
+
+		// Evicted pods need somewhere else to go for the budget to be met
+		context.enoughWorkers = len(workers) >= 2
+		skipIfNotEnoughWorkers()
+	})
+
+	It("should successfully deploy a workload with a PodDisruptionBudget", func() {
+		skipIfNotEnoughWorkers()
+
+		ns, err := context.KubernetesClientset.CoreV1().
+			Namespaces().
+			Create(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "cs-e2e-drain-pdb-",
+				},
+			})
+		Expect(err).NotTo(HaveOccurred())
+
+		context.pdbNamespace = ns.Name
+
+		_, err = context.KubernetesClientset.AppsV1().
+			Deployments(context.pdbNamespace).
+			Create(newDeployment(pdbDeploymentName, pdbReplicas))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = context.KubernetesClientset.PolicyV1beta1().
+			PodDisruptionBudgets(context.pdbNamespace).
+			Create(newPodDisruptionBudget(pdbDeploymentName, pdbMinAvailable))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(util.WaitForDeploymentAvailableWithContext(ctx, context.KubernetesClientset,
+			context.pdbNamespace, pdbDeploymentName, pollInterval, timeout)).Should(Succeed())
+	})
+
+	It("should keep the budget's minimum available while draining a node hosting the workload", func() {
+		skipIfNotEnoughWorkers()
+		Expect(context.pdbNamespace).NotTo(BeEmpty(), "workload was not deployed")
+
+		pods, err := listPods(context.pdbNamespace, pdbDeploymentName)
+		Expect(err).NotTo(HaveOccurred())
+
+		workers, err := listSchedulableWorkers()
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, pod := range pods {
+			if _, ok := workers[pod.Spec.NodeName]; ok {
+				context.pdbNodeName = pod.Spec.NodeName
+				break
+			}
+		}
+		Expect(context.pdbNodeName).NotTo(BeEmpty(), "no worker node is hosting the workload")
+
+		Expect(setNodeUnschedulable(context.pdbNodeName, true)).To(Succeed())
+		defer func() {
+			// Uncordon as soon as the spec is done rather than leaving the
+			// node out of rotation until the AfterSuite, which stays as a
+			// safety net. Best effort since the spec may already be failing.
+			log.By(fmt.Sprintf("uncordoning node %q", context.pdbNodeName))
+			if err := setNodeUnschedulable(context.pdbNodeName, false); err != nil {
+				log.Error(err, "uncordoning node", "name", context.pdbNodeName)
+			}
+		}()
+
+		stop := make(chan struct{})
+		lowest := watchLowestAvailable(context.pdbNamespace, pdbDeploymentName, stop)
+
+		drainErr := metrics.Time("node-drain-pdb", func() error {
+			for _, pod := range pods {
+				if pod.Spec.NodeName != context.pdbNodeName {
+					continue
+				}
+
+				log.By(fmt.Sprintf("evicting pod %q", pod.Name))
+				if err := evictRespectingBudget(pod); err != nil {
+					return err
+				}
+
+				// Give the budget room again before evicting the next pod,
+				// the same as a drain would
+				err := util.WaitForDeploymentAvailableWithContext(ctx, context.KubernetesClientset,
+					context.pdbNamespace, pdbDeploymentName, pollInterval, timeout)
+				if err != nil {
+					return err
+				}
+			}
+
+			return waitForNoPodsOnNode(context.pdbNamespace, pdbDeploymentName, context.pdbNodeName)
+		})
+
+		close(stop)
+		Expect(drainErr).NotTo(HaveOccurred())
+		Expect(<-lowest).To(BeNumerically(">=", pdbMinAvailable),
+			"available replicas dropped below the PodDisruptionBudget minimum during the drain (-1 means never observed)")
+	})
+})
+
+// Each spec must skip itself because skipping one spec doesn't skip the
+// remaining specs
+func skipIfNotEnoughWorkers() {
+	if !context.enoughWorkers {
+		Skip("need at least two schedulable worker nodes to drain one")
+	}
+}
+
+func newDeployment(name string, replicas int32) *appsv1.Deployment {
+	labels := map[string]string{
+		"app": name,
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "pause",
+							Image: "k8s.gcr.io/pause:3.1",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newPodDisruptionBudget returns a budget covering the pods of the given
+// deployment created by newDeployment
+func newPodDisruptionBudget(name string, minAvailable int) *policyv1beta1.PodDisruptionBudget {
+	minAvailableValue := intstr.FromInt(minAvailable)
+
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailableValue,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": name,
+				},
+			},
+		},
+	}
+}
+
+// evictRespectingBudget evicts the given pod, retrying for as long as the
+// eviction is refused because it would violate a PodDisruptionBudget
+func evictRespectingBudget(pod corev1.Pod) error {
+	return util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+		err := context.KubernetesClientset.CoreV1().
+			Pods(pod.Namespace).
+			Evict(&policyv1beta1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: pod.Namespace,
+					Name:      pod.Name,
+				},
+			})
+		switch {
+		case err == nil, apierrs.IsNotFound(err):
+			return true, nil
+		case apierrs.IsTooManyRequests(err):
+			log.Info("eviction refused by PodDisruptionBudget, retrying", "pod", pod.Name)
+			return false, nil
+		case util.IsRetryableAPIError(err):
+			return false, nil
+		default:
+			return false, errors.Wrapf(err, "evicting pod %q", pod.Name)
+		}
+	})
+}
+
+// watchLowestAvailable samples the available replicas of the given deployment
+// until stop is closed, then sends the lowest number observed, or -1 if it was
+// never observed. Sampling can miss a dip shorter than the poll interval.
+func watchLowestAvailable(namespace, name string, stop <-chan struct{}) <-chan int32 {
+	lowest := make(chan int32, 1)
+
+	go func() {
+		defer GinkgoRecover()
+
+		observed := int32(-1)
+		wait.Until(func() {
+			deployment, err := context.KubernetesClientset.AppsV1().
+				Deployments(namespace).
+				Get(name, metav1.GetOptions{})
+			if err != nil {
+				// Only costs a sample
+				return
+			}
+
+			if available := deployment.Status.AvailableReplicas; observed == -1 || available < observed {
+				observed = available
+			}
+		}, pollInterval, stop)
+
+		lowest <- observed
+	}()
+
+	return lowest
+}
+
+// listSchedulableWorkers returns the schedulable worker nodes keyed by name
+func listSchedulableWorkers() (map[string]corev1.Node, error) {
+	nodeList, err := context.KubernetesClientset.CoreV1().
+		Nodes().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing nodes")
+	}
+
+	workers := make(map[string]corev1.Node)
+	for _, node := range nodeList.Items {
+		if _, isMaster := node.Labels[constants.MasterRoleLabelKey]; isMaster {
+			continue
+		}
+
+		if util.IsNodeSchedulable(node) {
+			workers[node.Name] = node
+		}
+	}
+
+	return workers, nil
+}
+
+// dumpWorkloadPodLogs writes the logs of every workload pod to the test
+// output for post-mortem debugging. Any error is only logged since this runs
+// after a failure that's already been reported.
+func dumpWorkloadPodLogs() {
+	logs, err := util.CollectPodLogs(context.KubernetesClientset,
+		context.namespace, "app="+deploymentName, 0)
+	if err != nil {
+		log.Error(err, "failed to collect workload pod logs")
+		return
+	}
+
+	for name, podLogs := range logs {
+		fmt.Fprintf(GinkgoWriter, "---- logs for pod %q ----\n%s\n", name, podLogs)
+	}
+}
+
+func listWorkloadPods() ([]corev1.Pod, error) {
+	return listPods(context.namespace, deploymentName)
+}
+
+// listPods returns the pods of the given deployment created by newDeployment
+func listPods(namespace, name string) ([]corev1.Pod, error) {
+	podList, err := context.KubernetesClientset.CoreV1().
+		Pods(namespace).
+		List(metav1.ListOptions{
+			LabelSelector: "app=" + name,
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing workload pods")
+	}
+
+	return podList.Items, nil
+}
+
+func setNodeUnschedulable(name string, unschedulable bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		node.Spec.Unschedulable = unschedulable
+		_, err = context.KubernetesClientset.CoreV1().
+			Nodes().
+			Update(node)
+		return err
+	})
+}
+
+func scaleDeployment(replicas int32) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := context.KubernetesClientset.AppsV1().
+			Deployments(context.namespace).
+			Get(deploymentName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		deployment.Spec.Replicas = &replicas
+		_, err = context.KubernetesClientset.AppsV1().
+			Deployments(context.namespace).
+			Update(deployment)
+		return err
+	})
+}
+
+func waitForNoWorkloadPodsOnNode(nodeName string) error {
+	return waitForNoPodsOnNode(context.namespace, deploymentName, nodeName)
+}
+
+func waitForNoPodsOnNode(namespace, name, nodeName string) error {
+	return util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+		pods, err := listPods(namespace, name)
+		if err != nil {
+			return false, err
+		}
+
+		for _, pod := range pods {
+			if pod.Spec.NodeName == nodeName {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+}
@@ -0,0 +1,175 @@
+// Package nodeconfig verifies that the Kubernetes labels and taints declared
+// on a node pool actually land on the pool's nodes.
+//
+// Nodes are mapped to the cloud node pool they belong to by the
+// constants.NodePoolIDLabelKey ("containership.io/node-pool-id") label, which
+// the Containership agent sets to the node pool ID on every node it
+// bootstraps. Nodes without this label can't be attributed to a pool.
+package nodeconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// NodeConfig is the Kubernetes configuration a node pool declares for its
+// nodes
+type NodeConfig struct {
+	Labels map[string]string `json:"labels"`
+	Taints []corev1.Taint    `json:"taints"`
+}
+
+// IsEmpty returns true if the config declares no labels or taints
+func (c NodeConfig) IsEmpty() bool {
+	return len(c.Labels) == 0 && len(c.Taints) == 0
+}
+
+// NodeConfigForPool returns the labels and taints declared by the given
+// node pool. types.NodePool has no Labels or Taints fields, so they're read
+// from the pool's JSON. A pool that declares neither results in an empty
+// config.
+func NodeConfigForPool(pool types.NodePool) (*NodeConfig, error) {
+	config, err := readNodeConfig(pool)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading node pool %q", pool.ID)
+	}
+
+	return config, nil
+}
+
+func readNodeConfig(pool interface{}) (*NodeConfig, error) {
+	config := &NodeConfig{}
+	if err := util.RoundTripJSON(pool, config); err != nil {
+		return nil, errors.Wrap(err, "reading labels and taints")
+	}
+
+	return config, nil
+}
+
+// DiffNode returns one diff-style line for each label or taint in config
+// that the node doesn't carry. Missing labels and taints are prefixed with
+// "-" and labels with the wrong value with "~". Labels and taints on the node
+// that aren't in config are ignored, since Kubernetes and the Containership
+// agent add their own. An empty result means the node matches.
+func DiffNode(node corev1.Node, config NodeConfig) []string {
+	var diff []string
+
+	keys := make([]string, 0, len(config.Labels))
+	for k := range config.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		want := config.Labels[k]
+		got, ok := node.Labels[k]
+
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("- label %s=%s", k, want))
+		case got != want:
+			diff = append(diff, fmt.Sprintf("~ label %s: expected %q, got %q", k, want, got))
+		}
+	}
+
+	for _, want := range config.Taints {
+		if !hasTaint(node, want) {
+			diff = append(diff, fmt.Sprintf("- taint %s", want.ToString()))
+		}
+	}
+
+	return diff
+}
+
+// DiffNodePool returns a diff (see DiffNode) for each node in nodes that
+// belongs to the given pool, with each line prefixed by the node name. Nodes
+// that are being removed or are cordoned for replacement are skipped, since
+// old and new nodes briefly coexist when a pool's nodes are recreated. If no
+// node in the pool remains, the diff says so.
+func DiffNodePool(nodes []corev1.Node, nodePoolID string, config NodeConfig) []string {
+	var diff []string
+	found := false
+	for _, node := range util.FilterNodesByPool(nodes, nodePoolID) {
+		if node.DeletionTimestamp != nil || util.IsNodeCordoned(node) {
+			continue
+		}
+		found = true
+
+		for _, line := range DiffNode(node, config) {
+			diff = append(diff, fmt.Sprintf("node %q: %s", node.Name, line))
+		}
+	}
+
+	if !found {
+		return []string{"no nodes found"}
+	}
+
+	return diff
+}
+
+func hasTaint(node corev1.Node, want corev1.Taint) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == want.Key && taint.Value == want.Value && taint.Effect == want.Effect {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zoneKeys are the provider config keys that may hold the zones of a node
+// pool, which providers name differently
+var zoneKeys = []string{"zones", "availability_zones", "zone", "availability_zone"}
+
+// NodePoolZones returns the sorted zones configured for the given node pool,
+// or nil if its provider config doesn't specify any. A pool may be configured
+// with either a list of zones or a single one.
+func NodePoolZones(pool types.NodePool) ([]string, error) {
+	zones, err := readNodePoolZones(pool)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading node pool %q", pool.ID)
+	}
+
+	return zones, nil
+}
+
+func readNodePoolZones(pool interface{}) ([]string, error) {
+	// types.NodePool has no ProviderConfig field
+	var fields struct {
+		ProviderConfig map[string]json.RawMessage `json:"provider_config"`
+	}
+	if err := util.RoundTripJSON(pool, &fields); err != nil {
+		return nil, errors.Wrap(err, "reading provider config")
+	}
+
+	for _, key := range zoneKeys {
+		raw, ok := fields.ProviderConfig[key]
+		if !ok {
+			continue
+		}
+
+		var zones []string
+		if err := json.Unmarshal(raw, &zones); err != nil {
+			var zone string
+			if err := json.Unmarshal(raw, &zone); err != nil {
+				return nil, errors.Errorf("provider config %q must be a zone or a list of zones, got %s", key, raw)
+			}
+
+			zones = []string{zone}
+		}
+
+		sort.Strings(zones)
+		return zones, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,138 @@
+package nodeconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestReadNodeConfig(t *testing.T) {
+	config, err := readNodeConfig(json.RawMessage(`{
+		"id": "pool-a",
+		"labels": {"tier": "backend"},
+		"taints": [{"key": "dedicated", "value": "db", "effect": "NoSchedule"}]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if config.Labels["tier"] != "backend" {
+		t.Errorf("expected label tier=backend, got %v", config.Labels)
+	}
+
+	wantTaint := corev1.Taint{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}
+	if len(config.Taints) != 1 || config.Taints[0] != wantTaint {
+		t.Errorf("expected taint %v, got %v", wantTaint, config.Taints)
+	}
+
+	config, err = readNodeConfig(json.RawMessage(`{"id": "pool-b"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !config.IsEmpty() {
+		t.Errorf("expected empty config for pool without labels or taints, got %+v", config)
+	}
+}
+
+func TestDiffNode(t *testing.T) {
+	config := NodeConfig{
+		Labels: map[string]string{
+			"tier": "backend",
+			"zone": "a",
+		},
+		Taints: []corev1.Taint{
+			{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule},
+		},
+	}
+
+	matching := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"tier":                   "backend",
+				"zone":                   "a",
+				"kubernetes.io/hostname": "ignored",
+			},
+		},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{
+				{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule},
+				{Key: "ignored", Effect: corev1.TaintEffectNoExecute},
+			},
+		},
+	}
+
+	if diff := DiffNode(matching, config); len(diff) != 0 {
+		t.Errorf("expected no diff, got %q", diff)
+	}
+
+	mismatched := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"zone": "b"},
+		},
+		Spec: corev1.NodeSpec{
+			// Same key with a different effect doesn't count
+			Taints: []corev1.Taint{
+				{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoExecute},
+			},
+		},
+	}
+
+	want := []string{
+		"- label tier=backend",
+		`~ label zone: expected "a", got "b"`,
+		"- taint dedicated=db:NoSchedule",
+	}
+	if diff := DiffNode(mismatched, config); !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %q, got %q", want, diff)
+	}
+}
+
+func TestDiffNodePool(t *testing.T) {
+	config := NodeConfig{
+		Labels: map[string]string{"tier": "backend"},
+	}
+
+	node := func(name, poolID string, labels map[string]string) corev1.Node {
+		labels[constants.NodePoolIDLabelKey] = poolID
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+		}
+	}
+
+	updated := node("new-0", "pool-a", map[string]string{"tier": "backend"})
+	stale := node("old-0", "pool-a", map[string]string{})
+
+	// An old node that's being replaced is ignored
+	draining := node("old-1", "pool-a", map[string]string{})
+	draining.Spec.Unschedulable = true
+	deleting := node("old-2", "pool-a", map[string]string{})
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+
+	// Nodes of other pools are ignored
+	other := node("other-0", "pool-b", map[string]string{})
+
+	nodes := []corev1.Node{updated, draining, deleting, other}
+	if diff := DiffNodePool(nodes, "pool-a", config); len(diff) != 0 {
+		t.Errorf("expected no diff, got %q", diff)
+	}
+
+	diff := DiffNodePool(append(nodes, stale), "pool-a", config)
+	want := []string{`node "old-0": - label tier=backend`}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %q, got %q", want, diff)
+	}
+
+	diff = DiffNodePool([]corev1.Node{draining, other}, "pool-a", config)
+	if want := []string{"no nodes found"}; !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %q, got %q", want, diff)
+	}
+}
@@ -0,0 +1,365 @@
+package nodeconfig
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cleanup"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/scale"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+var context *testcontext.E2eTest
+
+// labelUpdateKey is the node pool label added by the label update specs
+const labelUpdateKey = "e2e.containership.io/label-update"
+
+// labelUpdate tracks the node pool label added by the label update specs
+var labelUpdate struct {
+	nodePoolID string
+	labelID    string
+	config     NodeConfig
+}
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopCleanupOnSignal stops the registered cleanups from being run on SIGINT
+// or SIGTERM, see cleanup.HandleSignals
+var stopCleanupOnSignal func()
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.DefaultTimeout, "timeout for waiting on labels and taints to be applied")
+}
+
+func TestNodeConfig(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Node Config Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
+
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
+	Expect(err).NotTo(HaveOccurred())
+
+	clientset, err := cloud.New(cloud.Config{
+		Token:            token,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	context = &testcontext.E2eTest{
+		ContainershipClientset: clientset,
+		OrganizationID:         orgID,
+	}
+
+	kubeClientset, err := context.InitKubernetesClientset()
+	Expect(err).NotTo(HaveOccurred())
+
+	context.ClusterID, err = util.GetClusterIDFromKubernetes(kubeClientset)
+	Expect(err).NotTo(HaveOccurred())
+
+	// Delete whatever was created so far if the run is interrupted
+	stopCleanupOnSignal = cleanup.HandleSignals()
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	if stopCleanupOnSignal != nil {
+		stopCleanupOnSignal()
+	}
+
+	Expect(cleanup.Run()).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = Describe("Node pool labels and taints", func() {
+	It("should be applied to every node in the pool", func() {
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		configs := make(map[string]NodeConfig)
+		for _, pool := range pools {
+			config, err := NodeConfigForPool(pool)
+			Expect(err).NotTo(HaveOccurred())
+
+			if config.IsEmpty() {
+				log.Info("node pool declares no labels or taints, skipping", "id", pool.ID)
+				continue
+			}
+
+			configs[string(pool.ID)] = *config
+		}
+
+		if len(configs) == 0 {
+			Skip("no node pools declare labels or taints")
+		}
+
+		for id, config := range configs {
+			log.By(fmt.Sprintf("verifying labels and taints on nodes of node pool %q", id))
+			Expect(waitForNodePoolNodesConfigured(id, config)).Should(Succeed())
+		}
+	})
+})
+
+var _ = Describe("Node zone labels", func() {
+	It("should be set on every worker node", func() {
+		workers, err := listWorkerNodes()
+		Expect(err).NotTo(HaveOccurred())
+		if len(workers) == 0 {
+			Skip("no worker nodes")
+		}
+
+		Expect(util.AssertNodesHaveZone(workers)).To(Succeed())
+	})
+
+	It("should match the zones configured for each multi-zone worker pool", func() {
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		workers, err := listWorkerNodes()
+		Expect(err).NotTo(HaveOccurred())
+
+		checked := 0
+		for _, pool := range scale.WorkerNodePools(pools) {
+			zones, err := NodePoolZones(pool)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Nothing to match against; the zone label itself is checked
+			// above
+			if len(zones) <= 1 {
+				log.Info("node pool is not multi-zone, skipping", "id", pool.ID, "zones", zones)
+				continue
+			}
+
+			log.By(fmt.Sprintf("verifying zones of nodes of node pool %q are in %v", pool.ID, zones))
+			Expect(util.AssertNodesInZones(util.FilterNodesByPool(workers, string(pool.ID)), zones)).
+				To(Succeed(), "node pool %q", pool.ID)
+			checked++
+		}
+
+		if checked == 0 {
+			Skip("no worker pools are configured with multiple zones")
+		}
+	})
+})
+
+// Containership applies node pool label changes to the pool's existing nodes
+// in place on every provider rather than by recreating them. Nodes that are
+// replaced while the label is applied are tolerated anyway (see
+// DiffNodePool).
+var _ = Describe("Updating node pool labels", func() {
+	It("should successfully add a label to a worker node pool", func() {
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		pool := scale.FirstWorkerNodePool(pools)
+		if pool == nil {
+			Skip("no worker pools to update labels on")
+		}
+		labelUpdate.nodePoolID = string(pool.ID)
+
+		// A unique value ensures the label wasn't left over from a previous run
+		value := strconv.FormatInt(time.Now().Unix(), 10)
+		key := labelUpdateKey
+		labelUpdate.config = NodeConfig{
+			Labels: map[string]string{key: value},
+		}
+
+		log.By(fmt.Sprintf("adding label %s=%s to node pool %q", key, value, labelUpdate.nodePoolID))
+		label, err := context.ContainershipClientset.Provision().
+			NodePoolLabels(context.OrganizationID, context.ClusterID, labelUpdate.nodePoolID).
+			Create(&types.CreateNodePoolLabelRequest{
+				Key:   &key,
+				Value: &value,
+			})
+		Expect(err).NotTo(HaveOccurred())
+		labelUpdate.labelID = string(label.ID)
+
+		cleanup.Register("deleting node pool label "+labelUpdate.labelID, deleteNodePoolLabel)
+	})
+
+	// UPDATING isn't waited for because the label may be applied between
+	// polls
+	It("should return to RUNNING state", func() {
+		requireLabelUpdate()
+
+		Expect(scale.WaitForNodePoolRunningWithContext(ctx, context.ContainershipClientset,
+			context.OrganizationID, context.ClusterID, labelUpdate.nodePoolID, pollInterval, timeout)).
+			Should(Succeed())
+	})
+
+	It("should eventually apply the label to every node in the pool", func() {
+		requireLabelUpdate()
+
+		Expect(waitForNodePoolNodesConfigured(labelUpdate.nodePoolID, labelUpdate.config)).
+			Should(Succeed())
+	})
+})
+
+// listWorkerNodes returns every node that isn't part of the control plane
+func listWorkerNodes() ([]corev1.Node, error) {
+	nodeList, err := context.KubernetesClientset.CoreV1().
+		Nodes().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing nodes")
+	}
+
+	var workers []corev1.Node
+	for _, node := range nodeList.Items {
+		if _, isMaster := node.Labels[constants.MasterRoleLabelKey]; !isMaster {
+			workers = append(workers, node)
+		}
+	}
+
+	return workers, nil
+}
+
+// requireLabelUpdate skips the current spec if no label was added, e.g.
+// because the cluster has no worker pools
+func requireLabelUpdate() {
+	if labelUpdate.labelID == "" {
+		Skip("no node pool label was added")
+	}
+}
+
+func deleteNodePoolLabel() error {
+	err := context.ContainershipClientset.Provision().
+		NodePoolLabels(context.OrganizationID, context.ClusterID, labelUpdate.nodePoolID).
+		Delete(labelUpdate.labelID)
+	if err != nil && !util.IsCloudNotFoundError(err) {
+		return errors.Wrapf(err, "deleting node pool label %q", labelUpdate.labelID)
+	}
+
+	return nil
+}
+
+// waitForNodePoolNodesConfigured waits for every node in the given pool to
+// carry the pool's labels and taints, which may be applied shortly after the
+// node registers. On timeout, the per-node diff from the last attempt is
+// returned.
+func waitForNodePoolNodesConfigured(id string, config NodeConfig) error {
+	var lastDiff []string
+
+	err := util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		if err != nil {
+			if util.IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "listing nodes")
+		}
+
+		lastDiff = DiffNodePool(nodeList.Items, id, config)
+		return len(lastDiff) == 0, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out waiting for nodes of node pool %q to match its labels and taints:\n%s",
+			id, strings.Join(lastDiff, "\n"))
+	}
+
+	return err
+}
@@ -0,0 +1,33 @@
+package nodeconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestReadNodePoolZones(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{`{"id": "pool"}`, nil, false},
+		{`{"id": "pool", "provider_config": {"size": "s-2vcpu-4gb"}}`, nil, false},
+		{`{"id": "pool", "provider_config": {"zones": ["us-east-1b", "us-east-1a"]}}`, []string{"us-east-1a", "us-east-1b"}, false},
+		{`{"id": "pool", "provider_config": {"availability_zone": "us-east-1a"}}`, []string{"us-east-1a"}, false},
+		{`{"id": "pool", "provider_config": {"zones": 3}}`, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := readNodePoolZones(json.RawMessage(test.data))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.data, test.wantErr, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected zones %v, got %v", test.data, test.want, got)
+		}
+	}
+}
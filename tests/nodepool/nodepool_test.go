@@ -0,0 +1,460 @@
+package nodepool
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cleanup"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/provision"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+type nodePoolContext struct {
+	*testcontext.E2eTest
+
+	// Node pool ID of the pool created by this suite. Required so that the
+	// delete (and any cleanup) operates on the pool we created regardless of
+	// spec ordering.
+	nodePoolID string
+
+	// The number of nodes requested for the created pool
+	nodePoolCount int
+
+	// Whether the created pool has already been deleted
+	nodePoolDeleted bool
+}
+
+var context *nodePoolContext
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopCleanupOnSignal stops the registered cleanups from being run on SIGINT
+// or SIGTERM, see cleanup.HandleSignals
+var stopCleanupOnSignal func()
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	nodePoolFilename string
+
+	checkControlPlaneGuard bool
+	guardTemplateFilename  string
+	guardClusterFilename   string
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+
+	flag.StringVar(&nodePoolFilename, "node-pool", "", "path to node pool file to use")
+	flag.BoolVar(&checkControlPlaneGuard, "check-control-plane-guard", false, "provision a disposable cluster from -guard-template and -guard-cluster, attempt to delete its only control plane node pool, and expect it to be rejected")
+	flag.StringVar(&guardTemplateFilename, "guard-template", "", "path to template file to provision the disposable cluster for -check-control-plane-guard from")
+	flag.StringVar(&guardClusterFilename, "guard-cluster", "", "path to cluster file to provision the disposable cluster for -check-control-plane-guard from")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.ProvisionTimeout, "timeout for waiting on node pool creation and deletion")
+}
+
+func TestNodePool(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Node Pool Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+	if checkControlPlaneGuard {
+		// The guard check may destroy the cluster it runs against, so it
+		// never runs against the cluster under test
+		Expect(guardTemplateFilename).NotTo(BeEmpty(), "-check-control-plane-guard requires -guard-template")
+		Expect(guardClusterFilename).NotTo(BeEmpty(), "-check-control-plane-guard requires -guard-cluster")
+	}
+
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
+
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
+	Expect(err).NotTo(HaveOccurred())
+
+	clientset, err := cloud.New(cloud.Config{
+		Token:            token,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	e2eTest := &testcontext.E2eTest{
+		ContainershipClientset: clientset,
+		OrganizationID:         orgID,
+	}
+
+	kubeClientset, err := e2eTest.InitKubernetesClientset()
+	Expect(err).NotTo(HaveOccurred())
+
+	e2eTest.ClusterID, err = util.GetClusterIDFromKubernetes(kubeClientset)
+	Expect(err).NotTo(HaveOccurred())
+
+	context = &nodePoolContext{
+		E2eTest: e2eTest,
+	}
+
+	// Delete whatever was created so far if the run is interrupted
+	stopCleanupOnSignal = cleanup.HandleSignals()
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if cleanup fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "nodepool", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if stopCleanupOnSignal != nil {
+		stopCleanupOnSignal()
+	}
+
+	Expect(cleanup.Run()).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
+})
+
+var _ = Describe("Adding and removing a worker node pool", func() {
+	It("should successfully request to create a worker node pool", func() {
+		log.By("building node pool create request from file")
+		req := &types.CreateNodePoolRequest{}
+		Expect(provision.ReadRequestFromFile(nodePoolFilename, provision.TemplateValues{}, req)).
+			To(Succeed())
+		Expect(*req.KubernetesMode).To(Equal("worker"), "node pool file must define a worker pool")
+
+		// The new pool must match the version of the existing cluster
+		if req.KubernetesVersion == nil {
+			version, err := provision.GetClusterKubernetesVersion(context.ContainershipClientset,
+				context.OrganizationID, context.ClusterID)
+			Expect(err).NotTo(HaveOccurred())
+			req.KubernetesVersion = &version
+		}
+
+		log.By("POSTing the node pool create request")
+		pool, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			Create(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool).NotTo(BeNil())
+
+		// Save the pool that we're operating on in the context
+		context.nodePoolID = string(pool.ID)
+		context.nodePoolCount = int(*req.Count)
+
+		// If anything fails after the pool was created, don't leave it behind
+		cleanup.Register("deleting node pool "+context.nodePoolID, cleanupNodePool)
+	})
+
+	It("should eventually report as running", func() {
+		Expect(metrics.Time("nodepool-create", func() error {
+			return waitForNodePoolRunning(context.nodePoolID)
+		})).Should(Succeed())
+	})
+
+	It("should eventually have all of its nodes ready in Kubernetes", func() {
+		Expect(waitForNodePoolNodesReady(context.nodePoolID, context.nodePoolCount)).Should(Succeed())
+	})
+
+	It("should successfully request to delete the node pool", func() {
+		err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			Delete(context.nodePoolID)
+		Expect(err).NotTo(HaveOccurred())
+
+		context.nodePoolDeleted = true
+	})
+
+	It("should eventually be removed from the cloud", func() {
+		Expect(metrics.Time("nodepool-delete", func() error {
+			return waitForNodePoolDeleted(context.nodePoolID)
+		})).Should(Succeed())
+	})
+
+	It("should eventually have all of its nodes removed from Kubernetes", func() {
+		Expect(waitForNodePoolNodesReady(context.nodePoolID, 0)).Should(Succeed())
+	})
+})
+
+var _ = Describe("Deleting the last control plane node pool", func() {
+	It("should be rejected by the API", func() {
+		if !checkControlPlaneGuard {
+			Skip("-check-control-plane-guard not set")
+		}
+
+		clusterID, err := provisionGuardCluster()
+		Expect(err).NotTo(HaveOccurred())
+
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, clusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		var masters []types.NodePool
+		for _, pool := range pools {
+			if *pool.KubernetesMode == "master" {
+				masters = append(masters, pool)
+			}
+		}
+		Expect(masters).NotTo(BeEmpty(), "cluster has no control plane node pool")
+		if len(masters) > 1 {
+			// Deleting one of several control plane pools may legitimately
+			// succeed, so don't risk it
+			Skip(fmt.Sprintf("cluster has %d control plane node pools", len(masters)))
+		}
+
+		id := string(masters[0].ID)
+		log.By(fmt.Sprintf("attempting to delete control plane node pool %q", id))
+		err = context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, clusterID).
+			Delete(id)
+		if err == nil {
+			// There is no undoing this, so make sure nobody mistakes it for a
+			// flaky failure
+			Fail(fmt.Sprintf("deleting the last control plane node pool %q of cluster %q was not rejected; the cluster is likely unusable",
+				id, clusterID))
+		}
+
+		Expect(util.IsCloudRejectedError(err)).To(BeTrue(),
+			"expected deleting control plane node pool %q to be rejected with a 4xx, got: %v", id, err)
+		Expect(err.Error()).NotTo(BeEmpty(), "rejection should explain why")
+
+		pool, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, clusterID).
+			Get(id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*pool.Status.Type).NotTo(Equal("DELETING"), "control plane node pool is being deleted despite the rejection")
+	})
+})
+
+// provisionGuardCluster provisions the disposable cluster for the control
+// plane guard check and returns its ID once its node pools are running. It's
+// registered for deletion as soon as it's created, so that it's torn down
+// even if the run is interrupted while waiting for it.
+func provisionGuardCluster() (string, error) {
+	log.By(fmt.Sprintf("provisioning a disposable cluster from %s and %s", guardTemplateFilename, guardClusterFilename))
+	result, err := provision.CreateTemplateAndCluster(context.ContainershipClientset,
+		context.OrganizationID, guardTemplateFilename, guardClusterFilename, provision.ProvisionOverrides{})
+	if result.ClusterID != "" || result.TemplateID != "" {
+		cleanup.Register("deleting guard cluster "+result.ClusterID, func() error {
+			return provision.DeleteProvisionResult(context.ContainershipClientset, context.OrganizationID,
+				result, constants.ProvisionInitialPollInterval, constants.ProvisionTimeout)
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Never returned for the cluster under test, whatever went wrong above
+	if result.ClusterID == context.ClusterID {
+		return "", errors.Errorf("guard cluster %q is the cluster under test", result.ClusterID)
+	}
+
+	err = provision.WaitForClusterRunningWithContext(ctx, context.ContainershipClientset,
+		context.OrganizationID, result.ClusterID)
+	if err != nil {
+		return "", err
+	}
+
+	err = provision.WaitForAllNodePoolsRunningWithContext(ctx, context.ContainershipClientset,
+		context.OrganizationID, result.ClusterID, pollInterval, constants.ProvisionTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	return result.ClusterID, nil
+}
+
+// cleanupNodePool deletes the created pool unless the suite already did
+func cleanupNodePool() error {
+	if context.nodePoolDeleted {
+		return nil
+	}
+
+	err := context.ContainershipClientset.Provision().
+		NodePools(context.OrganizationID, context.ClusterID).
+		Delete(context.nodePoolID)
+	if err != nil && !util.IsCloudNotFoundError(err) {
+		return errors.Wrapf(err, "deleting node pool %q", context.nodePoolID)
+	}
+
+	context.nodePoolDeleted = true
+	return nil
+}
+
+func waitForNodePoolRunning(id string) error {
+	return util.WaitForStatusOfWithContext(ctx, fmt.Sprintf("node pool %q", id),
+		pollInterval,
+		timeout,
+		func() (string, error) {
+			pool, err := context.ContainershipClientset.Provision().
+				NodePools(context.OrganizationID, context.ClusterID).
+				Get(id)
+			if err != nil {
+				return "", errors.Wrapf(err, "GETing node pool %q", id)
+			}
+
+			return *pool.Status.Type, nil
+		},
+		"RUNNING", "PROVISIONING", "UPDATING")
+}
+
+func waitForNodePoolDeleted(id string) error {
+	return util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+		pool, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			Get(id)
+		if err != nil {
+			if util.IsCloudNotFoundError(err) {
+				return true, nil
+			}
+			if util.IsRetryableCloudError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "GETing node pool %q", id)
+		}
+
+		status := *pool.Status.Type
+		switch status {
+		case "DELETING", "RUNNING", "UPDATING":
+			// The delete request may not have been picked up yet
+			return false, nil
+		default:
+			return false, errors.Errorf("node pool %q entered unexpected state %q while deleting", id, status)
+		}
+	})
+}
+
+// waitForNodePoolNodesReady waits for exactly count nodes belonging to the
+// given pool to exist in Kubernetes and be Ready
+func waitForNodePoolNodesReady(id string, count int) error {
+	return util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{
+				LabelSelector: fmt.Sprintf("%s=%s", constants.NodePoolIDLabelKey, id),
+			})
+		if err != nil {
+			if util.IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "listing nodes")
+		}
+
+		if len(nodeList.Items) != count {
+			return false, nil
+		}
+
+		for _, node := range nodeList.Items {
+			if !util.IsNodeReady(node) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+}
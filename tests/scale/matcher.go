@@ -0,0 +1,71 @@
+package scale
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/onsi/gomega/format"
+	gomegatypes "github.com/onsi/gomega/types"
+)
+
+// HaveNodePoolStatus returns a gomega matcher that succeeds when the actual
+// node pool status (see NodePoolStatus) is desired. While the status is one
+// of allowed, Eventually keeps polling; any other status fails the match
+// with an error naming the unexpected status, and Eventually stops polling
+// immediately rather than waiting out the timeout.
+func HaveNodePoolStatus(desired string, allowed ...string) gomegatypes.GomegaMatcher {
+	return &nodePoolStatusMatcher{
+		desired: desired,
+		allowed: allowed,
+	}
+}
+
+type nodePoolStatusMatcher struct {
+	desired string
+	allowed []string
+}
+
+func (m *nodePoolStatusMatcher) Match(actual interface{}) (bool, error) {
+	status, ok := actual.(string)
+	if !ok {
+		return false, errors.Errorf("HaveNodePoolStatus expects a string status, got %T", actual)
+	}
+
+	if status == m.desired {
+		return true, nil
+	}
+
+	if m.isAllowed(status) {
+		return false, nil
+	}
+
+	return false, errors.Errorf("node pool entered unexpected status %q while waiting for %q", status, m.desired)
+}
+
+func (m *nodePoolStatusMatcher) FailureMessage(actual interface{}) string {
+	return format.Message(actual, "to be node pool status", m.desired)
+}
+
+func (m *nodePoolStatusMatcher) NegatedFailureMessage(actual interface{}) string {
+	return format.Message(actual, "not to be node pool status", m.desired)
+}
+
+// MatchMayChangeInTheFuture tells Eventually to stop polling once the pool
+// has entered an unexpected status, since it's not going to recover
+func (m *nodePoolStatusMatcher) MatchMayChangeInTheFuture(actual interface{}) bool {
+	status, ok := actual.(string)
+	if !ok {
+		return false
+	}
+
+	return status == m.desired || m.isAllowed(status)
+}
+
+func (m *nodePoolStatusMatcher) isAllowed(status string) bool {
+	for _, s := range m.allowed {
+		if status == s {
+			return true
+		}
+	}
+
+	return false
+}
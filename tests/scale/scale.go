@@ -0,0 +1,255 @@
+package scale
+
+import (
+	// Aliased because the scale suite declares a package-level context
+	gocontext "context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// FirstWorkerNodePool returns a pointer to the first worker pool in pools, or
+// nil if there are no worker pools
+func FirstWorkerNodePool(pools []types.NodePool) *types.NodePool {
+	for i := range pools {
+		// Index into the slice rather than taking the address of a range
+		// variable, which is reused across iterations
+		if *pools[i].KubernetesMode == "worker" {
+			return &pools[i]
+		}
+	}
+
+	return nil
+}
+
+// SelectWorkerNodePool returns a pointer to the worker pool in pools with the
+// given ID, or to the first worker pool if id is empty (see
+// FirstWorkerNodePool). An error is returned if a pool was asked for by ID
+// but doesn't exist or isn't a worker pool.
+func SelectWorkerNodePool(pools []types.NodePool, id string) (*types.NodePool, error) {
+	if id == "" {
+		return FirstWorkerNodePool(pools), nil
+	}
+
+	for i := range pools {
+		if string(pools[i].ID) != id {
+			continue
+		}
+
+		if mode := *pools[i].KubernetesMode; mode != "worker" {
+			return nil, errors.Errorf("node pool %q is a %s pool, not a worker pool", id, mode)
+		}
+
+		return &pools[i], nil
+	}
+
+	return nil, errors.Errorf("node pool %q not found", id)
+}
+
+// WorkerNodePools returns the worker pools in pools sorted by ID, so that
+// they're listed in the same order on every call
+func WorkerNodePools(pools []types.NodePool) []types.NodePool {
+	var workers []types.NodePool
+	for _, pool := range pools {
+		if *pool.KubernetesMode == "worker" {
+			workers = append(workers, pool)
+		}
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+
+	return workers
+}
+
+// The node pool waiters poll with jitter (see util.PollWithJitter) since
+// suites that scale several pools at once poll on the same interval
+
+// WaitForNodePoolUpdating waits for the given node pool to report as updating
+func WaitForNodePoolUpdating(cs cloud.Interface, organizationID, clusterID, nodePoolID string, poll, timeout time.Duration) error {
+	return WaitForNodePoolUpdatingWithContext(gocontext.Background(), cs, organizationID, clusterID, nodePoolID, poll, timeout)
+}
+
+// WaitForNodePoolUpdatingWithContext is the same as WaitForNodePoolUpdating
+// but stops waiting if ctx is done
+func WaitForNodePoolUpdatingWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, clusterID, nodePoolID string, poll, timeout time.Duration) error {
+	return util.WaitForStatusWithPoller(fmt.Sprintf("node pool %q", nodePoolID),
+		util.JitterPollerWithContext(ctx, poll, timeout),
+		NodePoolStatus(cs, organizationID, clusterID, nodePoolID),
+		"UPDATING", "RUNNING")
+}
+
+// WaitForNodePoolRunning waits for the given node pool to report as running
+func WaitForNodePoolRunning(cs cloud.Interface, organizationID, clusterID, nodePoolID string, poll, timeout time.Duration) error {
+	return WaitForNodePoolRunningWithContext(gocontext.Background(), cs, organizationID, clusterID, nodePoolID, poll, timeout)
+}
+
+// WaitForNodePoolRunningWithContext is the same as WaitForNodePoolRunning but
+// stops waiting if ctx is done
+func WaitForNodePoolRunningWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, clusterID, nodePoolID string, poll, timeout time.Duration) error {
+	return util.WaitForStatusWithPoller(fmt.Sprintf("node pool %q", nodePoolID),
+		util.JitterPollerWithContext(ctx, poll, timeout),
+		NodePoolStatus(cs, organizationID, clusterID, nodePoolID),
+		"RUNNING", "UPDATING")
+}
+
+// ScaleAllWorkerPools requests every worker pool in the given cluster to be
+// scaled by delta at the same time and returns the IDs of the pools, sorted.
+// Nothing is requested if any pool would be scaled below zero. If any requests
+// fail, the error names each failed pool.
+func ScaleAllWorkerPools(cs cloud.Interface, organizationID, clusterID string, delta int) ([]string, error) {
+	pools, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing node pools")
+	}
+
+	workers := WorkerNodePools(pools)
+	ids := make([]string, 0, len(workers))
+	requests := make(map[string]func() error, len(workers))
+	for _, pool := range workers {
+		id := string(pool.ID)
+		count := *pool.Count + int32(delta)
+		if count < 0 {
+			return nil, errors.Errorf("can't scale node pool %q with count %d by %d", id, *pool.Count, delta)
+		}
+
+		ids = append(ids, id)
+		requests[id] = func() error {
+			req := types.NodePoolScaleRequest{
+				Count: &count,
+			}
+
+			_, err := cs.Provision().
+				NodePools(organizationID, clusterID).
+				Scale(id, &req)
+			return errors.Wrapf(err, "scaling node pool %q to %d", id, count)
+		}
+	}
+
+	return ids, util.RunParallel(requests)
+}
+
+// WaitForPoolConverged waits for the cloud and Kubernetes to agree that the
+// given node pool has targetCount nodes: the cloud must report the pool as
+// RUNNING with the target count, and Kubernetes must have exactly that many
+// nodes in the pool, all Ready. Either side may lag the other, so both are
+// checked on every poll. Polling stops immediately if the pool enters a
+// status other than RUNNING or UPDATING. On timeout, the error reports what
+// each side last showed.
+func WaitForPoolConverged(cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID, nodePoolID string, targetCount int, poll, timeout time.Duration) error {
+	return WaitForPoolConvergedWithContext(gocontext.Background(), cs, kubeClientset, organizationID, clusterID, nodePoolID, targetCount, poll, timeout)
+}
+
+// WaitForPoolConvergedWithContext is the same as WaitForPoolConverged but
+// stops waiting if ctx is done
+func WaitForPoolConvergedWithContext(ctx gocontext.Context, cs cloud.Interface, kubeClientset kubernetes.Interface, organizationID, clusterID, nodePoolID string, targetCount int, poll, timeout time.Duration) error {
+	var (
+		cloudStatus = "unknown"
+		cloudCount  = -1
+		kubeReady   = -1
+		kubeTotal   = -1
+	)
+	start := time.Now()
+
+	err := util.PollWithJitterWithContext(ctx, poll, timeout, func() (bool, error) {
+		pool, err := cs.Provision().
+			NodePools(organizationID, clusterID).
+			Get(nodePoolID)
+		if err != nil {
+			if util.IsRetryableCloudError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "GETing node pool %q", nodePoolID)
+		}
+
+		cloudStatus = *pool.Status.Type
+		cloudCount = int(*pool.Count)
+		if cloudStatus != "RUNNING" && cloudStatus != "UPDATING" {
+			return false, errors.Errorf("node pool %q entered unexpected state %q while converging on %d nodes",
+				nodePoolID, cloudStatus, targetCount)
+		}
+
+		nodeList, err := kubeClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		if err != nil {
+			if util.IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "listing nodes")
+		}
+
+		nodes := util.FilterNodesByPool(nodeList.Items, nodePoolID)
+		kubeTotal = len(nodes)
+		kubeReady = 0
+		for _, node := range nodes {
+			if util.IsNodeReady(node) {
+				kubeReady++
+			}
+		}
+
+		return cloudStatus == "RUNNING" && cloudCount == targetCount &&
+			kubeTotal == targetCount && kubeReady == targetCount, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out after %s waiting for node pool %q to converge on %d nodes; "+
+			"cloud reports %s with count %d, Kubernetes has %d of %d nodes Ready",
+			time.Since(start).Round(time.Second), nodePoolID, targetCount,
+			cloudStatus, cloudCount, kubeReady, kubeTotal)
+	}
+
+	return err
+}
+
+// AssertPoolCount returns an error if the given node pool's count in the cloud
+// isn't want
+func AssertPoolCount(cs cloud.Interface, organizationID, clusterID, nodePoolID string, want int) error {
+	pool, err := cs.Provision().
+		NodePools(organizationID, clusterID).
+		Get(nodePoolID)
+	if err != nil {
+		return errors.Wrapf(err, "GETing node pool %q", nodePoolID)
+	}
+
+	if got := int(*pool.Count); got != want {
+		return errors.Errorf("node pool %q has count %d, expected %d", nodePoolID, got, want)
+	}
+
+	return nil
+}
+
+// NodePoolStatus returns a function that GETs the status of the given node
+// pool. It can be polled with gomega's Eventually, which reports any error
+// returned in its failure message, e.g.
+//
+//	Eventually(NodePoolStatus(cs, org, clusterID, poolID), timeout, poll).
+//		Should(HaveNodePoolStatus("RUNNING", "UPDATING"))
+func NodePoolStatus(cs cloud.Interface, organizationID, clusterID, nodePoolID string) func() (string, error) {
+	return func() (string, error) {
+		pool, err := cs.Provision().
+			NodePools(organizationID, clusterID).
+			Get(nodePoolID)
+		if err != nil {
+			return "", errors.Wrapf(err, "GETing node pool %q", nodePoolID)
+		}
+
+		return *pool.Status.Type, nil
+	}
+}
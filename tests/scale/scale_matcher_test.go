@@ -0,0 +1,67 @@
+package scale
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestHaveNodePoolStatus(t *testing.T) {
+	matcher := HaveNodePoolStatus("RUNNING", "UPDATING")
+	oracle := matcher.(*nodePoolStatusMatcher)
+
+	tests := []struct {
+		status    string
+		matches   bool
+		wantErr   bool
+		mayChange bool
+	}{
+		{"RUNNING", true, false, true},
+		{"UPDATING", false, false, true},
+		{"ERROR", false, true, false},
+	}
+
+	for _, test := range tests {
+		matches, err := matcher.Match(test.status)
+		if matches != test.matches {
+			t.Errorf("%s: expected match %t, got %t", test.status, test.matches, matches)
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.status, test.wantErr, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), test.status) {
+			t.Errorf("%s: expected error to name the status, got %v", test.status, err)
+		}
+		if mayChange := oracle.MatchMayChangeInTheFuture(test.status); mayChange != test.mayChange {
+			t.Errorf("%s: expected may change %t, got %t", test.status, test.mayChange, mayChange)
+		}
+	}
+
+	if _, err := matcher.Match(42); err == nil {
+		t.Error("expected error for non-string status")
+	}
+}
+
+func TestNodePoolStatus(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:       "pool",
+		Statuses: []string{"UPDATING", "RUNNING"},
+	})
+
+	get := NodePoolStatus(cs, "org", "cluster", "pool")
+	for _, want := range []string{"UPDATING", "RUNNING", "RUNNING"} {
+		status, err := get()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if status != want {
+			t.Errorf("expected status %q, got %q", want, status)
+		}
+	}
+
+	if _, err := NodePoolStatus(cs, "org", "cluster", "missing")(); err == nil {
+		t.Error("expected error for missing node pool")
+	}
+}
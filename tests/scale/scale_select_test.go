@@ -0,0 +1,99 @@
+package scale
+
+import (
+	"testing"
+
+	"github.com/containership/csctl/cloud/provision/types"
+)
+
+func nodePoolWithMode(id, mode string) types.NodePool {
+	return types.NodePool{
+		ID:             types.UUID(id),
+		KubernetesMode: &mode,
+	}
+}
+
+func TestFirstWorkerNodePool(t *testing.T) {
+	pools := []types.NodePool{
+		nodePoolWithMode("master-0", "master"),
+		nodePoolWithMode("worker-0", "worker"),
+		nodePoolWithMode("worker-1", "worker"),
+		nodePoolWithMode("master-1", "master"),
+	}
+
+	pool := FirstWorkerNodePool(pools)
+	if pool == nil {
+		t.Fatal("expected a worker pool")
+	}
+
+	if pool.ID != "worker-0" {
+		t.Errorf("expected first worker pool %q, got %q", "worker-0", pool.ID)
+	}
+
+	// The returned pointer must refer to the slice element, not a copy that
+	// is overwritten by later iterations
+	if pool != &pools[1] {
+		t.Error("expected pointer into the given slice")
+	}
+
+	if FirstWorkerNodePool(pools[:1]) != nil {
+		t.Error("expected nil when there are no worker pools")
+	}
+}
+
+func TestSelectWorkerNodePool(t *testing.T) {
+	pools := []types.NodePool{
+		nodePoolWithMode("master-0", "master"),
+		nodePoolWithMode("worker-0", "worker"),
+		nodePoolWithMode("worker-1", "worker"),
+	}
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{"", "worker-0", false},
+		{"worker-1", "worker-1", false},
+		{"master-0", "", true},
+		{"missing", "", true},
+	}
+
+	for _, test := range tests {
+		pool, err := SelectWorkerNodePool(pools, test.id)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: expected error %t, got %v", test.id, test.wantErr, err)
+			continue
+		}
+
+		if err == nil && string(pool.ID) != test.want {
+			t.Errorf("%q: expected pool %q, got %q", test.id, test.want, pool.ID)
+		}
+	}
+
+	pool, err := SelectWorkerNodePool(pools[:1], "")
+	if err != nil || pool != nil {
+		t.Errorf("expected no worker pool without error, got %v, %v", pool, err)
+	}
+}
+
+func TestWorkerNodePools(t *testing.T) {
+	pools := []types.NodePool{
+		nodePoolWithMode("worker-1", "worker"),
+		nodePoolWithMode("master-0", "master"),
+		nodePoolWithMode("worker-0", "worker"),
+	}
+
+	workers := WorkerNodePools(pools)
+	if len(workers) != 2 {
+		t.Fatalf("expected 2 worker pools, got %d", len(workers))
+	}
+
+	if workers[0].ID != "worker-0" || workers[1].ID != "worker-1" {
+		t.Errorf("expected worker pools sorted by ID, got %q and %q", workers[0].ID, workers[1].ID)
+	}
+
+	if len(WorkerNodePools(pools[1:2])) != 0 {
+		t.Error("expected no worker pools")
+	}
+}
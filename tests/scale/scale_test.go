@@ -1,126 +1,341 @@
 package scale
 
 import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
-	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/containership/csctl/cloud"
 	"github.com/containership/csctl/cloud/provision/types"
 
+	"github.com/mattkelly/containership-test-v2-experiment/cleanup"
 	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/provision"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
 	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
 	"github.com/mattkelly/containership-test-v2-experiment/util"
 )
 
+// maxWorkerNodePools is the number of worker pools that can be scaled with
+// -all-worker-pools. Table entries must be declared before the pools are
+// known, so one is declared per index up to this limit and entries past the
+// number of worker pools are skipped.
+const maxWorkerNodePools = 8
+
 type scaleContext struct {
 	*testcontext.E2eTest
 
-	// Node pool ID of the pool we're currently operating on.
+	// Node pool ID of the pool the single pool specs operate on, set from
+	// -node-pool-id or else the first worker pool.
 	// Required to operate on the same pool across multiple It blocks (in order
 	// to ideally end up back at the same state - i.e. scale a pool up and then
 	// scale it back down)
 	currentNodePoolID string
+
+	// IDs of every worker pool sorted by ID, only set with -all-worker-pools
+	workerNodePoolIDs []string
+
+	nodePoolsMu sync.Mutex
+	nodePools   map[string]*nodePoolState
+}
+
+// nodePoolState tracks a single node pool being scaled
+type nodePoolState struct {
+	// The count most recently requested for the pool
+	targetCount int32
+
+	// The pool's Kubernetes nodes just before scaling up, used to tell
+	// which node was added
+	nodesBeforeScaleUp map[string]struct{}
+
+	// The pool's Kubernetes nodes just before scaling down, used to
+	// tell which node was removed
+	nodesBeforeScaleDown map[string]struct{}
 }
 
 var context *scaleContext
 
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopCleanupOnSignal stops the registered cleanups from being run on SIGINT
+// or SIGTERM, see cleanup.HandleSignals
+var stopCleanupOnSignal func()
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	clusterID        string
+	templateFilename string
+	clusterFilename  string
+
+	nodePoolID         string
+	allWorkerNodePools bool
+	concurrentScale    bool
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+
+	// The cluster to run against is the one given here, else the one
+	// KUBECONFIG points to, else one provisioned from -template and -cluster
+	flag.StringVar(&clusterID, "cluster-id", "", "ID of an existing cluster to run against instead of the one KUBECONFIG points to")
+	flag.StringVar(&templateFilename, "template", "", "path to template file to provision a cluster from if there is no existing one (requires -cluster)")
+	flag.StringVar(&clusterFilename, "cluster", "", "path to cluster file to provision a cluster from if there is no existing one (requires -template)")
+
+	flag.StringVar(&nodePoolID, "node-pool-id", "", "ID of the worker pool to scale instead of the first one")
+	flag.BoolVar(&allWorkerNodePools, "all-worker-pools", false, fmt.Sprintf("scale every worker pool (up to %d) up and back down, one spec per pool, instead of only the first", maxWorkerNodePools))
+	flag.BoolVar(&concurrentScale, "concurrent-scale", false, "with -all-worker-pools, scale all worker pools at the same time (in one spec) instead of one after another")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.DefaultTimeout, "timeout for waiting on node pool state transitions")
+}
+
 func TestScale(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
 	// Hook up gomega to ginkgo
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "Scale Suite")
+	reporting.RunSpecs(t, "Scale Suite", junitOutputDir)
 }
 
 var _ = SynchronizedBeforeSuite(func() []byte {
 	// Run only on first node
-	token := os.Getenv("CONTAINERSHIP_TOKEN")
-	Expect(token).NotTo(BeEmpty(), "please specify a Containership Cloud token via CONTAINERSHIP_TOKEN env var")
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
 
-	kubeconfigFilename := os.Getenv("KUBECONFIG")
-	Expect(kubeconfigFilename).NotTo(BeEmpty(), "please set KUBECONFIG environment variable")
+	// Start the watchdog before ensuring the cluster, since provisioning one
+	// can take up most of the deadline
+	startDeadlineWatchdog()
 
-	clientset, err := cloud.New(cloud.Config{
-		Token:            token,
-		APIBaseURL:       constants.StageAPIBaseURL,
-		AuthBaseURL:      constants.StageAuthBaseURL,
-		ProvisionBaseURL: constants.StageProvisionBaseURL,
-	})
+	token, err := testcontext.ReadToken(tokenEnv)
 	Expect(err).NotTo(HaveOccurred())
 
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigFilename)
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
 	Expect(err).NotTo(HaveOccurred())
 
-	kubeClientset, err := kubernetes.NewForConfig(cfg)
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
 	Expect(err).NotTo(HaveOccurred())
 
-	clusterID, err := util.GetClusterIDFromKubernetes(kubeClientset)
+	clientset, err := cloud.New(cloud.Config{
+		Token:            token,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
+	})
 	Expect(err).NotTo(HaveOccurred())
 
+	e2eTest := &testcontext.E2eTest{
+		ContainershipClientset: clientset,
+		AuthToken:              token,
+		OrganizationID:         orgID,
+	}
+
+	Expect(testutil.WrapIfDeadlineReached(ensureCluster(e2eTest))).To(Succeed())
+
 	context = &scaleContext{
-		E2eTest: &testcontext.E2eTest{
-			ContainershipClientset: clientset,
-			KubernetesClientset:    kubeClientset,
-			OrganizationID:         constants.TestOrganizationID,
-			ClusterID:              clusterID,
-		},
+		E2eTest:   e2eTest,
+		nodePools: make(map[string]*nodePoolState),
+	}
+
+	if allWorkerNodePools {
+		nodePools, err := clientset.Provision().
+			NodePools(e2eTest.OrganizationID, e2eTest.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		workers := WorkerNodePools(nodePools)
+		Expect(len(workers)).To(BeNumerically("<=", maxWorkerNodePools),
+			"too many worker pools to scale with -all-worker-pools")
+
+		for _, pool := range workers {
+			context.workerNodePoolIDs = append(context.workerNodePoolIDs, string(pool.ID))
+		}
+	} else {
+		Expect(concurrentScale).To(BeFalse(), "-concurrent-scale requires -all-worker-pools")
+	}
+
+	if nodePoolID != "" {
+		Expect(allWorkerNodePools).To(BeFalse(), "-node-pool-id can't be used with -all-worker-pools")
+
+		nodePools, err := clientset.Provision().
+			NodePools(e2eTest.OrganizationID, e2eTest.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = SelectWorkerNodePool(nodePools, nodePoolID)
+		Expect(err).NotTo(HaveOccurred(), "invalid -node-pool-id")
+
+		context.currentNodePoolID = nodePoolID
 	}
 
 	return nil
 }, func(_ []byte) {
 	// Run on all nodes after first one
+	// The first node already started its watchdog
+	if ctx == nil {
+		startDeadlineWatchdog()
+	}
+})
+
+// startDeadlineWatchdog sets up the suite context and aborts it before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+func startDeadlineWatchdog() {
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if cleanup fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "scale", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if stopCleanupOnSignal != nil {
+		stopCleanupOnSignal()
+	}
+
+	Expect(cleanup.Run()).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
 })
 
 var _ = Describe("Scaling a worker node pool", func() {
+	BeforeEach(func() {
+		if allWorkerNodePools {
+			testutil.Skipf(testutil.FeatureDisabled, "scaling all worker pools instead")
+		}
+	})
+
 	It("should successfully request to scale up by one", func() {
-		By("listing node pools")
+		log.By("listing node pools")
 		nodePools, err := context.ContainershipClientset.Provision().
 			NodePools(context.OrganizationID, context.ClusterID).
 			List()
 		Expect(err).NotTo(HaveOccurred())
 
-		// Any worker pool will do
-		var pool *types.NodePool
-		for _, p := range nodePools {
-			if *p.KubernetesMode == "worker" {
-				pool = &p
-				break
-			}
-		}
+		// Any worker pool will do unless one was given
+		pool, err := SelectWorkerNodePool(nodePools, context.currentNodePoolID)
+		Expect(err).NotTo(HaveOccurred())
 		if pool == nil {
 			// There are no worker pools - that's fine
-			Skip("no worker pools to test scale up on")
+			testutil.Skipf(testutil.NoWorkerPools, "no worker pools to test scale up on")
 		}
 
 		// Save the pool that we're operating on in the context
 		context.currentNodePoolID = string(pool.ID)
 
+		state := context.nodePool(context.currentNodePoolID)
+		state.nodesBeforeScaleUp, err = snapshotPoolNodes(context.currentNodePoolID)
+		Expect(err).NotTo(HaveOccurred())
+
 		targetCount := *pool.Count + 1
-		req := types.NodePoolScaleRequest{
-			Count: &targetCount,
-		}
+		state.targetCount = targetCount
 
-		_, err = context.ContainershipClientset.Provision().
-			NodePools(context.OrganizationID, context.ClusterID).
-			Scale(string(pool.ID), &req)
-		Expect(err).NotTo(HaveOccurred())
+		Expect(scaleNodePool(string(pool.ID), targetCount)).Should(Succeed())
 	})
 
-	It("should go into UPDATING state", func() {
-		Expect(waitForNodePoolUpdating(context.currentNodePoolID)).Should(Succeed())
-		// TODO check count in cloud
+	It("should converge on the scaled up count in the cloud and Kubernetes", func() {
+		Expect(metrics.Time("nodepool-scale-up", func() error {
+			return waitForPoolConverged(context.currentNodePoolID,
+				context.nodePool(context.currentNodePoolID).targetCount)
+		})).Should(Succeed())
 	})
 
-	It("should return to RUNNING state", func() {
-		Expect(waitForNodePoolRunning(context.currentNodePoolID)).Should(Succeed())
-		// TODO check for new node in Kubernetes and cloud
+	It("should report the scaled up count in the cloud", func() {
+		expectPoolRunningWithTargetCount(context.currentNodePoolID)
+	})
+
+	It("should have added exactly one node to Kubernetes", func() {
+		after, err := snapshotPoolNodes(context.currentNodePoolID)
+		Expect(err).NotTo(HaveOccurred())
+
+		added, removed := util.DiffNodeSets(context.nodePool(context.currentNodePoolID).nodesBeforeScaleUp, after)
+		Expect(added).To(HaveLen(1), "expected one node to be added, got %v", added)
+		Expect(removed).To(BeEmpty(), "expected no nodes to be removed while scaling up")
+
+		log.Info("node added to Kubernetes", "node", added[0], "nodePool", context.currentNodePoolID)
 	})
 
 	It("should successfully request to scale down by one", func() {
@@ -129,75 +344,333 @@ var _ = Describe("Scaling a worker node pool", func() {
 			Get(context.currentNodePoolID)
 		Expect(err).NotTo(HaveOccurred())
 
+		state := context.nodePool(context.currentNodePoolID)
+		state.nodesBeforeScaleDown, err = snapshotPoolNodes(context.currentNodePoolID)
+		Expect(err).NotTo(HaveOccurred())
+
 		targetCount := *pool.Count - 1
-		req := types.NodePoolScaleRequest{
-			Count: &targetCount,
+		state.targetCount = targetCount
+
+		Expect(scaleNodePool(string(pool.ID), targetCount)).Should(Succeed())
+	})
+
+	It("should have one of its nodes deleted from Kubernetes", func() {
+		Expect(waitForNodePoolNodeRemoved(context.currentNodePoolID)).Should(Succeed())
+	})
+
+	It("should converge on the scaled down count in the cloud and Kubernetes", func() {
+		Expect(metrics.Time("nodepool-scale-down", func() error {
+			return waitForPoolConverged(context.currentNodePoolID,
+				context.nodePool(context.currentNodePoolID).targetCount)
+		})).Should(Succeed())
+	})
+
+	It("should report the scaled down count in the cloud", func() {
+		expectPoolRunningWithTargetCount(context.currentNodePoolID)
+	})
+})
+
+var _ = Describe("Scaling every worker node pool", func() {
+	BeforeEach(func() {
+		if !allWorkerNodePools {
+			testutil.Skipf(testutil.FeatureDisabled, "only scaling the first worker pool (use -all-worker-pools to scale every pool)")
+		}
+	})
+
+	entries := make([]table.TableEntry, maxWorkerNodePools)
+	for i := range entries {
+		entries[i] = table.Entry(fmt.Sprintf("worker pool %d", i), i)
+	}
+
+	table.DescribeTable("should scale up by one and back down",
+		func(index int) {
+			if concurrentScale {
+				testutil.Skipf(testutil.FeatureDisabled, "scaling every worker pool at once instead")
+			}
+			if index >= len(context.workerNodePoolIDs) {
+				testutil.Skipf(testutil.NoWorkerPools, "cluster only has %d worker pools", len(context.workerNodePoolIDs))
+			}
+
+			id := context.workerNodePoolIDs[index]
+			log.By("scaling node pool " + id)
+
+			Expect(scaleNodePoolUpAndDown(id)).Should(Succeed())
+		},
+		entries...)
+
+	It("should scale every worker pool up by one and back down at once", func() {
+		if !concurrentScale {
+			testutil.Skipf(testutil.FeatureDisabled, "scaling worker pools one after another (use -concurrent-scale to scale them at once)")
+		}
+		if len(context.workerNodePoolIDs) == 0 {
+			testutil.Skipf(testutil.NoWorkerPools, "no worker pools to scale")
 		}
 
-		_, err = context.ContainershipClientset.Provision().
+		nodePools, err := context.ContainershipClientset.Provision().
 			NodePools(context.OrganizationID, context.ClusterID).
-			Scale(string(pool.ID), &req)
+			List()
 		Expect(err).NotTo(HaveOccurred())
 
-		Expect(waitForNodePoolUpdating(context.currentNodePoolID)).Should(Succeed())
+		originalCounts := make(map[string]int32)
+		for _, pool := range WorkerNodePools(nodePools) {
+			originalCounts[string(pool.ID)] = *pool.Count
+		}
+
+		log.By("scaling every worker pool up by one")
+		ids, err := ScaleAllWorkerPools(context.ContainershipClientset,
+			context.OrganizationID, context.ClusterID, 1)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(metrics.Time("nodepool-scale-up/all", func() error {
+			return forEachPool(ids, func(id string) error {
+				if err := waitForPoolConverged(id, originalCounts[id]+1); err != nil {
+					return err
+				}
+
+				return assertPoolCount(id, originalCounts[id]+1)
+			})
+		})).Should(Succeed())
+
+		for _, id := range ids {
+			nodes, err := snapshotPoolNodes(id)
+			Expect(err).NotTo(HaveOccurred())
+			context.nodePool(id).nodesBeforeScaleDown = nodes
+		}
+
+		log.By("scaling every worker pool back down by one")
+		_, err = ScaleAllWorkerPools(context.ContainershipClientset,
+			context.OrganizationID, context.ClusterID, -1)
+		Expect(err).NotTo(HaveOccurred())
 
-		Expect(waitForNodePoolRunning(context.currentNodePoolID)).Should(Succeed())
+		Expect(metrics.Time("nodepool-scale-down/all", func() error {
+			return forEachPool(ids, func(id string) error {
+				if err := waitForNodePoolNodeRemoved(id); err != nil {
+					return err
+				}
+
+				if err := waitForPoolConverged(id, originalCounts[id]); err != nil {
+					return err
+				}
+
+				return assertPoolCount(id, originalCounts[id])
+			})
+		})).Should(Succeed())
 	})
+})
 
-	It("should go into UPDATING state", func() {
-		Expect(waitForNodePoolUpdating(context.currentNodePoolID)).Should(Succeed())
-		// TODO this transition can be missed because a delete happens so quickly
-		// TODO check count in cloud
+// forEachPool runs f for each of the given node pools concurrently. If any
+// fail, the error names each failed pool.
+func forEachPool(ids []string, f func(id string) error) error {
+	checks := make(map[string]func() error, len(ids))
+	for _, id := range ids {
+		id := id
+		checks[id] = func() error {
+			return f(id)
+		}
+	}
+
+	return util.RunParallel(checks)
+}
+
+// scaleNodePoolUpAndDown scales the given pool up by one, waits for the
+// cloud and Kubernetes to agree on the new count, then scales it back down to
+// its original count. Only nodes in the pool are counted so that it's safe to
+// run concurrently for different pools.
+func scaleNodePoolUpAndDown(id string) error {
+	pool, err := context.ContainershipClientset.Provision().
+		NodePools(context.OrganizationID, context.ClusterID).
+		Get(id)
+	if err != nil {
+		return errors.Wrapf(err, "GETing node pool %q", id)
+	}
+	originalCount := *pool.Count
+
+	if err := scaleNodePool(id, originalCount+1); err != nil {
+		return err
+	}
+
+	err = metrics.Time("nodepool-scale-up/"+id, func() error {
+		return waitForPoolConverged(id, originalCount+1)
 	})
+	if err != nil {
+		return err
+	}
+	if err := assertPoolCount(id, originalCount+1); err != nil {
+		return err
+	}
+
+	state := context.nodePool(id)
+	state.nodesBeforeScaleDown, err = snapshotPoolNodes(id)
+	if err != nil {
+		return err
+	}
+
+	if err := scaleNodePool(id, originalCount); err != nil {
+		return err
+	}
 
-	It("should return to RUNNING state", func() {
-		Expect(waitForNodePoolRunning(context.currentNodePoolID)).Should(Succeed())
-		// TODO check for node deleted in Kubernetes and cloud
+	if err := waitForNodePoolNodeRemoved(id); err != nil {
+		return err
+	}
+
+	err = metrics.Time("nodepool-scale-down/"+id, func() error {
+		return waitForPoolConverged(id, originalCount)
 	})
-})
+	if err != nil {
+		return err
+	}
 
-func waitForNodePoolUpdating(id string) error {
-	return wait.PollImmediate(constants.DefaultPollInterval,
-		constants.DefaultTimeout,
-		func() (bool, error) {
-			pool, err := context.ContainershipClientset.Provision().
-				NodePools(context.OrganizationID, context.ClusterID).
-				Get(id)
-			if err != nil {
-				return false, errors.Wrapf(err, "GETing node pool %q", id)
-			}
+	return assertPoolCount(id, originalCount)
+}
 
-			status := *pool.Status.Type
-			switch status {
-			case "RUNNING":
-				return false, nil
-			case "UPDATING":
-				return true, nil
-			default:
-				return false, errors.Errorf("node pool %q entered unexpected state %q", pool.ID, status)
-			}
-		})
+// nodePool returns the state of the given node pool, creating it if needed
+func (c *scaleContext) nodePool(id string) *nodePoolState {
+	c.nodePoolsMu.Lock()
+	defer c.nodePoolsMu.Unlock()
+
+	state, ok := c.nodePools[id]
+	if !ok {
+		state = &nodePoolState{}
+		c.nodePools[id] = state
+	}
+
+	return state
+}
+
+func scaleNodePool(id string, count int32) error {
+	req := types.NodePoolScaleRequest{
+		Count: &count,
+	}
+
+	_, err := context.ContainershipClientset.Provision().
+		NodePools(context.OrganizationID, context.ClusterID).
+		Scale(id, &req)
+	if err != nil {
+		return errors.Wrapf(err, "scaling node pool %q to %d", id, count)
+	}
+
+	return nil
+}
+
+func waitForPoolConverged(id string, target int32) error {
+	return WaitForPoolConvergedWithContext(ctx, context.ContainershipClientset, context.KubernetesClientset,
+		context.OrganizationID, context.ClusterID, id, int(target), pollInterval, timeout)
+}
+
+// expectPoolRunningWithTargetCount expects the given pool to be running in
+// the cloud with the count it was last scaled to
+func expectPoolRunningWithTargetCount(id string) {
+	Eventually(NodePoolStatus(context.ContainershipClientset, context.OrganizationID, context.ClusterID, id),
+		timeout, pollInterval).Should(HaveNodePoolStatus("RUNNING", "UPDATING"))
+
+	Expect(assertPoolCount(id, context.nodePool(id).targetCount)).To(Succeed())
+}
+
+func assertPoolCount(id string, want int32) error {
+	return AssertPoolCount(context.ContainershipClientset, context.OrganizationID, context.ClusterID, id, int(want))
+}
+
+// snapshotPoolNodes returns the set of Kubernetes nodes currently in the given
+// pool, to compare against once the pool has been scaled
+func snapshotPoolNodes(id string) (map[string]struct{}, error) {
+	nodeList, err := context.KubernetesClientset.CoreV1().
+		Nodes().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing nodes")
+	}
+
+	return util.NodeNameSet(util.FilterNodesByPool(nodeList.Items, id)), nil
+}
+
+// waitForNodePoolNodeRemoved waits for one of the nodes snapshotted before
+// scaling the pool down to be deleted from Kubernetes
+func waitForNodePoolNodeRemoved(id string) error {
+	removed, err := util.WaitForNodePoolNodeRemovedWithContext(ctx, context.KubernetesClientset,
+		id, context.nodePool(id).nodesBeforeScaleDown, pollInterval, timeout)
+	if err != nil {
+		return err
+	}
+
+	log.Info("node removed from Kubernetes", "node", removed, "nodePool", id)
+	return nil
 }
 
-func waitForNodePoolRunning(id string) error {
-	return wait.PollImmediate(constants.DefaultPollInterval,
-		constants.DefaultTimeout,
-		func() (bool, error) {
-			pool, err := context.ContainershipClientset.Provision().
-				NodePools(context.OrganizationID, context.ClusterID).
-				Get(id)
+// ensureCluster sets the cluster to run against, see testcontext.EnsureCluster.
+// A cluster provisioned here is registered to be torn down after the suite.
+func ensureCluster(e2eTest *testcontext.E2eTest) error {
+	if (templateFilename == "") != (clusterFilename == "") {
+		return errors.New("-template and -cluster must be specified together")
+	}
+
+	opts := testcontext.EnsureOptions{
+		ClusterID: clusterID,
+	}
+
+	// Only discover the cluster from KUBECONFIG if one wasn't given, since
+	// KUBECONFIG may well point at another cluster
+	if clusterID == "" && os.Getenv("KUBECONFIG") != "" {
+		kubeClientset, err := e2eTest.InitKubernetesClientset()
+		if err != nil {
+			return err
+		}
+
+		opts.KubernetesClientset = kubeClientset
+
+		// The ID may not be populated yet on a freshly provisioned cluster
+		discovered, err := util.WaitForClusterIDFromKubernetesWithContext(ctx, kubeClientset,
+			pollInterval, constants.ClusterIDDiscoveryTimeout)
+		switch {
+		case err == nil:
+			opts.ClusterID = discovered
+		case err != util.ErrClusterIDNotFound:
+			return errors.Wrap(err, "discovering cluster from Kubernetes")
+		}
+	}
+
+	if templateFilename != "" {
+		opts.Provision = func() (string, error) {
+			log.By("provisioning a cluster to run against")
+			result, err := provision.ProvisionClusterWithContext(ctx, e2eTest.ContainershipClientset,
+				e2eTest.OrganizationID, templateFilename, clusterFilename, provision.ProvisionOverrides{})
+			if result.ClusterID != "" || result.TemplateID != "" {
+				stopCleanupOnSignal = cleanup.HandleSignals()
+				cleanup.Register("deleting provisioned cluster "+result.ClusterID, func() error {
+					return provision.DeleteProvisionResult(e2eTest.ContainershipClientset, e2eTest.OrganizationID,
+						result, constants.ProvisionInitialPollInterval, constants.ProvisionTimeout)
+				})
+			}
 			if err != nil {
-				return false, errors.Wrapf(err, "GETing node pool %q", id)
+				return result.ClusterID, err
 			}
 
-			status := *pool.Status.Type
-			switch status {
-			case "UPDATING":
-				return false, nil
-			case "RUNNING":
-				return true, nil
-			default:
-				return false, errors.Errorf("node pool %q entered unexpected state %q", pool.ID, status)
-			}
-		})
+			err = provision.WaitForAllNodePoolsRunningWithContext(ctx, e2eTest.ContainershipClientset,
+				e2eTest.OrganizationID, result.ClusterID, pollInterval, constants.ProvisionTimeout)
+			return result.ClusterID, err
+		}
+	}
+
+	id, created, err := testcontext.EnsureCluster(e2eTest.ContainershipClientset, e2eTest.OrganizationID, opts)
+	if err != nil {
+		return err
+	}
+
+	e2eTest.ClusterID = id
+	if created {
+		log.Info("provisioned cluster", "id", id)
+	}
+
+	if opts.KubernetesClientset != nil && !created {
+		// Discovered from KUBECONFIG, so the clientset is already set
+		return nil
+	}
+
+	proxyBaseURL, err := constants.ProxyBaseURLForEnvironment(constants.Environment(environment))
+	if err != nil {
+		return err
+	}
+
+	e2eTest.KubernetesClientset, err = provision.NewKubernetesClientset(proxyBaseURL,
+		e2eTest.OrganizationID, id, e2eTest.AuthToken, nil)
+	return err
 }
@@ -0,0 +1,178 @@
+package scale
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestWaitForNodePoolTransitions(t *testing.T) {
+	poll := time.Millisecond
+	timeout := time.Second
+
+	cs := cloudfake.New()
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:       "scales",
+		Statuses: []string{"RUNNING", "RUNNING", "UPDATING", "UPDATING", "RUNNING"},
+	})
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:       "errors",
+		Statuses: []string{"UPDATING", "ERROR"},
+	})
+
+	if err := WaitForNodePoolUpdating(cs, "org", "cluster", "scales", poll, timeout); err != nil {
+		t.Fatalf("expected node pool to start updating, got error: %v", err)
+	}
+
+	if err := WaitForNodePoolRunning(cs, "org", "cluster", "scales", poll, timeout); err != nil {
+		t.Fatalf("expected node pool to return to running, got error: %v", err)
+	}
+
+	if err := WaitForNodePoolRunning(cs, "org", "cluster", "errors", poll, timeout); err == nil {
+		t.Error("expected error for node pool entering unexpected state")
+	}
+}
+
+func TestAssertPoolCount(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:       "pool",
+		Count:    3,
+		Statuses: []string{"RUNNING"},
+	})
+
+	if err := AssertPoolCount(cs, "org", "cluster", "pool", 3); err != nil {
+		t.Errorf("expected matching count, got error: %v", err)
+	}
+
+	if err := AssertPoolCount(cs, "org", "cluster", "pool", 4); err == nil {
+		t.Error("expected error for mismatched count")
+	}
+
+	if err := AssertPoolCount(cs, "org", "cluster", "missing", 3); err == nil {
+		t.Error("expected error for missing node pool")
+	}
+}
+
+func TestScaleAllWorkerPools(t *testing.T) {
+	cs := cloudfake.New()
+	for _, id := range []string{"worker-1", "worker-0"} {
+		cs.AddNodePool("cluster", cloudfake.NodePool{
+			ID:             id,
+			KubernetesMode: "worker",
+			Count:          2,
+			Statuses:       []string{"RUNNING"},
+		})
+	}
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:             "master",
+		KubernetesMode: "master",
+		Count:          1,
+		Statuses:       []string{"RUNNING"},
+	})
+
+	ids, err := ScaleAllWorkerPools(cs, "org", "cluster", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "worker-0" || ids[1] != "worker-1" {
+		t.Errorf("expected sorted worker pool IDs, got %v", ids)
+	}
+
+	for id, want := range map[string]int{"worker-0": 3, "worker-1": 3, "master": 1} {
+		if err := AssertPoolCount(cs, "org", "cluster", id, want); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Nothing is requested if any pool would go below zero
+	if _, err := ScaleAllWorkerPools(cs, "org", "cluster", -4); err == nil {
+		t.Error("expected error for scaling below zero")
+	}
+	if err := AssertPoolCount(cs, "org", "cluster", "worker-0", 3); err != nil {
+		t.Error(err)
+	}
+}
+
+func poolNode(poolID, name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				constants.NodePoolIDLabelKey: poolID,
+			},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: status},
+			},
+		},
+	}
+}
+
+func TestWaitForPoolConverged(t *testing.T) {
+	poll := time.Millisecond
+	timeout := 50 * time.Millisecond
+
+	cs := cloudfake.New()
+	// The cloud lags Kubernetes, reporting UPDATING for a few polls
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:       "pool",
+		Count:    2,
+		Statuses: []string{"UPDATING", "UPDATING", "RUNNING"},
+	})
+	cs.AddNodePool("cluster", cloudfake.NodePool{
+		ID:       "errors",
+		Count:    2,
+		Statuses: []string{"ERROR"},
+	})
+
+	kube := fake.NewSimpleClientset(
+		poolNode("pool", "pool-0", true),
+		poolNode("pool", "pool-1", true),
+		poolNode("other", "other-0", true),
+	)
+
+	if err := WaitForPoolConverged(cs, kube, "org", "cluster", "pool", 2, poll, timeout); err != nil {
+		t.Errorf("expected pool to converge, got error: %v", err)
+	}
+
+	// Kubernetes lags the cloud, with the new node not Ready
+	if _, err := kube.CoreV1().Nodes().Create(poolNode("pool", "pool-2", false)); err != nil {
+		t.Fatal(err)
+	}
+	count := int32(3)
+	_, err := cs.Provision().
+		NodePools("org", "cluster").
+		Scale("pool", &types.NodePoolScaleRequest{Count: &count})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WaitForPoolConverged(cs, kube, "org", "cluster", "pool", 3, poll, timeout)
+	if err == nil {
+		t.Fatal("expected timeout while a node is not Ready")
+	}
+	if !strings.Contains(err.Error(), "cloud reports RUNNING with count 3, Kubernetes has 2 of 3 nodes Ready") {
+		t.Errorf("expected timeout to report both sides, got %v", err)
+	}
+
+	err = WaitForPoolConverged(cs, kube, "org", "cluster", "errors", 2, poll, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "unexpected state") {
+		t.Errorf("expected error for node pool entering unexpected state, got %v", err)
+	}
+}
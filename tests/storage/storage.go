@@ -0,0 +1,205 @@
+package storage
+
+import (
+	// Aliased because the storage suite declares a package-level context
+	gocontext "context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+const (
+	// VolumeCheckImage is the image the pods writing to and reading from the
+	// volume run
+	VolumeCheckImage = "busybox:1.31"
+
+	// volumeMountPath is where the volume is mounted in the check pods
+	volumeMountPath = "/data"
+
+	// volumeCheckFile is the file written to and read back from the volume
+	volumeCheckFile = volumeMountPath + "/cs-e2e-check"
+
+	// volumeCheckContentEnv is the environment variable holding the content
+	// to write to the volume
+	volumeCheckContentEnv = "CONTENT"
+)
+
+// Annotations marking a StorageClass as the default, the beta one being what
+// older clusters use
+var defaultStorageClassAnnotations = []string{
+	"storageclass.kubernetes.io/is-default-class",
+	"storageclass.beta.kubernetes.io/is-default-class",
+}
+
+// DefaultStorageClass returns the name and volume binding mode of the
+// cluster's default StorageClass, or an empty name if it has none. More than
+// one default is an error since claims without a class would then be
+// rejected. A class without a binding mode binds immediately.
+func DefaultStorageClass(kubeClientset kubernetes.Interface) (string, storagev1.VolumeBindingMode, error) {
+	classes, err := kubeClientset.StorageV1().
+		StorageClasses().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return "", "", errors.Wrap(err, "listing StorageClasses")
+	}
+
+	var defaults []storagev1.StorageClass
+	for _, class := range classes.Items {
+		if isDefaultStorageClass(class) {
+			defaults = append(defaults, class)
+		}
+	}
+
+	switch len(defaults) {
+	case 0:
+		return "", "", nil
+	case 1:
+		mode := storagev1.VolumeBindingImmediate
+		if defaults[0].VolumeBindingMode != nil {
+			mode = *defaults[0].VolumeBindingMode
+		}
+
+		return defaults[0].Name, mode, nil
+	default:
+		names := make([]string, 0, len(defaults))
+		for _, class := range defaults {
+			names = append(names, class.Name)
+		}
+		sort.Strings(names)
+
+		return "", "", errors.Errorf("cluster has more than one default StorageClass: %s", strings.Join(names, ", "))
+	}
+}
+
+func isDefaultStorageClass(class storagev1.StorageClass) bool {
+	for _, key := range defaultStorageClassAnnotations {
+		if class.Annotations[key] == "true" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewPersistentVolumeClaim returns a ReadWriteOnce claim of the given size
+// that leaves the StorageClass unset so that the default is used
+func NewPersistentVolumeClaim(name string, size resource.Quantity) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+}
+
+// NewVolumeWriterPod returns a pod that writes content to a file on the
+// volume of the given claim, syncing it to disk before exiting
+func NewVolumeWriterPod(name, claimName, content string) *corev1.Pod {
+	script := fmt.Sprintf(`echo "$%s" > %s && sync`, volumeCheckContentEnv, volumeCheckFile)
+	pod := newVolumeCheckPod(name, claimName, script)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: volumeCheckContentEnv, Value: content},
+	}
+
+	return pod
+}
+
+// NewVolumeReaderPod returns a pod that prints the file written by the pod
+// from NewVolumeWriterPod to its logs
+func NewVolumeReaderPod(name, claimName string) *corev1.Pod {
+	return newVolumeCheckPod(name, claimName, "cat "+volumeCheckFile)
+}
+
+func newVolumeCheckPod(name, claimName, script string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:    "check",
+					Image:   VolumeCheckImage,
+					Command: []string{"sh", "-c", script},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "data", MountPath: volumeMountPath},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: claimName,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// WaitForPodSucceeded waits for the given pod to run to completion. Polling
+// stops immediately if the pod fails. On timeout, the error includes the last
+// observed phase.
+func WaitForPodSucceeded(kubeClientset kubernetes.Interface, namespace, name string, poll, timeout time.Duration) error {
+	return WaitForPodSucceededWithContext(gocontext.Background(), kubeClientset, namespace, name, poll, timeout)
+}
+
+// WaitForPodSucceededWithContext is the same as WaitForPodSucceeded but
+// stops waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForPodSucceededWithContext(ctx gocontext.Context, kubeClientset kubernetes.Interface, namespace, name string, poll, timeout time.Duration) error {
+	var phase corev1.PodPhase
+	err := util.PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		pod, err := kubeClientset.CoreV1().
+			Pods(namespace).
+			Get(name, metav1.GetOptions{})
+		if err != nil {
+			if util.IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "getting pod %s/%s", namespace, name)
+		}
+
+		phase = pod.Status.Phase
+		switch phase {
+		case corev1.PodSucceeded:
+			return true, nil
+		case corev1.PodFailed:
+			return false, errors.Errorf("pod %s/%s failed", namespace, name)
+		default:
+			return false, nil
+		}
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out waiting for pod %s/%s to complete; last observed phase %q",
+			namespace, name, phase)
+	}
+
+	return err
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func storageClass(name string, annotations map[string]string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: annotations,
+		},
+		Provisioner: "dobs.csi.digitalocean.com",
+	}
+}
+
+func waitForFirstConsumer(class *storagev1.StorageClass) *storagev1.StorageClass {
+	mode := storagev1.VolumeBindingWaitForFirstConsumer
+	class = class.DeepCopy()
+	class.VolumeBindingMode = &mode
+
+	return class
+}
+
+func TestDefaultStorageClass(t *testing.T) {
+	standard := storageClass("standard", nil)
+	ga := storageClass("do-block-storage", map[string]string{"storageclass.kubernetes.io/is-default-class": "true"})
+	beta := storageClass("legacy", map[string]string{"storageclass.beta.kubernetes.io/is-default-class": "true"})
+	notDefault := storageClass("slow", map[string]string{"storageclass.kubernetes.io/is-default-class": "false"})
+
+	tests := []struct {
+		name     string
+		classes  []runtime.Object
+		want     string
+		wantMode storagev1.VolumeBindingMode
+		wantErr  bool
+	}{
+		{"no classes", nil, "", "", false},
+		{"no default", []runtime.Object{standard, notDefault}, "", "", false},
+		{"default", []runtime.Object{standard, ga}, "do-block-storage", storagev1.VolumeBindingImmediate, false},
+		{"beta default", []runtime.Object{beta, notDefault}, "legacy", storagev1.VolumeBindingImmediate, false},
+		{"default waiting for a consumer", []runtime.Object{waitForFirstConsumer(ga)}, "do-block-storage", storagev1.VolumeBindingWaitForFirstConsumer, false},
+		{"more than one default", []runtime.Object{ga, beta}, "", "", true},
+	}
+
+	for _, test := range tests {
+		got, mode, err := DefaultStorageClass(fake.NewSimpleClientset(test.classes...))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.name, test.wantErr, err)
+		}
+		if got != test.want || mode != test.wantMode {
+			t.Errorf("%s: expected %q (%s), got %q (%s)", test.name, test.want, test.wantMode, got, mode)
+		}
+	}
+}
+
+func TestVolumeCheckPods(t *testing.T) {
+	writer := NewVolumeWriterPod("writer", "claim", "content")
+	reader := NewVolumeReaderPod("reader", "claim")
+
+	for _, pod := range []*corev1.Pod{writer, reader} {
+		if claim := pod.Spec.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != "claim" {
+			t.Errorf("%s: expected volume from claim %q, got %v", pod.Name, "claim", pod.Spec.Volumes[0])
+		}
+		if mount := pod.Spec.Containers[0].VolumeMounts[0]; mount.MountPath != volumeMountPath {
+			t.Errorf("%s: expected volume mounted at %s, got %s", pod.Name, volumeMountPath, mount.MountPath)
+		}
+		if pod.Spec.RestartPolicy != corev1.RestartPolicyNever {
+			t.Errorf("%s: expected pod to run once, got restart policy %s", pod.Name, pod.Spec.RestartPolicy)
+		}
+	}
+
+	if env := writer.Spec.Containers[0].Env; len(env) != 1 || env[0].Value != "content" {
+		t.Errorf("expected writer to be given the content, got %v", env)
+	}
+	if script := reader.Spec.Containers[0].Command[2]; !strings.Contains(script, volumeCheckFile) {
+		t.Errorf("expected reader to read %s, got %q", volumeCheckFile, script)
+	}
+}
+
+func pod(phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "check",
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+		},
+	}
+}
+
+func TestWaitForPodSucceeded(t *testing.T) {
+	poll := time.Millisecond
+	timeout := 20 * time.Millisecond
+
+	if err := WaitForPodSucceeded(fake.NewSimpleClientset(pod(corev1.PodSucceeded)), "default", "check", poll, timeout); err != nil {
+		t.Errorf("expected succeeded pod to pass, got %v", err)
+	}
+
+	err := WaitForPodSucceeded(fake.NewSimpleClientset(pod(corev1.PodFailed)), "default", "check", poll, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("expected error for failed pod, got %v", err)
+	}
+
+	err = WaitForPodSucceeded(fake.NewSimpleClientset(pod(corev1.PodPending)), "default", "check", poll, timeout)
+	if err == nil || !strings.Contains(err.Error(), `last observed phase "Pending"`) {
+		t.Errorf("expected timeout error including the phase, got %v", err)
+	}
+}
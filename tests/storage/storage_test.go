@@ -0,0 +1,316 @@
+package storage
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+const (
+	claimName     = "cs-e2e-storage"
+	writerPodName = "cs-e2e-storage-writer"
+	readerPodName = "cs-e2e-storage-reader"
+)
+
+type storageContext struct {
+	*testcontext.E2eTest
+
+	// Name of the cluster's default StorageClass, only set if it has one,
+	// and when it binds volumes
+	storageClass string
+	bindingMode  storagev1.VolumeBindingMode
+
+	// Namespace created to hold the claim and the pods using it
+	namespace string
+
+	// Content written to the volume, unique to this run so that a stale
+	// volume can't pass the check
+	content string
+
+	// Whether the writer pod was created, and whether it completed, i.e. whether there is anything to
+	// read back
+	writerCreated bool
+	written       bool
+}
+
+var context *storageContext
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+	environment          string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	volumeSize string
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.StringVar(&environment, "environment", "", "Containership Cloud environment the cluster is in (stage or production), to label pushed timings with (unlabeled if empty)")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&volumeSize, "volume-size", "1Gi", "size of the PersistentVolumeClaim to provision")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.DefaultTimeout, "timeout for waiting on the claim to be bound and on pods to complete")
+}
+
+func TestStorage(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Storage Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+	if environment != "" {
+		_, err := constants.ConfigForEnvironment(constants.Environment(environment))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	_, err := resource.ParseQuantity(volumeSize)
+	Expect(err).NotTo(HaveOccurred(), "-volume-size must be a quantity, e.g. 1Gi")
+
+	// Only Kubernetes is required for this suite
+	e2eTest := &testcontext.E2eTest{}
+	_, err = e2eTest.InitKubernetesClientset()
+	Expect(err).NotTo(HaveOccurred())
+
+	context = &storageContext{
+		E2eTest: e2eTest,
+		content: fmt.Sprintf("cs-e2e-%d", time.Now().UnixNano()),
+	}
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if teardown fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "storage", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if context == nil || context.namespace == "" {
+		return
+	}
+
+	// Deleting the namespace deletes the pods and the claim with it, which
+	// releases the volume to be deleted by its reclaim policy
+	log.By(fmt.Sprintf("deleting namespace %q", context.namespace))
+	Expect(util.DeleteNamespace(context.KubernetesClientset, context.namespace,
+		pollInterval, constants.NamespaceDeleteTimeout)).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
+})
+
+var _ = Describe("Provisioning a persistent volume", func() {
+	It("should have a default StorageClass", func() {
+		name, mode, err := DefaultStorageClass(context.KubernetesClientset)
+		Expect(err).NotTo(HaveOccurred())
+		if name == "" {
+			testutil.Skipf(testutil.FeatureDisabled, "cluster has no default StorageClass")
+		}
+
+		log.Info("using default StorageClass", "name", name, "volumeBindingMode", string(mode))
+		context.storageClass = name
+		context.bindingMode = mode
+	})
+
+	It("should bind a claim using the default StorageClass", func() {
+		skipIfNoStorageClass()
+
+		ns, err := context.KubernetesClientset.CoreV1().
+			Namespaces().
+			Create(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "cs-e2e-storage-",
+				},
+			})
+		Expect(err).NotTo(HaveOccurred())
+
+		context.namespace = ns.Name
+
+		log.By(fmt.Sprintf("creating a %s PersistentVolumeClaim", volumeSize))
+		_, err = context.KubernetesClientset.CoreV1().
+			PersistentVolumeClaims(context.namespace).
+			Create(NewPersistentVolumeClaim(claimName, resource.MustParse(volumeSize)))
+		Expect(err).NotTo(HaveOccurred())
+
+		// A StorageClass that waits for the first consumer only binds once a
+		// pod uses the claim, so the writer has to come first
+		if context.bindingMode == storagev1.VolumeBindingWaitForFirstConsumer {
+			Expect(createWriterPod()).To(Succeed())
+		}
+
+		Expect(metrics.Time("pvc-bind", func() error {
+			return util.WaitForPVCBoundWithContext(ctx, context.KubernetesClientset,
+				context.namespace, claimName, pollInterval, timeout)
+		})).Should(Succeed())
+
+		if !context.writerCreated {
+			Expect(createWriterPod()).To(Succeed())
+		}
+	})
+
+	It("should write a file to the mounted volume", func() {
+		skipIfNoClaim()
+		if !context.writerCreated {
+			Skip("no writer pod was created")
+		}
+
+		err := WaitForPodSucceededWithContext(ctx, context.KubernetesClientset,
+			context.namespace, writerPodName, pollInterval, timeout)
+		Expect(err).NotTo(HaveOccurred(), "writer pod logs:\n%s", podLogs(writerPodName))
+
+		context.written = true
+	})
+
+	It("should read the file back from another pod", func() {
+		skipIfNoClaim()
+		if !context.written {
+			Skip("nothing was written to the volume")
+		}
+
+		// A new pod proves the data outlived the pod that wrote it
+		_, err := context.KubernetesClientset.CoreV1().
+			Pods(context.namespace).
+			Create(NewVolumeReaderPod(readerPodName, claimName))
+		Expect(err).NotTo(HaveOccurred())
+
+		err = WaitForPodSucceededWithContext(ctx, context.KubernetesClientset,
+			context.namespace, readerPodName, pollInterval, timeout)
+		Expect(err).NotTo(HaveOccurred(), "reader pod logs:\n%s", podLogs(readerPodName))
+
+		Expect(podLogs(readerPodName)).To(Equal(context.content))
+	})
+})
+
+// Each spec must skip itself because skipping one spec doesn't skip the
+// remaining specs
+func skipIfNoStorageClass() {
+	if context.storageClass == "" {
+		testutil.Skipf(testutil.FeatureDisabled, "cluster has no default StorageClass")
+	}
+}
+
+func skipIfNoClaim() {
+	skipIfNoStorageClass()
+	if context.namespace == "" {
+		Skip("no PersistentVolumeClaim was created")
+	}
+}
+
+// createWriterPod creates the pod writing the suite's content to the claim
+func createWriterPod() error {
+	_, err := context.KubernetesClientset.CoreV1().
+		Pods(context.namespace).
+		Create(NewVolumeWriterPod(writerPodName, claimName, context.content))
+	if err != nil {
+		return errors.Wrap(err, "creating writer pod")
+	}
+
+	context.writerCreated = true
+	return nil
+}
+
+// podLogs returns the trimmed logs of the named pod in the suite's
+// namespace, or a note if they can't be fetched
+func podLogs(name string) string {
+	data, err := context.KubernetesClientset.CoreV1().
+		Pods(context.namespace).
+		GetLogs(name, &corev1.PodLogOptions{}).
+		Do().
+		Raw()
+	if err != nil {
+		return fmt.Sprintf("(failed to get logs: %v)", err)
+	}
+
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,87 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+)
+
+// processStart approximates when the process started, which is what a CI
+// wall-clock limit is measured from
+var processStart = time.Now()
+
+// deadlineReached is set to 1 once a deadline watchdog has aborted the suite
+var deadlineReached int32
+
+// StartDeadlineWatchdog aborts the suite by cancelling its context once the
+// process has run for deadline minus margin, so that the running spec fails
+// promptly instead of the process being killed by CI without any output. The
+// remaining specs are skipped by SkipIfDeadlineReached, leaving the margin
+// for teardown. A zero deadline disables the watchdog. The returned func
+// stops the watchdog and should be called once the suite is done.
+func StartDeadlineWatchdog(deadline, margin time.Duration, cancel context.CancelFunc) (stop func(), err error) {
+	return startDeadlineWatchdog(processStart, deadline, margin, cancel)
+}
+
+func startDeadlineWatchdog(start time.Time, deadline, margin time.Duration, cancel context.CancelFunc) (func(), error) {
+	if deadline < 0 || margin < 0 {
+		return nil, errors.New("suite deadline and margin must not be negative")
+	}
+	if deadline == 0 {
+		return func() {}, nil
+	}
+	if margin >= deadline {
+		return nil, errors.Errorf("suite deadline margin %s must be less than the deadline %s", margin, deadline)
+	}
+
+	timer := time.NewTimer(time.Until(start.Add(deadline - margin)))
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-timer.C:
+			log.Info("approaching suite deadline, aborting",
+				"deadline", deadline.String(), "teardownMargin", margin.String())
+			atomic.StoreInt32(&deadlineReached, 1)
+			cancel()
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			timer.Stop()
+			close(done)
+		})
+	}, nil
+}
+
+// isDeadlineReached returns true once a deadline watchdog has aborted the
+// suite
+func isDeadlineReached() bool {
+	return atomic.LoadInt32(&deadlineReached) == 1
+}
+
+// WrapIfDeadlineReached wraps err to say that the suite was aborted if a
+// deadline watchdog has cancelled its context, so that a failure caused by
+// the abort isn't mistaken for a plain context cancellation
+func WrapIfDeadlineReached(err error) error {
+	if err == nil || !isDeadlineReached() {
+		return err
+	}
+
+	return errors.Wrap(err, "approaching suite deadline, aborting")
+}
+
+// SkipIfDeadlineReached skips the current spec if a deadline watchdog has
+// aborted the suite. It is meant to be called from a top-level BeforeEach.
+func SkipIfDeadlineReached() {
+	if isDeadlineReached() {
+		Skipf(SuiteDeadline, "approaching suite deadline, aborting")
+	}
+}
@@ -0,0 +1,88 @@
+package testutil
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartDeadlineWatchdog(t *testing.T) {
+	defer atomic.StoreInt32(&deadlineReached, 0)
+
+	for _, test := range []struct {
+		deadline time.Duration
+		margin   time.Duration
+	}{
+		{-time.Minute, 0},
+		{time.Minute, -time.Second},
+		{time.Minute, time.Minute},
+	} {
+		if _, err := startDeadlineWatchdog(time.Now(), test.deadline, test.margin, func() {}); err == nil {
+			t.Errorf("expected error for deadline %s with margin %s", test.deadline, test.margin)
+		}
+	}
+
+	// A zero deadline disables the watchdog
+	ctx, cancel := context.WithCancel(context.Background())
+	stop, err := startDeadlineWatchdog(time.Now().Add(-time.Hour), 0, 0, cancel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stop()
+	if ctx.Err() != nil || isDeadlineReached() {
+		t.Error("expected disabled watchdog not to abort")
+	}
+
+	// Stopped before the deadline
+	stop, err = startDeadlineWatchdog(time.Now(), time.Hour, time.Minute, cancel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stop()
+	stop()
+	if ctx.Err() != nil || isDeadlineReached() {
+		t.Error("expected stopped watchdog not to abort")
+	}
+
+	// The margin is measured back from the deadline
+	start := time.Now()
+	stop, err = startDeadlineWatchdog(start, time.Hour+50*time.Millisecond, time.Hour, cancel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected watchdog to cancel the context")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected watchdog to wait until the margin, aborted after %s", elapsed)
+	}
+	if !isDeadlineReached() {
+		t.Error("expected deadline to be marked as reached")
+	}
+}
+
+func TestWrapIfDeadlineReached(t *testing.T) {
+	defer atomic.StoreInt32(&deadlineReached, 0)
+
+	if err := WrapIfDeadlineReached(nil); err != nil {
+		t.Errorf("expected nil error to stay nil, got %v", err)
+	}
+	if err := WrapIfDeadlineReached(context.Canceled); err != context.Canceled {
+		t.Errorf("expected error to be unchanged before the deadline, got %v", err)
+	}
+
+	atomic.StoreInt32(&deadlineReached, 1)
+	if err := WrapIfDeadlineReached(nil); err != nil {
+		t.Errorf("expected nil error to stay nil, got %v", err)
+	}
+	err := WrapIfDeadlineReached(context.Canceled)
+	if err == nil || !strings.HasPrefix(err.Error(), "approaching suite deadline, aborting") {
+		t.Errorf("expected error to be wrapped once the deadline is reached, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package testutil
+
+import (
+	"github.com/onsi/ginkgo"
+
+	"github.com/mattkelly/containership-test-v2-experiment/debug"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+)
+
+// DumpStateOnFailure writes the state of the cluster under test to dir using
+// debug.DumpState if the current spec failed. It is meant to be called from
+// an AfterEach. Nothing is dumped before the cluster is known, and errors are
+// only logged so that a failed dump can't mask the failure of the spec.
+func DumpStateOnFailure(e2eTest *testcontext.E2eTest, dir string) {
+	if !ginkgo.CurrentGinkgoTestDescription().Failed {
+		return
+	}
+	if e2eTest == nil || e2eTest.ClusterID == "" {
+		return
+	}
+
+	err := debug.DumpState(e2eTest.ContainershipClientset, e2eTest.KubernetesClientset,
+		e2eTest.OrganizationID, e2eTest.ClusterID, dir)
+	if err != nil {
+		log.Error(err, "dumping cluster state", "dir", dir)
+	}
+}
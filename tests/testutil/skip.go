@@ -0,0 +1,40 @@
+// Package testutil holds helpers shared by the ginkgo suites
+package testutil
+
+import (
+	"fmt"
+
+	"github.com/onsi/ginkgo"
+)
+
+// SkipReason categorizes why a spec was skipped so that skips caused by the
+// environment lacking something can be told apart from genuine coverage
+type SkipReason string
+
+const (
+	// NoWorkerPools means the cluster has no (or not enough) worker pools
+	NoWorkerPools SkipReason = "no-worker-pools"
+
+	// ClusterIDUnavailable means the cluster ID could not be determined
+	ClusterIDUnavailable SkipReason = "cluster-id-unavailable"
+
+	// FeatureDisabled means the behavior under test was not enabled, e.g. by
+	// a flag or because the cluster doesn't have it deployed
+	FeatureDisabled SkipReason = "feature-disabled"
+
+	// SuiteDeadline means the suite was aborted because its deadline was
+	// approaching, see StartDeadlineWatchdog
+	SuiteDeadline SkipReason = "suite-deadline"
+)
+
+// Skipf skips the current spec with a message prefixed by the reason, e.g.
+// "[no-worker-pools] no worker pools to scale". The prefix is what ends up
+// in the reported skip message, so skips can be counted by reason.
+func Skipf(reason SkipReason, format string, args ...interface{}) {
+	// Skip the frame of this helper so the skip is reported at the caller
+	ginkgo.Skip(skipMessage(reason, format, args...), 1)
+}
+
+func skipMessage(reason SkipReason, format string, args ...interface{}) string {
+	return fmt.Sprintf("[%s] %s", reason, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,24 @@
+package testutil
+
+import (
+	"testing"
+)
+
+func TestSkipMessage(t *testing.T) {
+	tests := []struct {
+		reason   SkipReason
+		format   string
+		args     []interface{}
+		expected string
+	}{
+		{NoWorkerPools, "no worker pools to scale", nil, "[no-worker-pools] no worker pools to scale"},
+		{NoWorkerPools, "cluster only has %d worker pools", []interface{}{2}, "[no-worker-pools] cluster only has 2 worker pools"},
+		{FeatureDisabled, "-concurrent-scale not set", nil, "[feature-disabled] -concurrent-scale not set"},
+	}
+
+	for _, test := range tests {
+		if got := skipMessage(test.reason, test.format, test.args...); got != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+}
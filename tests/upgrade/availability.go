@@ -0,0 +1,183 @@
+package upgrade
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// Availability is how many nodes of a node pool must stay Ready while it's
+// being updated
+type Availability struct {
+	// Count is the number of nodes the pool is configured with
+	Count int
+
+	// MaxUnavailable is the number of nodes the pool's update strategy
+	// allows to be unavailable at once
+	MaxUnavailable int
+}
+
+// MinReady returns the fewest Ready nodes the pool may have during an update
+func (a Availability) MinReady() int {
+	return a.Count - a.MaxUnavailable
+}
+
+// NodePoolAvailability returns the availability the given node pool's update
+// strategy requires, or nil if it has no update strategy configured. A
+// percentage maxUnavailable is taken of the pool's count and rounded down,
+// as Kubernetes does for a rolling update.
+func NodePoolAvailability(pool types.NodePool) (*Availability, error) {
+	count := 0
+	if pool.Count != nil {
+		count = int(*pool.Count)
+	}
+
+	availability, err := readAvailability(pool, count)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading update strategy of node pool %q", pool.ID)
+	}
+
+	return availability, nil
+}
+
+func readAvailability(pool interface{}, count int) (*Availability, error) {
+	// types.NodePool has no UpdateStrategy field
+	var fields struct {
+		UpdateStrategy *struct {
+			MaxUnavailable *intstr.IntOrString `json:"max_unavailable"`
+		} `json:"update_strategy"`
+	}
+	if err := util.RoundTripJSON(pool, &fields); err != nil {
+		return nil, err
+	}
+
+	if fields.UpdateStrategy == nil || fields.UpdateStrategy.MaxUnavailable == nil {
+		return nil, nil
+	}
+
+	maxUnavailable, err := intstr.GetValueFromIntOrPercent(fields.UpdateStrategy.MaxUnavailable, count, false)
+	if err != nil {
+		return nil, err
+	}
+	if maxUnavailable < 0 {
+		return nil, errors.Errorf("max_unavailable must not be negative, got %d", maxUnavailable)
+	}
+
+	return &Availability{
+		Count:          count,
+		MaxUnavailable: maxUnavailable,
+	}, nil
+}
+
+// CheckAvailability returns an error if lowest, the fewest Ready nodes
+// observed in the given pool during an update, is below the minimum its
+// availability requires. observed is false if the pool was never sampled, in
+// which case availability can't be verified.
+func CheckAvailability(poolID string, availability Availability, lowest int, observed bool) error {
+	if !observed {
+		return errors.Errorf("Ready nodes of node pool %q were never sampled", poolID)
+	}
+
+	if lowest < availability.MinReady() {
+		return errors.Errorf("node pool %q dropped to %d Ready nodes, below the minimum of %d (count %d, maxUnavailable %d)",
+			poolID, lowest, availability.MinReady(), availability.Count, availability.MaxUnavailable)
+	}
+
+	return nil
+}
+
+// ReadyNodeSampler tracks the fewest Ready Kubernetes nodes observed in each
+// of a set of node pools. Failed samples, e.g. while the proxy is briefly
+// unavailable during a control plane upgrade, are skipped.
+type ReadyNodeSampler struct {
+	poolIDs []string
+
+	mu     sync.Mutex
+	lowest map[string]int
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// StartReadyNodeSampler starts sampling the Ready nodes of the given pools
+// every interval until Stop is called
+func StartReadyNodeSampler(kubeClientset kubernetes.Interface, poolIDs []string, interval time.Duration) *ReadyNodeSampler {
+	s := &ReadyNodeSampler{
+		poolIDs: poolIDs,
+		lowest:  make(map[string]int),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		wait.Until(func() {
+			nodeList, err := kubeClientset.CoreV1().
+				Nodes().
+				List(metav1.ListOptions{})
+			if err != nil {
+				return
+			}
+
+			s.sample(nodeList.Items)
+		}, interval, s.stopCh)
+	}()
+
+	return s
+}
+
+// Stop stops sampling and returns the fewest Ready nodes observed in each
+// pool. Pools that were never sampled are left out. It is safe to call more
+// than once.
+func (s *ReadyNodeSampler) Stop() map[string]int {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	<-s.done
+
+	return s.Lowest()
+}
+
+// Lowest returns the fewest Ready nodes observed in each pool so far
+func (s *ReadyNodeSampler) Lowest() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lowest := make(map[string]int, len(s.lowest))
+	for id, n := range s.lowest {
+		lowest[id] = n
+	}
+
+	return lowest
+}
+
+func (s *ReadyNodeSampler) sample(nodes []corev1.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.poolIDs {
+		ready := 0
+		for _, node := range util.FilterNodesByPool(nodes, id) {
+			if util.IsNodeReady(node) {
+				ready++
+			}
+		}
+
+		if lowest, ok := s.lowest[id]; !ok || ready < lowest {
+			s.lowest[id] = ready
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestReadAvailability(t *testing.T) {
+	tests := []struct {
+		fields  string
+		count   int
+		want    *Availability
+		wantErr bool
+	}{
+		{`{"id": "pool"}`, 3, nil, false},
+		{`{"id": "pool", "update_strategy": {}}`, 3, nil, false},
+		{`{"id": "pool", "update_strategy": {"max_unavailable": 1}}`, 3, &Availability{Count: 3, MaxUnavailable: 1}, false},
+		{`{"id": "pool", "update_strategy": {"max_unavailable": "50%"}}`, 3, &Availability{Count: 3, MaxUnavailable: 1}, false},
+		{`{"id": "pool", "update_strategy": {"max_unavailable": "half"}}`, 3, nil, true},
+		{`{"id": "pool", "update_strategy": {"max_unavailable": -1}}`, 3, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := readAvailability(json.RawMessage(test.fields), test.count)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.fields, test.wantErr, err)
+			continue
+		}
+
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("%s: expected availability %v, got %v", test.fields, test.want, got)
+		}
+	}
+}
+
+func TestCheckAvailability(t *testing.T) {
+	availability := Availability{Count: 3, MaxUnavailable: 1}
+
+	if err := CheckAvailability("pool", availability, 2, true); err != nil {
+		t.Errorf("expected minimum availability to pass, got %v", err)
+	}
+
+	err := CheckAvailability("pool", availability, 1, true)
+	if err == nil || !strings.Contains(err.Error(), "dropped to 1 Ready nodes, below the minimum of 2") {
+		t.Errorf("expected error reporting the lowest Ready count, got %v", err)
+	}
+
+	if err := CheckAvailability("pool", availability, 0, false); err == nil {
+		t.Error("expected error for a pool that was never sampled")
+	}
+}
+
+func poolNodes(poolID string, ready, notReady int) []corev1.Node {
+	var nodes []corev1.Node
+	for i := 0; i < ready+notReady; i++ {
+		status := corev1.ConditionTrue
+		if i >= ready {
+			status = corev1.ConditionFalse
+		}
+
+		nodes = append(nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("%s-%d", poolID, i),
+				Labels: map[string]string{
+					constants.NodePoolIDLabelKey: poolID,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: status},
+				},
+			},
+		})
+	}
+
+	return nodes
+}
+
+func TestReadyNodeSamplerSample(t *testing.T) {
+	s := &ReadyNodeSampler{
+		poolIDs: []string{"a", "b"},
+		lowest:  make(map[string]int),
+	}
+
+	s.sample(append(poolNodes("a", 3, 0), poolNodes("other", 0, 2)...))
+	s.sample(poolNodes("a", 2, 1))
+	s.sample(poolNodes("a", 3, 0))
+
+	lowest := s.Lowest()
+	if lowest["a"] != 2 {
+		t.Errorf("expected lowest Ready count of 2 for pool a, got %d", lowest["a"])
+	}
+	if n, ok := lowest["b"]; !ok || n != 0 {
+		t.Errorf("expected lowest Ready count of 0 for pool b without nodes, got %d, %t", n, ok)
+	}
+	if _, ok := lowest["other"]; ok {
+		t.Error("expected untracked pool to be left out")
+	}
+}
@@ -0,0 +1,96 @@
+package upgrade
+
+import (
+	// Aliased because the upgrade suite declares a package-level context
+	gocontext "context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+// WaitForAllPoolsAtVersion waits for every node pool in the given cluster to
+// report the target Kubernetes version and be RUNNING. Pools upgrade one
+// after another, so pools that are RUNNING on the old version are waited on.
+// Polling stops immediately if any pool enters a status other than RUNNING or
+// UPDATING. On timeout, the error lists the pools that hadn't finished.
+func WaitForAllPoolsAtVersion(cs cloud.Interface, organizationID, clusterID, targetVersion string, poll, timeout time.Duration) error {
+	return WaitForAllPoolsAtVersionWithContext(gocontext.Background(), cs, organizationID, clusterID, targetVersion, poll, timeout)
+}
+
+// WaitForAllPoolsAtVersionWithContext is the same as WaitForAllPoolsAtVersion
+// but stops waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForAllPoolsAtVersionWithContext(ctx gocontext.Context, cs cloud.Interface, organizationID, clusterID, targetVersion string, poll, timeout time.Duration) error {
+	var lagging []string
+	start := time.Now()
+
+	err := util.PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		pools, err := cs.Provision().
+			NodePools(organizationID, clusterID).
+			List()
+		if err != nil {
+			if util.IsRetryableCloudError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "GETing node pools")
+		}
+
+		lagging, err = poolsNotAtVersion(pools, targetVersion)
+		if err != nil {
+			return false, err
+		}
+
+		return len(lagging) == 0, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out after %s waiting for every node pool to be RUNNING at version %s; not yet: %s",
+			time.Since(start).Round(time.Second), targetVersion, strings.Join(lagging, ", "))
+	}
+
+	return err
+}
+
+// poolsNotAtVersion returns a description of each pool that isn't RUNNING at
+// the target version, sorted by pool ID, or an error if any pool is in a
+// state other than RUNNING or UPDATING
+func poolsNotAtVersion(pools []types.NodePool, targetVersion string) ([]string, error) {
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].ID < pools[j].ID
+	})
+
+	var lagging []string
+	for _, pool := range pools {
+		status := *pool.Status.Type
+		if status != "RUNNING" && status != "UPDATING" {
+			return nil, errors.Errorf("node pool %q entered unexpected state %q", pool.ID, status)
+		}
+
+		version := ""
+		if pool.KubernetesVersion != nil {
+			version = *pool.KubernetesVersion
+		}
+
+		if status != "RUNNING" || normalizeVersion(version) != normalizeVersion(targetVersion) {
+			lagging = append(lagging, fmt.Sprintf("%s (%s, %s)", pool.ID, version, status))
+		}
+	}
+
+	return lagging, nil
+}
+
+// normalizeVersion strips the leading "v" that Kubernetes reports but the
+// Containership API does not
+func normalizeVersion(version string) string {
+	return strings.TrimPrefix(version, "v")
+}
@@ -0,0 +1,489 @@
+package upgrade
+
+import (
+	// Aliased because context is used for the suite context below
+	gocontext "context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/csctl/cloud"
+	"github.com/containership/csctl/cloud/provision/types"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cleanup"
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+	"github.com/mattkelly/containership-test-v2-experiment/metrics"
+	"github.com/mattkelly/containership-test-v2-experiment/provision"
+	"github.com/mattkelly/containership-test-v2-experiment/reporting"
+	testcontext "github.com/mattkelly/containership-test-v2-experiment/tests/context"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/scale"
+	"github.com/mattkelly/containership-test-v2-experiment/tests/testutil"
+	"github.com/mattkelly/containership-test-v2-experiment/util"
+)
+
+type upgradeContext struct {
+	*testcontext.E2eTest
+
+	// The Kubernetes version the cluster was on before the upgrade
+	initialKubernetesVersion string
+
+	// The Kubernetes nodes just before the upgrade was requested
+	nodesBeforeUpgrade map[string]struct{}
+
+	// The availability required of each node pool with an update strategy
+	// and the fewest Ready nodes observed in it during the upgrade
+	availability     map[string]Availability
+	lowestReadyNodes map[string]int
+}
+
+var context *upgradeContext
+
+// ctx is cancelled on SIGINT or SIGTERM so that waits stop promptly when a
+// run is aborted instead of hanging until they time out
+var (
+	ctx       gocontext.Context
+	cancelCtx gocontext.CancelFunc
+)
+
+// stopCleanupOnSignal stops the registered cleanups from being run on SIGINT
+// or SIGTERM, see cleanup.HandleSignals
+var stopCleanupOnSignal func()
+
+// stopDeadlineWatchdog stops the watchdog that aborts the suite before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+var stopDeadlineWatchdog func()
+
+// Flags
+var (
+	logFormat string
+
+	suiteDeadline       time.Duration
+	suiteDeadlineMargin time.Duration
+
+	junitOutputDir string
+
+	timingOutputFilename string
+	pushgatewayURL       string
+
+	dumpOnFailure bool
+	dumpOutputDir string
+
+	environment string
+
+	organizationID string
+	tokenEnv       string
+
+	clusterID        string
+	templateFilename string
+	clusterFilename  string
+
+	targetKubernetesVersion string
+
+	checkUniformRuntime bool
+
+	pollInterval time.Duration
+	timeout      time.Duration
+)
+
+func init() {
+	flag.StringVar(&logFormat, "log-format", log.FormatText, "format of progress output (text or json)")
+	flag.DurationVar(&suiteDeadline, "suite-deadline", 0, "wall-clock limit of the run, e.g. the CI job timeout; the suite is aborted -suite-deadline-margin before it so that teardown can run (disabled if zero)")
+	flag.DurationVar(&suiteDeadlineMargin, "suite-deadline-margin", constants.DefaultSuiteDeadlineMargin, "time to leave for teardown before -suite-deadline")
+	flag.StringVar(&junitOutputDir, "junit-output", "", "directory to write JUnit XML results to")
+	flag.StringVar(&timingOutputFilename, "timing-output", "", "path to write operation timings to as JSON")
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push operation timings to, if any")
+	flag.BoolVar(&dumpOnFailure, "dump-on-failure", false, "dump the cluster, node pool, and node state to a JSON file when a spec fails")
+	flag.StringVar(&dumpOutputDir, "dump-output", filepath.Join(os.TempDir(), "cs-e2e-dumps"), "directory to write state dumps to")
+
+	flag.StringVar(&environment, "environment", string(constants.Stage), "Containership Cloud environment to run against (stage or production)")
+	flag.StringVar(&organizationID, "organization-id", "", "Containership organization to run against (defaults to CONTAINERSHIP_ORGANIZATION_ID env var, then the test organization)")
+	flag.StringVar(&tokenEnv, "token-env", testcontext.DefaultTokenEnv, "environment variable to read the Containership Cloud token from")
+
+	// The cluster to run against is the one given here, else the one
+	// KUBECONFIG points to, else one provisioned from -template and -cluster
+	flag.StringVar(&clusterID, "cluster-id", "", "ID of an existing cluster to run against instead of the one KUBECONFIG points to")
+	flag.StringVar(&templateFilename, "template", "", "path to template file to provision a cluster from if there is no existing one (requires -cluster)")
+	flag.StringVar(&clusterFilename, "cluster", "", "path to cluster file to provision a cluster from if there is no existing one (requires -template)")
+
+	flag.StringVar(&targetKubernetesVersion, "target-kubernetes-version", "", "Kubernetes version to upgrade to")
+	flag.BoolVar(&checkUniformRuntime, "check-uniform-runtime", false, "verify that every node reports the same container runtime, OS image, and kernel")
+
+	flag.DurationVar(&pollInterval, "poll-interval", constants.DefaultPollInterval, "interval at which to poll while waiting")
+	flag.DurationVar(&timeout, "timeout", constants.UpgradeTimeout, "timeout for waiting on each node pool upgrade")
+}
+
+func TestUpgrade(t *testing.T) {
+	if err := log.SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hook up gomega to ginkgo
+	RegisterFailHandler(Fail)
+	reporting.RunSpecs(t, "Upgrade Suite", junitOutputDir)
+}
+
+var _ = SynchronizedBeforeSuite(func() []byte {
+	// Run only on first node
+	Expect(util.ValidatePollOptions(pollInterval, timeout)).To(Succeed())
+
+	// Start the watchdog before ensuring the cluster, since provisioning one
+	// can take up most of the deadline
+	startDeadlineWatchdog()
+
+	Expect(targetKubernetesVersion).NotTo(BeEmpty(), "please specify a version to upgrade to via -target-kubernetes-version")
+
+	token, err := testcontext.ReadToken(tokenEnv)
+	Expect(err).NotTo(HaveOccurred())
+
+	orgID, err := testcontext.ResolveOrganizationID(organizationID)
+	Expect(err).NotTo(HaveOccurred())
+
+	apiBaseURL, authBaseURL, provisionBaseURL, err := constants.URLsForEnvironment(constants.Environment(environment))
+	Expect(err).NotTo(HaveOccurred())
+
+	clientset, err := cloud.New(cloud.Config{
+		Token:            token,
+		APIBaseURL:       apiBaseURL,
+		AuthBaseURL:      authBaseURL,
+		ProvisionBaseURL: provisionBaseURL,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	e2eTest := &testcontext.E2eTest{
+		ContainershipClientset: clientset,
+		AuthToken:              token,
+		OrganizationID:         orgID,
+	}
+
+	Expect(testutil.WrapIfDeadlineReached(ensureCluster(e2eTest))).To(Succeed())
+
+	context = &upgradeContext{
+		E2eTest: e2eTest,
+	}
+
+	return nil
+}, func(_ []byte) {
+	// Run on all nodes after first one
+	// The first node already started its watchdog
+	if ctx == nil {
+		startDeadlineWatchdog()
+	}
+})
+
+// startDeadlineWatchdog sets up the suite context and aborts it before
+// -suite-deadline, see testutil.StartDeadlineWatchdog
+func startDeadlineWatchdog() {
+	ctx, cancelCtx = util.SignalContext()
+
+	var err error
+	stopDeadlineWatchdog, err = testutil.StartDeadlineWatchdog(suiteDeadline, suiteDeadlineMargin, cancelCtx)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+var _ = SynchronizedAfterSuite(func() {
+	// Run on all nodes
+	if stopDeadlineWatchdog != nil {
+		stopDeadlineWatchdog()
+	}
+	if cancelCtx != nil {
+		cancelCtx()
+	}
+}, func() {
+	// Run only on last node
+	// Report timings even if cleanup fails
+	defer func() {
+		if pushgatewayURL != "" {
+			// Best effort so that a metrics outage doesn't fail the suite
+			if err := metrics.Push(pushgatewayURL, "upgrade", environment); err != nil {
+				log.Error(err, "pushing timings", "url", pushgatewayURL)
+			}
+		}
+		Expect(metrics.Report(os.Stdout, timingOutputFilename)).To(Succeed())
+	}()
+
+	if stopCleanupOnSignal != nil {
+		stopCleanupOnSignal()
+	}
+
+	Expect(cleanup.Run()).To(Succeed())
+})
+
+var _ = BeforeEach(testutil.SkipIfDeadlineReached)
+
+var _ = AfterEach(func() {
+	if dumpOnFailure && context != nil {
+		testutil.DumpStateOnFailure(context.E2eTest, dumpOutputDir)
+	}
+})
+
+var _ = Describe("Upgrading a cluster", func() {
+	It("should be on a version other than the target version", func() {
+		version, err := provision.GetClusterKubernetesVersion(context.ContainershipClientset,
+			context.OrganizationID, context.ClusterID)
+		Expect(err).NotTo(HaveOccurred(), "could not determine current cluster version")
+		context.initialKubernetesVersion = version
+
+		skipIfAlreadyAtTarget()
+	})
+
+	It("should successfully upgrade every node pool", func() {
+		skipIfAlreadyAtTarget()
+
+		pools, err := context.ContainershipClientset.Provision().
+			NodePools(context.OrganizationID, context.ClusterID).
+			List()
+		Expect(err).NotTo(HaveOccurred())
+
+		// The control plane must be upgraded before the workers
+		var masters, workers []string
+		for _, pool := range pools {
+			if *pool.KubernetesMode == "master" {
+				masters = append(masters, string(pool.ID))
+			} else {
+				workers = append(workers, string(pool.ID))
+			}
+		}
+
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		context.nodesBeforeUpgrade = util.NodeNameSet(nodeList.Items)
+
+		context.availability = make(map[string]Availability)
+		var sampled []string
+		for _, pool := range pools {
+			availability, err := NodePoolAvailability(pool)
+			Expect(err).NotTo(HaveOccurred())
+			if availability != nil {
+				context.availability[string(pool.ID)] = *availability
+				sampled = append(sampled, string(pool.ID))
+			}
+		}
+
+		// Sample for the whole upgrade, including waiting for every pool to
+		// settle below, since nodes are replaced gradually throughout
+		if len(sampled) > 0 {
+			sampler := StartReadyNodeSampler(context.KubernetesClientset, sampled, constants.AvailabilitySampleInterval)
+			defer func() {
+				context.lowestReadyNodes = sampler.Stop()
+			}()
+		}
+
+		for _, id := range append(masters, workers...) {
+			log.By(fmt.Sprintf("requesting upgrade of node pool %q", id))
+			upgradeType := "kubernetes"
+			req := types.NodePoolUpgradeRequest{
+				Type:          &upgradeType,
+				TargetVersion: &targetKubernetesVersion,
+			}
+
+			_, err := context.ContainershipClientset.Provision().
+				NodePools(context.OrganizationID, context.ClusterID).
+				Upgrade(id, &req)
+			Expect(err).NotTo(HaveOccurred())
+
+			log.By(fmt.Sprintf("waiting for node pool %q to go into UPDATING state", id))
+			Expect(scale.WaitForNodePoolUpdatingWithContext(ctx, context.ContainershipClientset,
+				context.OrganizationID, context.ClusterID, id, pollInterval, timeout)).
+				Should(Succeed())
+
+			log.By(fmt.Sprintf("waiting for node pool %q to return to RUNNING state", id))
+			Expect(metrics.Time("nodepool-upgrade", func() error {
+				return scale.WaitForNodePoolRunningWithContext(ctx, context.ContainershipClientset,
+					context.OrganizationID, context.ClusterID, id, pollInterval, timeout)
+			})).Should(Succeed())
+		}
+
+		log.By(fmt.Sprintf("waiting for every node pool to be RUNNING at version %s", targetKubernetesVersion))
+		Expect(WaitForAllPoolsAtVersionWithContext(ctx, context.ContainershipClientset,
+			context.OrganizationID, context.ClusterID, targetKubernetesVersion, pollInterval, timeout)).
+			Should(Succeed())
+	})
+
+	It("should keep each node pool with an update strategy at its minimum availability", func() {
+		if context.availability == nil {
+			Skip("no upgrade was requested")
+		}
+		if len(context.availability) == 0 {
+			Skip("no node pools have an update strategy configured")
+		}
+
+		for id, availability := range context.availability {
+			lowest, observed := context.lowestReadyNodes[id]
+			log.Info("lowest Ready node count during upgrade", "id", id, "lowest", lowest, "minimum", availability.MinReady())
+			Expect(CheckAvailability(id, availability, lowest, observed)).To(Succeed())
+		}
+	})
+
+	It("should return the cluster to RUNNING state", func() {
+		skipIfAlreadyAtTarget()
+
+		Expect(metrics.Time("cluster-upgrade", waitForClusterRunning)).Should(Succeed())
+	})
+
+	It("should have all Kubernetes nodes report the target kubelet version", func() {
+		Expect(waitForKubeletVersions(targetKubernetesVersion)).Should(Succeed())
+	})
+
+	It("should run the same container runtime, OS image, and kernel on every node after the upgrade", func() {
+		if !checkUniformRuntime {
+			Skip("-check-uniform-runtime is not set")
+		}
+
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(util.AssertUniformRuntime(nodeList.Items)).To(Succeed())
+	})
+
+	It("should have as many Kubernetes nodes as before the upgrade", func() {
+		if context.nodesBeforeUpgrade == nil {
+			Skip("no upgrade was requested")
+		}
+
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		after := util.NodeNameSet(nodeList.Items)
+		added, removed := util.DiffNodeSets(context.nodesBeforeUpgrade, after)
+		log.Info("nodes replaced by the upgrade", "added", added, "removed", removed)
+
+		Expect(after).To(HaveLen(len(context.nodesBeforeUpgrade)),
+			"nodes added: %v, removed: %v", added, removed)
+	})
+})
+
+// Each spec must skip itself because skipping one spec doesn't skip the
+// remaining specs
+func skipIfAlreadyAtTarget() {
+	if normalizeVersion(context.initialKubernetesVersion) == normalizeVersion(targetKubernetesVersion) {
+		Skip(fmt.Sprintf("cluster is already on target version %s", targetKubernetesVersion))
+	}
+}
+
+func waitForClusterRunning() error {
+	return util.WaitForStatusOfWithContext(ctx, "cluster", pollInterval, timeout,
+		func() (string, error) {
+			cluster, err := context.ContainershipClientset.Provision().
+				CKEClusters(context.OrganizationID).
+				Get(context.ClusterID)
+			if err != nil {
+				return "", errors.Wrap(err, "GETing cluster")
+			}
+
+			return *cluster.Status.Type, nil
+		},
+		"RUNNING", provision.UpgradeClusterInProgressStatuses...)
+}
+
+func waitForKubeletVersions(version string) error {
+	return util.PollImmediateWithContext(ctx, pollInterval, timeout, func() (bool, error) {
+		nodeList, err := context.KubernetesClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		if err != nil {
+			if util.IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "listing nodes")
+		}
+
+		for _, node := range nodeList.Items {
+			if !util.IsNodeReady(node) {
+				return false, nil
+			}
+
+			if normalizeVersion(node.Status.NodeInfo.KubeletVersion) != normalizeVersion(version) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+}
+
+// ensureCluster sets the cluster to run against, see testcontext.EnsureCluster.
+// A cluster provisioned here is registered to be torn down after the suite.
+func ensureCluster(e2eTest *testcontext.E2eTest) error {
+	if (templateFilename == "") != (clusterFilename == "") {
+		return errors.New("-template and -cluster must be specified together")
+	}
+
+	opts := testcontext.EnsureOptions{
+		ClusterID: clusterID,
+	}
+
+	// Only discover the cluster from KUBECONFIG if one wasn't given, since
+	// KUBECONFIG may well point at another cluster
+	if clusterID == "" && os.Getenv("KUBECONFIG") != "" {
+		kubeClientset, err := e2eTest.InitKubernetesClientset()
+		if err != nil {
+			return err
+		}
+
+		opts.KubernetesClientset = kubeClientset
+	}
+
+	if templateFilename != "" {
+		opts.Provision = func() (string, error) {
+			log.By("provisioning a cluster to run against")
+			result, err := provision.ProvisionClusterWithContext(ctx, e2eTest.ContainershipClientset,
+				e2eTest.OrganizationID, templateFilename, clusterFilename, provision.ProvisionOverrides{})
+			if result.ClusterID != "" || result.TemplateID != "" {
+				stopCleanupOnSignal = cleanup.HandleSignals()
+				cleanup.Register("deleting provisioned cluster "+result.ClusterID, func() error {
+					return provision.DeleteProvisionResult(e2eTest.ContainershipClientset, e2eTest.OrganizationID,
+						result, constants.ProvisionInitialPollInterval, constants.ProvisionTimeout)
+				})
+			}
+			if err != nil {
+				return result.ClusterID, err
+			}
+
+			err = provision.WaitForAllNodePoolsRunningWithContext(ctx, e2eTest.ContainershipClientset,
+				e2eTest.OrganizationID, result.ClusterID, pollInterval, constants.ProvisionTimeout)
+			return result.ClusterID, err
+		}
+	}
+
+	id, created, err := testcontext.EnsureCluster(e2eTest.ContainershipClientset, e2eTest.OrganizationID, opts)
+	if err != nil {
+		return err
+	}
+
+	e2eTest.ClusterID = id
+	if created {
+		log.Info("provisioned cluster", "id", id)
+	}
+
+	if opts.KubernetesClientset != nil && !created {
+		// Discovered from KUBECONFIG, so the clientset is already set
+		return nil
+	}
+
+	proxyBaseURL, err := constants.ProxyBaseURLForEnvironment(constants.Environment(environment))
+	if err != nil {
+		return err
+	}
+
+	e2eTest.KubernetesClientset, err = provision.NewKubernetesClientset(proxyBaseURL,
+		e2eTest.OrganizationID, id, e2eTest.AuthToken, nil)
+	return err
+}
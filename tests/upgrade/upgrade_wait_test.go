@@ -0,0 +1,43 @@
+package upgrade
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func TestWaitForAllPoolsAtVersion(t *testing.T) {
+	poll := time.Millisecond
+	timeout := 20 * time.Millisecond
+
+	cs := cloudfake.New()
+	cs.AddCluster("upgraded", "RUNNING")
+	cs.AddNodePool("upgraded", cloudfake.NodePool{ID: "master", KubernetesMode: "master", KubernetesVersion: "1.15.3", Statuses: []string{"RUNNING"}})
+	cs.AddNodePool("upgraded", cloudfake.NodePool{ID: "worker", KubernetesMode: "worker", KubernetesVersion: "1.15.3", Statuses: []string{"UPDATING", "RUNNING"}})
+
+	if err := WaitForAllPoolsAtVersion(cs, "org", "upgraded", "v1.15.3", poll, timeout); err != nil {
+		t.Errorf("expected all pools at version, got %v", err)
+	}
+
+	cs.AddCluster("partial", "RUNNING")
+	cs.AddNodePool("partial", cloudfake.NodePool{ID: "master", KubernetesMode: "master", KubernetesVersion: "1.15.3", Statuses: []string{"RUNNING"}})
+	cs.AddNodePool("partial", cloudfake.NodePool{ID: "worker", KubernetesMode: "worker", KubernetesVersion: "1.14.6", Statuses: []string{"RUNNING"}})
+
+	err := WaitForAllPoolsAtVersion(cs, "org", "partial", "1.15.3", poll, timeout)
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), "worker (1.14.6, RUNNING)") {
+		t.Errorf("expected timeout naming the lagging pool, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "master (") {
+		t.Errorf("expected upgraded pool to be left out, got %v", err)
+	}
+
+	cs.AddCluster("failed", "RUNNING")
+	cs.AddNodePool("failed", cloudfake.NodePool{ID: "worker", KubernetesMode: "worker", KubernetesVersion: "1.14.6", Statuses: []string{"FAILED"}})
+
+	err = WaitForAllPoolsAtVersion(cs, "org", "failed", "1.15.3", poll, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "unexpected state") {
+		t.Errorf("expected error for failed pool, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+// AgentNotFoundError is returned by GetContainershipAgentVersion when the
+// agent deployment doesn't exist, e.g. because the agent was never installed
+type AgentNotFoundError struct {
+	Namespace string
+	Name      string
+}
+
+func (e *AgentNotFoundError) Error() string {
+	return fmt.Sprintf("Containership agent deployment %s/%s not found", e.Namespace, e.Name)
+}
+
+// GetContainershipAgentVersion returns the version of the Containership agent
+// running in the cluster, i.e. the image tag of its deployment. An
+// *AgentNotFoundError is returned if the deployment doesn't exist.
+func GetContainershipAgentVersion(kubeClientset kubernetes.Interface) (string, error) {
+	deployment, err := kubeClientset.AppsV1().
+		Deployments(constants.AgentDeploymentNamespace).
+		Get(constants.AgentDeploymentName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return "", &AgentNotFoundError{
+			Namespace: constants.AgentDeploymentNamespace,
+			Name:      constants.AgentDeploymentName,
+		}
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "getting Containership agent deployment")
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return "", errors.New("Containership agent deployment has no containers")
+	}
+
+	// Prefer the container named after the deployment in case there are
+	// sidecars
+	image := containers[0].Image
+	for _, c := range containers {
+		if c.Name == constants.AgentDeploymentName {
+			image = c.Image
+			break
+		}
+	}
+
+	return imageTag(image)
+}
+
+// imageTag returns the tag of the given image reference. A port in the
+// registry host is not mistaken for a tag, and a digest is ignored. An error
+// is returned if there is no tag since the version can't be known.
+func imageTag(image string) (string, error) {
+	ref := image
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+
+	// The tag can only follow the last path component
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	if i < 0 || i == len(name)-1 {
+		return "", errors.Errorf("image %q has no tag", image)
+	}
+
+	return name[i+1:], nil
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{"containership/agent:v3.1.0", "v3.1.0", false},
+		{"agent:3.1", "3.1", false},
+		{"registry.example.com:5000/containership/agent:v3.1.0", "v3.1.0", false},
+		{"containership/agent:v3.1.0@sha256:0123456789abcdef", "v3.1.0", false},
+		{"containership/agent", "", true},
+		{"registry.example.com:5000/containership/agent", "", true},
+		{"containership/agent@sha256:0123456789abcdef", "", true},
+		{"containership/agent:", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := imageTag(test.image)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.image, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: expected tag %q, got %q", test.image, test.want, got)
+		}
+	}
+}
+
+func TestGetContainershipAgentVersion(t *testing.T) {
+	_, err := GetContainershipAgentVersion(fake.NewSimpleClientset())
+	if _, ok := err.(*AgentNotFoundError); !ok {
+		t.Errorf("expected *AgentNotFoundError for missing deployment, got %v", err)
+	}
+
+	kube := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: constants.AgentDeploymentNamespace,
+			Name:      constants.AgentDeploymentName,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "proxy", Image: "envoy:1.11"},
+						{Name: constants.AgentDeploymentName, Image: "containership/agent:v3.1.0"},
+					},
+				},
+			},
+		},
+	})
+
+	version, err := GetContainershipAgentVersion(kube)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "v3.1.0" {
+		t.Errorf("expected version %q, got %q", "v3.1.0", version)
+	}
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// httpStatusCoder is implemented by errors returned from the Containership
+// cloud client that carry the HTTP status code of the failed request
+type httpStatusCoder interface {
+	Code() int
+}
+
+// cloudErrorCode returns the HTTP status code of a Containership cloud client
+// error and true, or 0 and false if the error doesn't carry a status code
+func cloudErrorCode(err error) (int, bool) {
+	coder, ok := errors.Cause(err).(httpStatusCoder)
+	if !ok {
+		return 0, false
+	}
+
+	return coder.Code(), true
+}
+
+// IsCloudNotFoundError returns true if the error is a Containership cloud
+// client error indicating that the requested resource does not exist
+func IsCloudNotFoundError(err error) bool {
+	code, ok := cloudErrorCode(err)
+	return ok && code == http.StatusNotFound
+}
+
+// IsCloudRejectedError returns true if the error is a Containership cloud
+// client error indicating that the request was understood but refused, i.e. a
+// 4xx response other than 404
+func IsCloudRejectedError(err error) bool {
+	code, ok := cloudErrorCode(err)
+	return ok && code >= http.StatusBadRequest &&
+		code < http.StatusInternalServerError &&
+		code != http.StatusNotFound
+}
+
+// IsRetryableCloudError returns true if the error is a Containership cloud
+// client error that may be transient, i.e. a 5xx response, a request that
+// exceeded its deadline, or a connection reset. It is the cloud counterpart of
+// IsRetryableAPIError.
+func IsRetryableCloudError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if code, ok := cloudErrorCode(err); ok {
+		return code >= http.StatusInternalServerError
+	}
+
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		if urlErr.Err == context.DeadlineExceeded {
+			return true
+		}
+	}
+
+	return cause == context.DeadlineExceeded || utilnet.IsConnectionReset(cause)
+}
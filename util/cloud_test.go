@@ -0,0 +1,78 @@
+package util
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type statusCodeError int
+
+func (e statusCodeError) Error() string {
+	return http.StatusText(int(e))
+}
+
+func (e statusCodeError) Code() int {
+	return int(e)
+}
+
+func TestIsRetryableCloudError(t *testing.T) {
+	connReset := &net.OpError{
+		Op:  "read",
+		Net: "tcp",
+		Err: os.NewSyscallError("read", syscall.ECONNRESET),
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"nil", nil, false},
+		{"500", statusCodeError(http.StatusInternalServerError), true},
+		{"502 wrapped", errors.Wrap(statusCodeError(http.StatusBadGateway), "GETing cluster"), true},
+		{"503", statusCodeError(http.StatusServiceUnavailable), true},
+		{"404", statusCodeError(http.StatusNotFound), false},
+		{"401", statusCodeError(http.StatusUnauthorized), false},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"deadline exceeded in url error", &url.Error{Op: "Get", URL: "https://example.com", Err: context.DeadlineExceeded}, true},
+		{"connection reset", connReset, true},
+		{"connection reset in url error", errors.Wrap(&url.Error{Op: "Get", URL: "https://example.com", Err: connReset}, "GETing node pools"), true},
+		{"canceled", context.Canceled, false},
+		{"plain error", errors.New("malformed response"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsRetryableCloudError(test.err); got != test.retryable {
+			t.Errorf("%s: expected retryable %t, got %t", test.name, test.retryable, got)
+		}
+	}
+}
+
+func TestIsCloudRejectedError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		rejected bool
+	}{
+		{"nil", nil, false},
+		{"400", statusCodeError(http.StatusBadRequest), true},
+		{"409 wrapped", errors.Wrap(statusCodeError(http.StatusConflict), "deleting node pool"), true},
+		{"422", statusCodeError(http.StatusUnprocessableEntity), true},
+		{"404", statusCodeError(http.StatusNotFound), false},
+		{"500", statusCodeError(http.StatusInternalServerError), false},
+		{"plain error", errors.New("malformed response"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsCloudRejectedError(test.err); got != test.rejected {
+			t.Errorf("%s: expected rejected %t, got %t", test.name, test.rejected, got)
+		}
+	}
+}
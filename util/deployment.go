@@ -0,0 +1,86 @@
+package util
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForDeploymentAvailable polls the given deployment until all of its
+// replicas are available and its Available condition is true, or the timeout
+// expires. Status the controller computed for an older generation of the
+// deployment is ignored. On timeout, the error includes the last observed
+// replica counts.
+func WaitForDeploymentAvailable(kubeClientset kubernetes.Interface, namespace, name string, poll, timeout time.Duration) error {
+	return WaitForDeploymentAvailableWithContext(context.Background(), kubeClientset, namespace, name, poll, timeout)
+}
+
+// WaitForDeploymentAvailableWithContext is the same as
+// WaitForDeploymentAvailable but stops waiting if ctx is done, in which case
+// ctx.Err() is returned
+func WaitForDeploymentAvailableWithContext(ctx context.Context, kubeClientset kubernetes.Interface, namespace, name string, poll, timeout time.Duration) error {
+	var last *appsv1.Deployment
+	start := time.Now()
+
+	err := PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		deployment, err := kubeClientset.AppsV1().
+			Deployments(namespace).
+			Get(name, metav1.GetOptions{})
+		if err != nil {
+			if IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "getting deployment %s/%s", namespace, name)
+		}
+
+		last = deployment
+		return IsDeploymentAvailable(*deployment), nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		waited := time.Since(start).Round(time.Second)
+		if last == nil {
+			return errors.Errorf("timed out after %s waiting for deployment %s/%s to be available; deployment never observed",
+				waited, namespace, name)
+		}
+
+		return errors.Errorf("timed out after %s waiting for deployment %s/%s to be available; last observed %d of %d replicas available",
+			waited, namespace, name, last.Status.AvailableReplicas, last.Status.Replicas)
+	}
+
+	return err
+}
+
+// IsDeploymentAvailable returns true if the deployment's status is up to
+// date, it has the desired number of replicas, all of them are available,
+// and its Available condition is true
+func IsDeploymentAvailable(deployment appsv1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+
+	if deployment.Status.Replicas != desired || deployment.Status.AvailableReplicas != deployment.Status.Replicas {
+		return false
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
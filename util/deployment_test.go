@@ -0,0 +1,98 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func deployment(replicas, available int32, availableCondition corev1.ConditionStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "workload",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          replicas,
+			AvailableReplicas: available,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: availableCondition},
+			},
+		},
+	}
+}
+
+func TestWaitForDeploymentAvailable(t *testing.T) {
+	poll := time.Millisecond
+	timeout := time.Second
+
+	// The deployment becomes available on the third poll
+	kube := fake.NewSimpleClientset()
+	gets := 0
+	kube.PrependReactor("get", "deployments", func(action ktesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets < 3 {
+			return true, deployment(3, 1, corev1.ConditionFalse), nil
+		}
+
+		return true, deployment(3, 3, corev1.ConditionTrue), nil
+	})
+
+	if err := WaitForDeploymentAvailable(kube, "default", "workload", poll, timeout); err != nil {
+		t.Fatalf("expected deployment to become available, got error: %v", err)
+	}
+	if gets != 3 {
+		t.Errorf("expected 3 polls, got %d", gets)
+	}
+
+	stuck := fake.NewSimpleClientset(deployment(3, 2, corev1.ConditionTrue))
+	err := WaitForDeploymentAvailable(stuck, "default", "workload", poll, 20*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if !strings.Contains(err.Error(), "2 of 3 replicas available") {
+		t.Errorf("expected error to include replica counts, got %v", err)
+	}
+
+	err = WaitForDeploymentAvailable(fake.NewSimpleClientset(), "default", "missing", poll, 20*time.Millisecond)
+	if err == nil {
+		t.Error("expected error for missing deployment")
+	}
+}
+
+func TestIsDeploymentAvailable(t *testing.T) {
+	stale := deployment(2, 2, corev1.ConditionTrue)
+	stale.Generation = 2
+	stale.Status.ObservedGeneration = 1
+
+	noCondition := deployment(2, 2, corev1.ConditionTrue)
+	noCondition.Status.Conditions = nil
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		want       bool
+	}{
+		{"available", deployment(2, 2, corev1.ConditionTrue), true},
+		{"replicas unavailable", deployment(2, 1, corev1.ConditionTrue), false},
+		{"condition false", deployment(2, 2, corev1.ConditionFalse), false},
+		{"stale status", stale, false},
+		{"no condition", noCondition, false},
+	}
+
+	for _, test := range tests {
+		if got := IsDeploymentAvailable(*test.deployment); got != test.want {
+			t.Errorf("%s: expected %t, got %t", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CoreDNSDeploymentName is the name of the CoreDNS deployment in
+	// kube-system
+	CoreDNSDeploymentName = "coredns"
+
+	// DNSCheckImage is the image used to run nslookup. Later busybox
+	// releases ship an nslookup that doesn't work with search domains.
+	DNSCheckImage = "busybox:1.28"
+
+	// dnsCheckName is the name that must resolve from inside the cluster
+	dnsCheckName = "kubernetes.default"
+)
+
+// IsCoreDNSDeployed returns true if the CoreDNS deployment exists in
+// kube-system
+func IsCoreDNSDeployed(kubeClientset kubernetes.Interface) (bool, error) {
+	_, err := kubeClientset.AppsV1().
+		Deployments(metav1.NamespaceSystem).
+		Get(CoreDNSDeploymentName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "getting CoreDNS deployment")
+	}
+
+	return true, nil
+}
+
+// AssertDNSResolves runs a short-lived pod in the default namespace that
+// looks up kubernetes.default and returns an error including the pod's logs if
+// the lookup fails or doesn't complete before the timeout. The pod is deleted
+// afterward. A reachable API server doesn't mean that in-cluster DNS works, so
+// this should be used before running anything that depends on it.
+func AssertDNSResolves(kubeClientset kubernetes.Interface, poll, timeout time.Duration) error {
+	return AssertDNSResolvesWithContext(context.Background(), kubeClientset, poll, timeout)
+}
+
+// AssertDNSResolvesWithContext is the same as AssertDNSResolves but stops
+// waiting if ctx is done, in which case ctx.Err() is returned
+func AssertDNSResolvesWithContext(ctx context.Context, kubeClientset kubernetes.Interface, poll, timeout time.Duration) error {
+	pods := kubeClientset.CoreV1().Pods(metav1.NamespaceDefault)
+
+	pod, err := pods.Create(newDNSCheckPod())
+	if err != nil {
+		return errors.Wrap(err, "creating DNS check pod")
+	}
+	// Don't wait for graceful termination since the pod has nothing to clean
+	// up and may still be running if the check timed out
+	defer pods.Delete(pod.Name, metav1.NewDeleteOptions(0))
+
+	var phase corev1.PodPhase
+	err = PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		p, err := pods.Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			if IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "getting DNS check pod %q", pod.Name)
+		}
+
+		phase = p.Status.Phase
+		return phase == corev1.PodSucceeded || phase == corev1.PodFailed, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out waiting for DNS check pod %q to complete; last observed phase %q",
+			pod.Name, phase)
+	}
+	if err != nil {
+		return err
+	}
+
+	if phase == corev1.PodFailed {
+		return errors.Errorf("failed to resolve %s from inside the cluster:\n%s",
+			dnsCheckName, dnsCheckPodLogs(kubeClientset, pod.Name))
+	}
+
+	return nil
+}
+
+func newDNSCheckPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "dns-check-",
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:    "nslookup",
+					Image:   DNSCheckImage,
+					Command: []string{"nslookup", dnsCheckName},
+				},
+			},
+		},
+	}
+}
+
+// dnsCheckPodLogs returns the logs of the DNS check pod, or a note if they
+// can't be fetched, since they're only used to explain a failure
+func dnsCheckPodLogs(kubeClientset kubernetes.Interface, name string) string {
+	data, err := kubeClientset.CoreV1().
+		Pods(metav1.NamespaceDefault).
+		GetLogs(name, &corev1.PodLogOptions{}).
+		Do().
+		Raw()
+	if err != nil {
+		return fmt.Sprintf("(failed to get logs: %v)", err)
+	}
+
+	return strings.TrimSpace(truncateLog(string(data), MaxPodLogBytes))
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestIsCoreDNSDeployed(t *testing.T) {
+	coreDNS := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceSystem,
+			Name:      CoreDNSDeploymentName,
+		},
+	}
+
+	deployed, err := IsCoreDNSDeployed(fake.NewSimpleClientset(coreDNS))
+	if err != nil || !deployed {
+		t.Errorf("expected CoreDNS to be deployed, got %t (error %v)", deployed, err)
+	}
+
+	deployed, err = IsCoreDNSDeployed(fake.NewSimpleClientset())
+	if err != nil || deployed {
+		t.Errorf("expected CoreDNS not to be deployed, got %t (error %v)", deployed, err)
+	}
+}
+
+// dnsCheckClientset returns a fake clientset whose DNS check pods always
+// report the given phase
+func dnsCheckClientset(phase corev1.PodPhase) *fake.Clientset {
+	kube := fake.NewSimpleClientset()
+
+	// The fake doesn't implement GenerateName
+	kube.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod := action.(ktesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Name = pod.GenerateName + "test"
+		return false, nil, nil
+	})
+
+	kube.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		// Fetching the logs of a failed check is also a get on pods, but
+		// with the log subresource and without a name
+		get, ok := action.(ktesting.GetAction)
+		if !ok || action.GetSubresource() == "log" {
+			return true, &corev1.Pod{}, nil
+		}
+
+		return true, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: get.GetName(),
+			},
+			Status: corev1.PodStatus{
+				Phase: phase,
+			},
+		}, nil
+	})
+
+	return kube
+}
+
+func TestAssertDNSResolves(t *testing.T) {
+	poll := time.Millisecond
+	timeout := 20 * time.Millisecond
+
+	tests := []struct {
+		phase   corev1.PodPhase
+		wantErr bool
+	}{
+		{corev1.PodSucceeded, false},
+		{corev1.PodFailed, true},
+		{corev1.PodRunning, true},
+	}
+
+	for _, test := range tests {
+		kube := dnsCheckClientset(test.phase)
+
+		err := AssertDNSResolves(kube, poll, timeout)
+		if test.wantErr && err == nil {
+			t.Errorf("%s: expected error", test.phase)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.phase, err)
+		}
+
+		podList, err := kube.CoreV1().Pods(metav1.NamespaceDefault).List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error listing pods: %v", err)
+		}
+		if len(podList.Items) != 0 {
+			t.Errorf("%s: expected DNS check pod to be deleted, found %d pods", test.phase, len(podList.Items))
+		}
+	}
+}
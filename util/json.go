@@ -0,0 +1,16 @@
+package util
+
+import (
+	"encoding/json"
+)
+
+// RoundTripJSON fills in to from the JSON form of from. It's how fields the
+// API reports but the generated cloud types lack are read.
+func RoundTripJSON(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, to)
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRoundTripJSON(t *testing.T) {
+	type source struct {
+		ID     string `json:"id"`
+		Nested struct {
+			Count int `json:"count"`
+		} `json:"nested"`
+	}
+
+	from := source{ID: "pool"}
+	from.Nested.Count = 3
+
+	var to struct {
+		Nested map[string]int `json:"nested"`
+	}
+	if err := RoundTripJSON(from, &to); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if to.Nested["count"] != 3 {
+		t.Errorf("expected nested count 3, got %v", to.Nested)
+	}
+
+	if err := RoundTripJSON(json.RawMessage(`not json`), &to); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
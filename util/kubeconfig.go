@@ -0,0 +1,54 @@
+package util
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// LoadRestConfigOnce builds a REST config from the kubeconfig at the given
+// path, failing immediately if it's missing or invalid
+func LoadRestConfigOnce(kubeconfigPath string) (*rest.Config, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "building REST config from %s", kubeconfigPath)
+	}
+
+	return cfg, nil
+}
+
+// LoadRestConfig is the same as LoadRestConfigOnce but retries until the
+// kubeconfig at the given path exists and is valid, since it may still be
+// being written by a provision step running in parallel. On timeout, the
+// error from the last attempt is returned.
+func LoadRestConfig(kubeconfigPath string, poll, timeout time.Duration) (*rest.Config, error) {
+	return LoadRestConfigWithContext(context.Background(), kubeconfigPath, poll, timeout)
+}
+
+// LoadRestConfigWithContext is the same as LoadRestConfig but stops retrying
+// if ctx is done, in which case ctx.Err() is returned
+func LoadRestConfigWithContext(ctx context.Context, kubeconfigPath string, poll, timeout time.Duration) (*rest.Config, error) {
+	var cfg *rest.Config
+	var lastErr error
+	start := time.Now()
+
+	err := PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		cfg, lastErr = LoadRestConfigOnce(kubeconfigPath)
+		return lastErr == nil, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return nil, errors.Wrapf(lastErr, "timed out after %s waiting for a valid kubeconfig",
+			time.Since(start).Round(time.Second))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
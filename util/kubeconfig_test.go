@@ -0,0 +1,73 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kube.example.com
+users:
+- name: test
+  user:
+    token: token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+
+func TestLoadRestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "kube.conf")
+
+	// A kubeconfig caught mid-write
+	if err := ioutil.WriteFile(filename, []byte(validKubeconfig[:60]), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadRestConfigOnce(filename); err == nil {
+		t.Fatal("expected error loading incomplete kubeconfig once")
+	}
+
+	_, err = LoadRestConfig(filename, time.Millisecond, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout for kubeconfig that never becomes valid, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		done <- ioutil.WriteFile(filename, []byte(validKubeconfig), 0600)
+	}()
+
+	cfg, err := LoadRestConfig(filename, time.Millisecond, 5*time.Second)
+	if writeErr := <-done; writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if err != nil {
+		t.Fatalf("expected kubeconfig to load once complete, got %v", err)
+	}
+	if cfg.Host != "https://kube.example.com" {
+		t.Errorf("expected host %q, got %q", "https://kube.example.com", cfg.Host)
+	}
+
+	if _, err := LoadRestConfig(filepath.Join(dir, "missing"), time.Millisecond, 20*time.Millisecond); err == nil {
+		t.Error("expected error for kubeconfig that never appears")
+	}
+}
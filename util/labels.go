@@ -0,0 +1,137 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+// DiffLabels compares actual labels against the expected labels and returns
+// one diff-style line per difference, sorted by key. Missing labels are
+// prefixed with "-", unexpected labels with "+", and labels with the wrong
+// value with "~". An empty result means the labels match exactly.
+func DiffLabels(expected, actual map[string]string) []string {
+	keys := make(map[string]struct{}, len(expected)+len(actual))
+	for k := range expected {
+		keys[k] = struct{}{}
+	}
+	for k := range actual {
+		keys[k] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diff []string
+	for _, k := range sorted {
+		want, expectedOK := expected[k]
+		got, actualOK := actual[k]
+
+		switch {
+		case !actualOK:
+			diff = append(diff, fmt.Sprintf("- %s=%s", k, want))
+		case !expectedOK:
+			diff = append(diff, fmt.Sprintf("+ %s=%s", k, got))
+		case want != got:
+			diff = append(diff, fmt.Sprintf("~ %s: expected %q, got %q", k, want, got))
+		}
+	}
+
+	return diff
+}
+
+// AssertNodesHaveLabel returns an error listing the nodes that don't carry the
+// given label with a non-empty value, or nil if they all do
+func AssertNodesHaveLabel(nodes []corev1.Node, key string) error {
+	return assertNodesHaveValue(nodes, "label "+key, labelValue(key))
+}
+
+// AssertNodesHaveLabelValues is the same as AssertNodesHaveLabel but also
+// requires the value of the label on every node to be one of allowed. The
+// error lists each node with a missing or unexpected value.
+func AssertNodesHaveLabelValues(nodes []corev1.Node, key string, allowed []string) error {
+	return assertNodesHaveValueIn(nodes, "label "+key, labelValue(key), allowed)
+}
+
+// NodeZone returns the zone the given node is running in, or "" if it isn't
+// labeled with one. The GA zone label is only set by kubelets as of
+// Kubernetes 1.17, so the beta label is used for older versions.
+func NodeZone(node corev1.Node) string {
+	if zone := node.Labels[constants.ZoneLabelKey]; zone != "" {
+		return zone
+	}
+
+	return node.Labels[constants.BetaZoneLabelKey]
+}
+
+// zoneLabelDescription names the labels NodeZone reads in errors
+var zoneLabelDescription = fmt.Sprintf("zone label (%s or %s)", constants.ZoneLabelKey, constants.BetaZoneLabelKey)
+
+// AssertNodesHaveZone is the same as AssertNodesHaveLabel for the zone
+// label, see NodeZone
+func AssertNodesHaveZone(nodes []corev1.Node) error {
+	return assertNodesHaveValue(nodes, zoneLabelDescription, NodeZone)
+}
+
+// AssertNodesInZones is the same as AssertNodesHaveLabelValues for the zone
+// label, see NodeZone
+func AssertNodesInZones(nodes []corev1.Node, allowed []string) error {
+	return assertNodesHaveValueIn(nodes, zoneLabelDescription, NodeZone, allowed)
+}
+
+func labelValue(key string) func(corev1.Node) string {
+	return func(node corev1.Node) string {
+		return node.Labels[key]
+	}
+}
+
+func assertNodesHaveValue(nodes []corev1.Node, what string, value func(corev1.Node) string) error {
+	var missing []string
+	for _, node := range nodes {
+		if value(node) == "" {
+			missing = append(missing, node.Name)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sort.Strings(missing)
+	return errors.Errorf("nodes missing %s: %s", what, strings.Join(missing, ", "))
+}
+
+func assertNodesHaveValueIn(nodes []corev1.Node, what string, value func(corev1.Node) string, allowed []string) error {
+	if err := assertNodesHaveValue(nodes, what, value); err != nil {
+		return err
+	}
+
+	ok := make(map[string]struct{}, len(allowed))
+	for _, v := range allowed {
+		ok[v] = struct{}{}
+	}
+
+	var unexpected []string
+	for _, node := range nodes {
+		if _, found := ok[value(node)]; !found {
+			unexpected = append(unexpected, fmt.Sprintf("%s=%s", node.Name, value(node)))
+		}
+	}
+
+	if len(unexpected) == 0 {
+		return nil
+	}
+
+	sort.Strings(unexpected)
+	return errors.Errorf("nodes with %s not in [%s]: %s",
+		what, strings.Join(allowed, ", "), strings.Join(unexpected, ", "))
+}
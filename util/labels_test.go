@@ -0,0 +1,122 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func TestDiffLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]string
+		actual   map[string]string
+		diff     []string
+	}{
+		{
+			name:     "match",
+			expected: map[string]string{"a": "1", "b": "2"},
+			actual:   map[string]string{"b": "2", "a": "1"},
+			diff:     nil,
+		},
+		{
+			name:     "both empty",
+			expected: nil,
+			actual:   map[string]string{},
+			diff:     nil,
+		},
+		{
+			name:     "missing, unexpected, and mismatched",
+			expected: map[string]string{"a": "1", "b": "2", "c": "3"},
+			actual:   map[string]string{"b": "changed", "c": "3", "d": "4"},
+			diff: []string{
+				"- a=1",
+				`~ b: expected "2", got "changed"`,
+				"+ d=4",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := DiffLabels(test.expected, test.actual)
+		if !reflect.DeepEqual(got, test.diff) {
+			t.Errorf("%s: expected diff %q, got %q", test.name, test.diff, got)
+		}
+	}
+}
+
+func labeledNode(name string, labels map[string]string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestAssertNodesHaveLabel(t *testing.T) {
+	nodes := []corev1.Node{
+		labeledNode("a", map[string]string{"zone": "nyc1"}),
+		labeledNode("b", map[string]string{"zone": "nyc3"}),
+	}
+
+	if err := AssertNodesHaveLabel(nodes, "zone"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := AssertNodesHaveLabelValues(nodes, "zone", []string{"nyc1", "nyc3"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := AssertNodesHaveLabelValues(nodes, "zone", []string{"nyc1"})
+	if err == nil || !strings.Contains(err.Error(), "b=nyc3") {
+		t.Errorf("expected error naming the node in an unexpected zone, got %v", err)
+	}
+
+	nodes = append(nodes,
+		labeledNode("d", nil),
+		labeledNode("c", map[string]string{"zone": ""}))
+
+	err = AssertNodesHaveLabel(nodes, "zone")
+	if err == nil || !strings.Contains(err.Error(), "missing label zone: c, d") {
+		t.Errorf("expected error listing nodes missing the label, got %v", err)
+	}
+
+	err = AssertNodesHaveLabelValues(nodes, "zone", []string{"nyc1", "nyc3"})
+	if err == nil || !strings.Contains(err.Error(), "c, d") {
+		t.Errorf("expected error listing nodes missing the label, got %v", err)
+	}
+}
+
+func TestAssertNodesInZones(t *testing.T) {
+	nodes := []corev1.Node{
+		labeledNode("ga", map[string]string{constants.ZoneLabelKey: "nyc1"}),
+		labeledNode("beta", map[string]string{constants.BetaZoneLabelKey: "nyc3"}),
+		labeledNode("both", map[string]string{
+			constants.ZoneLabelKey:     "nyc1",
+			constants.BetaZoneLabelKey: "nyc3",
+		}),
+	}
+
+	if err := AssertNodesHaveZone(nodes); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := AssertNodesInZones(nodes, []string{"nyc1", "nyc3"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The GA label takes precedence
+	err := AssertNodesInZones(nodes, []string{"nyc3"})
+	if err == nil || !strings.Contains(err.Error(), "both=nyc1, ga=nyc1") {
+		t.Errorf("expected error naming the nodes in an unexpected zone, got %v", err)
+	}
+
+	err = AssertNodesHaveZone(append(nodes, labeledNode("none", nil)))
+	if err == nil || !strings.Contains(err.Error(), ": none") {
+		t.Errorf("expected error listing the node without a zone, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MaxPodLogBytes caps the logs collected per pod by CollectPodLogs so that a
+// chatty pod doesn't flood CI output. The end of the logs is kept since it's
+// usually what explains a failure.
+const MaxPodLogBytes = 64 * 1024
+
+// CollectPodLogs returns the logs of every container in each pod in namespace
+// matching labelSelector, keyed by pod name, for post-mortem debugging. If
+// sinceSeconds is positive, only logs at most that old are collected.
+// Collection is best effort: a container that hasn't started yet or whose
+// logs can't be fetched gets a note in place of its logs rather than failing
+// the whole collection.
+func CollectPodLogs(kubeClientset kubernetes.Interface, namespace, labelSelector string, sinceSeconds int64) (map[string]string, error) {
+	podList, err := kubeClientset.CoreV1().
+		Pods(namespace).
+		List(metav1.ListOptions{
+			LabelSelector: labelSelector,
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing pods in namespace %q", namespace)
+	}
+
+	logs := make(map[string]string, len(podList.Items))
+	for _, pod := range podList.Items {
+		var b strings.Builder
+		for _, container := range pod.Spec.Containers {
+			fmt.Fprintf(&b, "==> %s <==\n", container.Name)
+
+			if !isContainerStarted(pod, container.Name) {
+				b.WriteString("(container has not started yet)\n")
+				continue
+			}
+
+			opts := &corev1.PodLogOptions{
+				Container: container.Name,
+			}
+			if sinceSeconds > 0 {
+				opts.SinceSeconds = &sinceSeconds
+			}
+
+			data, err := kubeClientset.CoreV1().
+				Pods(namespace).
+				GetLogs(pod.Name, opts).
+				Do().
+				Raw()
+			if err != nil {
+				fmt.Fprintf(&b, "(failed to get logs: %v)\n", err)
+				continue
+			}
+
+			b.Write(data)
+		}
+
+		logs[pod.Name] = truncateLog(b.String(), MaxPodLogBytes)
+	}
+
+	return logs, nil
+}
+
+// isContainerStarted returns true if the named container in the pod is
+// running or has run, i.e. it may have logs
+func isContainerStarted(pod corev1.Pod, containerName string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName {
+			return status.State.Running != nil || status.State.Terminated != nil
+		}
+	}
+
+	return false
+}
+
+// truncateLog returns the last max bytes of s, noting if anything was cut
+func truncateLog(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	return fmt.Sprintf("(truncated %d bytes)\n%s", len(s)-max, s[len(s)-max:])
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollectPodLogsSkipsUnstartedContainers(t *testing.T) {
+	pendingPod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				Labels:    labels,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "app",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	kube := fake.NewSimpleClientset(
+		pendingPod("app-0", map[string]string{"app": "test"}),
+		pendingPod("other-0", map[string]string{"app": "other"}),
+	)
+
+	logs, err := CollectPodLogs(kube, "test", "app=test", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(logs) != 1 {
+		t.Fatalf("expected logs for 1 pod, got %d", len(logs))
+	}
+
+	podLogs, ok := logs["app-0"]
+	if !ok {
+		t.Fatal("expected logs keyed by pod name")
+	}
+
+	if !strings.Contains(podLogs, "app") || !strings.Contains(podLogs, "has not started") {
+		t.Errorf("expected note that the container hasn't started, got %q", podLogs)
+	}
+}
+
+func TestTruncateLog(t *testing.T) {
+	if got := truncateLog("short", 10); got != "short" {
+		t.Errorf("expected log under the limit to be unchanged, got %q", got)
+	}
+
+	got := truncateLog("0123456789", 4)
+	if !strings.HasSuffix(got, "6789") || !strings.Contains(got, "truncated 6 bytes") {
+		t.Errorf("expected the last 4 bytes with a truncation note, got %q", got)
+	}
+}
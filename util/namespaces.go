@@ -0,0 +1,67 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AssertNamespacesExist returns an error naming every one of the given
+// namespaces that doesn't exist in the cluster, sorted by name
+func AssertNamespacesExist(kubeClientset kubernetes.Interface, names []string) error {
+	namespaces, err := kubeClientset.CoreV1().
+		Namespaces().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing namespaces")
+	}
+
+	existing := make(map[string]bool, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		existing[ns.Name] = true
+	}
+
+	var missing []string
+	for _, name := range names {
+		if !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.Errorf("missing expected namespaces: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// DeleteNamespace deletes the given namespace and waits for it to be gone. A
+// namespace that doesn't exist is already deleted. It takes no context since
+// it's meant for teardown, which must run even if the suite was interrupted.
+func DeleteNamespace(kubeClientset kubernetes.Interface, name string, poll, timeout time.Duration) error {
+	err := kubeClientset.CoreV1().
+		Namespaces().
+		Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return errors.Wrapf(err, "deleting namespace %q", name)
+	}
+
+	return wait.PollImmediate(poll, timeout, func() (bool, error) {
+		_, err := kubeClientset.CoreV1().
+			Namespaces().
+			Get(name, metav1.GetOptions{})
+		if apierrs.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, nil
+	})
+}
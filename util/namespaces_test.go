@@ -0,0 +1,55 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAssertNamespacesExist(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "containership-core"}},
+	)
+
+	if err := AssertNamespacesExist(kube, []string{"kube-system", "containership-core"}); err != nil {
+		t.Errorf("expected existing namespaces to pass, got %v", err)
+	}
+
+	if err := AssertNamespacesExist(kube, nil); err != nil {
+		t.Errorf("expected no namespaces to pass, got %v", err)
+	}
+
+	err := AssertNamespacesExist(kube, []string{"kube-system", "metrics", "addons"})
+	if err == nil {
+		t.Fatal("expected error for missing namespaces")
+	}
+	if !strings.Contains(err.Error(), "addons, metrics") {
+		t.Errorf("expected error to name the missing namespaces, got %v", err)
+	}
+	if strings.Contains(err.Error(), "kube-system") {
+		t.Errorf("expected error to name only the missing namespaces, got %v", err)
+	}
+}
+
+func TestDeleteNamespace(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "cs-e2e-test"}},
+	)
+
+	if err := DeleteNamespace(kube, "cs-e2e-test", time.Millisecond, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := kube.CoreV1().Namespaces().Get("cs-e2e-test", metav1.GetOptions{}); err == nil {
+		t.Error("expected namespace to be deleted")
+	}
+
+	if err := DeleteNamespace(kube, "missing", time.Millisecond, time.Second); err != nil {
+		t.Errorf("expected missing namespace to count as deleted, got %v", err)
+	}
+}
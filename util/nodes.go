@@ -0,0 +1,38 @@
+package util
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeNameSet returns the set of names of the given nodes, suitable for
+// snapshotting the nodes before an operation to compare with DiffNodeSets
+func NodeNameSet(nodes []corev1.Node) map[string]struct{} {
+	set := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		set[node.Name] = struct{}{}
+	}
+
+	return set
+}
+
+// DiffNodeSets returns the sorted names of the nodes in after but not before
+// and of the nodes in before but not after
+func DiffNodeSets(before, after map[string]struct{}) (added, removed []string) {
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
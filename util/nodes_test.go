@@ -0,0 +1,56 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func namedNodes(names ...string) []corev1.Node {
+	nodes := make([]corev1.Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+
+	return nodes
+}
+
+func TestNodeNameSet(t *testing.T) {
+	got := NodeNameSet(namedNodes("a", "b", "a"))
+	want := map[string]struct{}{"a": {}, "b": {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if got := NodeNameSet(nil); got == nil || len(got) != 0 {
+		t.Errorf("expected empty set, got %v", got)
+	}
+}
+
+func TestDiffNodeSets(t *testing.T) {
+	tests := []struct {
+		name        string
+		before      []string
+		after       []string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{"unchanged", []string{"a", "b"}, []string{"b", "a"}, nil, nil},
+		{"scaled up", []string{"a"}, []string{"c", "a", "b"}, []string{"b", "c"}, nil},
+		{"scaled down", []string{"a", "b", "c"}, []string{"b"}, nil, []string{"a", "c"}},
+		{"replaced", []string{"a", "b"}, []string{"b", "d"}, []string{"d"}, []string{"a"}},
+		{"from nothing", nil, []string{"a"}, []string{"a"}, nil},
+	}
+
+	for _, test := range tests {
+		added, removed := DiffNodeSets(NodeNameSet(namedNodes(test.before...)), NodeNameSet(namedNodes(test.after...)))
+		if !reflect.DeepEqual(added, test.wantAdded) {
+			t.Errorf("%s: expected added %v, got %v", test.name, test.wantAdded, added)
+		}
+		if !reflect.DeepEqual(removed, test.wantRemoved) {
+			t.Errorf("%s: expected removed %v, got %v", test.name, test.wantRemoved, removed)
+		}
+	}
+}
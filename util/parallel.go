@@ -0,0 +1,58 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RunParallel runs each of the named checks concurrently and waits for all
+// of them to finish. If any checks fail, the returned error names each
+// failed check along with its error, sorted by name, so that it's clear
+// which check failed. The checks must be safe to run concurrently.
+func RunParallel(checks map[string]func() error) error {
+	return RunParallelLimit(0, checks)
+}
+
+// RunParallelLimit is the same as RunParallel but runs at most limit checks
+// at once. A limit less than 1 means no limit.
+func RunParallelLimit(limit int, checks map[string]func() error) error {
+	if limit < 1 {
+		limit = len(checks)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []string
+	)
+
+	sem := make(chan struct{}, limit)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := check(); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+
+				failures = append(failures, name+": "+err.Error())
+			}
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	sort.Strings(failures)
+	return errors.Errorf("%d of %d checks failed:\n%s", len(failures), len(checks), strings.Join(failures, "\n"))
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRunParallel(t *testing.T) {
+	// Each check waits for the others to start, so this only finishes if
+	// they're actually run concurrently
+	started := make(chan struct{}, 3)
+	waitForAll := func() {
+		started <- struct{}{}
+		for len(started) < cap(started) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	err := RunParallel(map[string]func() error{
+		"a": func() error { waitForAll(); return nil },
+		"b": func() error { waitForAll(); return errors.New("b failed") },
+		"c": func() error { waitForAll(); return errors.New("c failed") },
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	msg := err.Error()
+	for _, want := range []string{"2 of 3 checks failed", "b: b failed", "c: c failed"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected error to contain %q, got: %s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "a:") {
+		t.Errorf("expected passing check not to be reported, got: %s", msg)
+	}
+
+	if err := RunParallel(map[string]func() error{"ok": func() error { return nil }}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestRunParallelLimit(t *testing.T) {
+	const limit = 2
+
+	var (
+		mu      sync.Mutex
+		running int
+		peak    int
+	)
+
+	checks := make(map[string]func() error)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		checks[name] = func() error {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	if err := RunParallelLimit(limit, checks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if peak > limit {
+		t.Errorf("expected at most %d checks at once, got %d", limit, peak)
+	}
+	if peak < limit {
+		t.Errorf("expected checks to run concurrently up to the limit, got at most %d at once", peak)
+	}
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UnreachableError is returned by ProbeAPIServer when a TCP connection to the
+// host can't be made, e.g. because its name doesn't resolve or nothing is
+// listening. It means the host or network is misconfigured rather than that
+// the API isn't ready yet.
+type UnreachableError struct {
+	Host string
+	Err  error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%s is unreachable: %s", e.Host, e.Err)
+}
+
+// Timeout returns true if the probe only timed out, which may just mean that
+// the host is slow to respond rather than unreachable
+func (e *UnreachableError) Timeout() bool {
+	netErr, ok := e.Err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// ProbeAPIServer makes a TCP connection to host to check that it's reachable
+// at all. host may be a URL, in which case the port defaults to that of its
+// scheme, or a host with an optional port, which defaults to 443. An
+// *UnreachableError is returned if the connection can't be made within
+// timeout.
+func ProbeAPIServer(host string, timeout time.Duration) error {
+	addr, err := probeAddress(host)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return &UnreachableError{Host: addr, Err: err}
+	}
+
+	return conn.Close()
+}
+
+// probeAddress returns the host:port to dial for the given host or URL
+func probeAddress(host string) (string, error) {
+	port := "443"
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return "", errors.Wrapf(err, "parsing API server URL %q", host)
+		}
+
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		host = u.Host
+	}
+
+	if host == "" {
+		return "", errors.New("API server host is empty")
+	}
+
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host, nil
+	}
+
+	// Brackets are added back for IPv6 literals
+	return net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
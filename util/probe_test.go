@@ -0,0 +1,59 @@
+package util
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeAddress(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{"https://proxy.example.com/v3/organizations", "proxy.example.com:443", false},
+		{"http://proxy.example.com", "proxy.example.com:80", false},
+		{"https://proxy.example.com:8443", "proxy.example.com:8443", false},
+		{"proxy.example.com", "proxy.example.com:443", false},
+		{"proxy.example.com:8443", "proxy.example.com:8443", false},
+		{"[::1]", "[::1]:443", false},
+		{"", "", true},
+		{"https://", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := probeAddress(test.host)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: expected error %t, got %v", test.host, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: expected address %q, got %q", test.host, test.want, got)
+		}
+	}
+}
+
+func TestProbeAPIServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	if err := ProbeAPIServer("https://"+addr, time.Second); err != nil {
+		t.Errorf("expected listening host to be reachable, got %v", err)
+	}
+
+	// Nothing is listening once the listener is closed
+	listener.Close()
+
+	err = ProbeAPIServer(addr, time.Second)
+	unreachable, ok := err.(*UnreachableError)
+	if !ok {
+		t.Fatalf("expected *UnreachableError, got %v", err)
+	}
+	if unreachable.Timeout() {
+		t.Error("expected a refused connection not to be a timeout")
+	}
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForPVCBound polls the given PersistentVolumeClaim until it is bound to
+// a volume or the timeout expires. Polling stops immediately if the claim is
+// Lost, since it will never be bound again. On timeout, the error includes
+// the last observed phase.
+func WaitForPVCBound(kubeClientset kubernetes.Interface, namespace, name string, poll, timeout time.Duration) error {
+	return WaitForPVCBoundWithContext(context.Background(), kubeClientset, namespace, name, poll, timeout)
+}
+
+// WaitForPVCBoundWithContext is the same as WaitForPVCBound but stops
+// waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForPVCBoundWithContext(ctx context.Context, kubeClientset kubernetes.Interface, namespace, name string, poll, timeout time.Duration) error {
+	var last *corev1.PersistentVolumeClaim
+	start := time.Now()
+
+	err := PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		pvc, err := kubeClientset.CoreV1().
+			PersistentVolumeClaims(namespace).
+			Get(name, metav1.GetOptions{})
+		if err != nil {
+			if IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "getting PersistentVolumeClaim %s/%s", namespace, name)
+		}
+
+		last = pvc
+		switch pvc.Status.Phase {
+		case corev1.ClaimBound:
+			return true, nil
+		case corev1.ClaimLost:
+			return false, errors.Errorf("PersistentVolumeClaim %s/%s lost its volume %q", namespace, name, pvc.Spec.VolumeName)
+		default:
+			return false, nil
+		}
+	})
+
+	if err == wait.ErrWaitTimeout {
+		waited := time.Since(start).Round(time.Second)
+		if last == nil {
+			return errors.Errorf("timed out after %s waiting for PersistentVolumeClaim %s/%s to be bound; claim never observed",
+				waited, namespace, name)
+		}
+
+		return errors.Errorf("timed out after %s waiting for PersistentVolumeClaim %s/%s to be bound; last observed phase %q",
+			waited, namespace, name, last.Status.Phase)
+	}
+
+	return err
+}
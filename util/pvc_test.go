@@ -0,0 +1,74 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func pvc(phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "claim",
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: "pv",
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase: phase,
+		},
+	}
+}
+
+func TestWaitForPVCBound(t *testing.T) {
+	poll := time.Millisecond
+	timeout := time.Second
+
+	// The claim is bound on the third poll
+	kube := fake.NewSimpleClientset()
+	gets := 0
+	kube.PrependReactor("get", "persistentvolumeclaims", func(action ktesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets < 3 {
+			return true, pvc(corev1.ClaimPending), nil
+		}
+
+		return true, pvc(corev1.ClaimBound), nil
+	})
+
+	if err := WaitForPVCBound(kube, "default", "claim", poll, timeout); err != nil {
+		t.Fatalf("expected claim to be bound, got error: %v", err)
+	}
+	if gets != 3 {
+		t.Errorf("expected 3 polls, got %d", gets)
+	}
+
+	pending := fake.NewSimpleClientset(pvc(corev1.ClaimPending))
+	err := WaitForPVCBound(pending, "default", "claim", poll, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), `last observed phase "Pending"`) {
+		t.Errorf("expected timeout error including the phase, got %v", err)
+	}
+
+	// A lost claim fails immediately rather than waiting out the timeout
+	start := time.Now()
+	lost := fake.NewSimpleClientset(pvc(corev1.ClaimLost))
+	err = WaitForPVCBound(lost, "default", "claim", poll, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "lost its volume") {
+		t.Errorf("expected error for lost claim, got %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("expected lost claim to fail promptly, waited %s", waited)
+	}
+
+	err = WaitForPVCBound(fake.NewSimpleClientset(), "default", "missing", poll, 20*time.Millisecond)
+	if err == nil {
+		t.Error("expected error for missing claim")
+	}
+}
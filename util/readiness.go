@@ -0,0 +1,70 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeReadinessInfo describes why a node isn't Ready
+type NodeReadinessInfo struct {
+	Name string
+
+	// Condition is the condition that failed, i.e. Ready
+	Condition corev1.NodeConditionType
+
+	// Status is the status of Condition, or Unknown if the node doesn't
+	// report it at all, e.g. because the kubelet hasn't posted status yet
+	Status  corev1.ConditionStatus
+	Reason  string
+	Message string
+}
+
+func (i NodeReadinessInfo) String() string {
+	s := fmt.Sprintf("%s: %s=%s", i.Name, i.Condition, i.Status)
+	switch {
+	case i.Reason != "" && i.Message != "":
+		s += fmt.Sprintf(" (%s: %s)", i.Reason, i.Message)
+	case i.Reason != "":
+		s += fmt.Sprintf(" (%s)", i.Reason)
+	case i.Message != "":
+		s += fmt.Sprintf(" (%s)", i.Message)
+	}
+
+	return s
+}
+
+// UnreadyNodes returns the details of the Ready condition of each of the
+// given nodes that isn't Ready (see IsNodeReady), sorted by node name
+func UnreadyNodes(nodes []corev1.Node) []NodeReadinessInfo {
+	var unready []NodeReadinessInfo
+	for _, node := range nodes {
+		if IsNodeReady(node) {
+			continue
+		}
+
+		info := NodeReadinessInfo{
+			Name:      node.Name,
+			Condition: corev1.NodeReady,
+			Status:    corev1.ConditionUnknown,
+			Message:   "condition not reported",
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				info.Status = condition.Status
+				info.Reason = condition.Reason
+				info.Message = condition.Message
+				break
+			}
+		}
+
+		unready = append(unready, info)
+	}
+
+	sort.Slice(unready, func(i, j int) bool {
+		return unready[i].Name < unready[j].Name
+	})
+
+	return unready
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodeWithConditions(name string, conditions ...corev1.NodeCondition) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: conditions,
+		},
+	}
+}
+
+func TestUnreadyNodes(t *testing.T) {
+	nodes := []corev1.Node{
+		nodeWithConditions("ready",
+			corev1.NodeCondition{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+			corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue}),
+		nodeWithConditions("not-ready",
+			corev1.NodeCondition{
+				Type:    corev1.NodeReady,
+				Status:  corev1.ConditionFalse,
+				Reason:  "KubeletNotReady",
+				Message: "runtime network not ready: cni config uninitialized",
+			}),
+		nodeWithConditions("unknown",
+			corev1.NodeCondition{
+				Type:   corev1.NodeReady,
+				Status: corev1.ConditionUnknown,
+				Reason: "NodeStatusUnknown",
+			}),
+		nodeWithConditions("bootstrapping"),
+	}
+
+	got := UnreadyNodes(nodes)
+	want := []string{
+		"bootstrapping: Ready=Unknown (condition not reported)",
+		"not-ready: Ready=False (KubeletNotReady: runtime network not ready: cni config uninitialized)",
+		"unknown: Ready=Unknown (NodeStatusUnknown)",
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d unready nodes, got %v", len(want), got)
+	}
+
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("expected %q, got %q", want[i], got[i].String())
+		}
+	}
+
+	if got[1].Condition != corev1.NodeReady || got[1].Status != corev1.ConditionFalse {
+		t.Errorf("expected failed Ready condition, got %+v", got[1])
+	}
+
+	if unready := UnreadyNodes(nodes[:1]); len(unready) != 0 {
+		t.Errorf("expected no unready nodes, got %v", unready)
+	}
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattkelly/containership-test-v2-experiment/log"
+)
+
+// RetryOnTransient calls fn until it succeeds, returns an error that isn't
+// transient (see IsRetryableCloudError), or has been called attempts times.
+// The delay before each retry starts at backoff and doubles after every
+// attempt. Each retry is logged.
+func RetryOnTransient(attempts int, backoff time.Duration, fn func() error) error {
+	if attempts < 1 {
+		return errors.Errorf("attempts must be at least 1, got %d", attempts)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryableCloudError(err) {
+			return err
+		}
+
+		if attempt == attempts {
+			return errors.Wrapf(err, "giving up after %d attempts", attempts)
+		}
+
+		log.Error(err, "retrying after transient error",
+			"attempt", attempt, "attempts", attempts, "backoff", backoff)
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
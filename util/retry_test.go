@@ -0,0 +1,50 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryOnTransient(t *testing.T) {
+	transient := statusCodeError(502)
+	permanent := statusCodeError(400)
+
+	tests := []struct {
+		name      string
+		errs      []error
+		attempts  int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"succeeds first try", []error{nil}, 3, false, 1},
+		{"succeeds after transient errors", []error{transient, transient, nil}, 3, false, 3},
+		{"gives up after attempts", []error{transient, transient, transient, nil}, 3, true, 3},
+		{"permanent error is not retried", []error{permanent, nil}, 3, true, 1},
+		{"wrapped transient error is retried", []error{errors.Wrap(transient, "creating"), nil}, 3, false, 2},
+		{"non-cloud error is not retried", []error{errors.New("bad request"), nil}, 3, true, 1},
+	}
+
+	for _, test := range tests {
+		calls := 0
+		err := RetryOnTransient(test.attempts, 0, func() error {
+			err := test.errs[calls]
+			calls++
+			return err
+		})
+
+		if test.wantErr && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if calls != test.wantCalls {
+			t.Errorf("%s: expected %d calls, got %d", test.name, test.wantCalls, calls)
+		}
+	}
+
+	if err := RetryOnTransient(0, 0, func() error { return nil }); err == nil {
+		t.Error("expected error for zero attempts")
+	}
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeRuntime is the container runtime and OS a node reports running
+type NodeRuntime struct {
+	Name                    string
+	ContainerRuntimeVersion string
+	OSImage                 string
+	KernelVersion           string
+}
+
+// String describes the runtime, leaving out the node name so that nodes
+// running the same thing describe the same
+func (r NodeRuntime) String() string {
+	return fmt.Sprintf("%s, %s, kernel %s", r.ContainerRuntimeVersion, r.OSImage, r.KernelVersion)
+}
+
+// NodeRuntimeInfo returns the runtime reported by each of the given nodes,
+// sorted by node name
+func NodeRuntimeInfo(nodes []corev1.Node) []NodeRuntime {
+	runtimes := make([]NodeRuntime, 0, len(nodes))
+	for _, node := range nodes {
+		info := node.Status.NodeInfo
+		runtimes = append(runtimes, NodeRuntime{
+			Name:                    node.Name,
+			ContainerRuntimeVersion: info.ContainerRuntimeVersion,
+			OSImage:                 info.OSImage,
+			KernelVersion:           info.KernelVersion,
+		})
+	}
+
+	sort.Slice(runtimes, func(i, j int) bool {
+		return runtimes[i].Name < runtimes[j].Name
+	})
+
+	return runtimes
+}
+
+// AssertUniformRuntime returns an error if the given nodes don't all report
+// the same container runtime version, OS image, and kernel version, which can
+// indicate a partially applied upgrade. The error lists each distinct runtime
+// with the nodes running it.
+func AssertUniformRuntime(nodes []corev1.Node) error {
+	byRuntime := make(map[string][]string)
+	for _, runtime := range NodeRuntimeInfo(nodes) {
+		byRuntime[runtime.String()] = append(byRuntime[runtime.String()], runtime.Name)
+	}
+
+	if len(byRuntime) <= 1 {
+		return nil
+	}
+
+	groups := make([]string, 0, len(byRuntime))
+	for runtime, names := range byRuntime {
+		groups = append(groups, fmt.Sprintf("%s (%s)", runtime, strings.Join(names, ", ")))
+	}
+	sort.Strings(groups)
+
+	return errors.Errorf("nodes disagree on their runtime: %s", strings.Join(groups, "; "))
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodeWithRuntime(name, runtime, osImage, kernel string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{
+				ContainerRuntimeVersion: runtime,
+				OSImage:                 osImage,
+				KernelVersion:           kernel,
+			},
+		},
+	}
+}
+
+func TestNodeRuntimeInfo(t *testing.T) {
+	runtimes := NodeRuntimeInfo([]corev1.Node{
+		nodeWithRuntime("b", "docker://18.9.7", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"),
+		nodeWithRuntime("a", "containerd://1.2.6", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"),
+	})
+
+	want := []NodeRuntime{
+		{"a", "containerd://1.2.6", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"},
+		{"b", "docker://18.9.7", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"},
+	}
+	if len(runtimes) != len(want) {
+		t.Fatalf("expected %d runtimes, got %v", len(want), runtimes)
+	}
+	for i := range want {
+		if runtimes[i] != want[i] {
+			t.Errorf("expected runtime %v, got %v", want[i], runtimes[i])
+		}
+	}
+}
+
+func TestAssertUniformRuntime(t *testing.T) {
+	if err := AssertUniformRuntime(nil); err != nil {
+		t.Errorf("expected no nodes to be uniform, got %v", err)
+	}
+
+	uniform := []corev1.Node{
+		nodeWithRuntime("a", "docker://18.9.7", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"),
+		nodeWithRuntime("b", "docker://18.9.7", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"),
+	}
+	if err := AssertUniformRuntime(uniform); err != nil {
+		t.Errorf("expected uniform nodes to pass, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		node corev1.Node
+		want string
+	}{
+		{"runtime", nodeWithRuntime("c", "docker://19.3.1", "Ubuntu 18.04.3 LTS", "4.15.0-58-generic"), "docker://19.3.1, Ubuntu 18.04.3 LTS, kernel 4.15.0-58-generic (c)"},
+		{"OS image", nodeWithRuntime("c", "docker://18.9.7", "CentOS Linux 7 (Core)", "4.15.0-58-generic"), "docker://18.9.7, CentOS Linux 7 (Core), kernel 4.15.0-58-generic (c)"},
+		{"kernel", nodeWithRuntime("c", "docker://18.9.7", "Ubuntu 18.04.3 LTS", "4.15.0-60-generic"), "docker://18.9.7, Ubuntu 18.04.3 LTS, kernel 4.15.0-60-generic (c)"},
+	}
+
+	for _, test := range tests {
+		err := AssertUniformRuntime(append(uniform, test.node))
+		if err == nil {
+			t.Errorf("%s: expected error for differing node", test.name)
+			continue
+		}
+
+		for _, want := range []string{test.want, "4.15.0-58-generic (a, b)"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected error to contain %q, got %v", test.name, want, err)
+			}
+		}
+	}
+}
@@ -1,13 +1,22 @@
 package util
 
 import (
+	"context"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
 	"github.com/pkg/errors"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
 )
 
 // This function is borrowed from kubernetes/kubernetes/test/utils
@@ -28,8 +37,13 @@ func IsRetryableAPIError(err error) bool {
 }
 
 // IsAuthError returns true if the error is an authentication
-// or authorization error, else false
+// or authorization error from either the Kubernetes API or the Containership
+// cloud, else false
 func IsAuthError(err error) bool {
+	if code, ok := cloudErrorCode(err); ok {
+		return code == http.StatusUnauthorized || code == http.StatusForbidden
+	}
+
 	return apierrs.IsForbidden(err) || apierrs.IsUnauthorized(err)
 }
 
@@ -45,9 +59,150 @@ func IsNodeReady(node corev1.Node) bool {
 	return false
 }
 
-// GetClusterIDFromKubernetes gets the Containership cluster ID
-// from the cluster-id label
+// IsPodReady returns true if the given pod is Running with a Ready condition
+// or has run to completion, else false
+func IsPodReady(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded {
+		return true
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsNodeCordoned returns true if the given node has been marked unschedulable
+// (i.e. cordoned), else false.
+func IsNodeCordoned(node corev1.Node) bool {
+	return node.Spec.Unschedulable
+}
+
+// IsNodeSchedulable returns true if new pods may be scheduled onto the given
+// node, else false. A node is schedulable if it is Ready, not cordoned, and
+// has no NoSchedule or NoExecute taints.
+func IsNodeSchedulable(node corev1.Node) bool {
+	if IsNodeCordoned(node) || !IsNodeReady(node) {
+		return false
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ErrClusterIDNotFound is returned when the Containership cluster ID can't
+// be found in the Kubernetes cluster, e.g. because it is not a Containership
+// cluster
+var ErrClusterIDNotFound = errors.New("Containership cluster ID not found in Kubernetes cluster")
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsUUID returns true if the given string is a valid UUID, else false
+func IsUUID(s string) bool {
+	return uuidRegexp.MatchString(s)
+}
+
+// GetClusterIDFromKubernetes gets the Containership cluster ID from the
+// Containership agent configmap, falling back to the cluster-id node label if
+// the configmap doesn't exist. ErrClusterIDNotFound is returned if neither
+// source contains the ID.
 func GetClusterIDFromKubernetes(kubeClientset kubernetes.Interface) (string, error) {
+	clusterID, err := getClusterIDFromConfigMap(kubeClientset)
+	if err != nil && err != ErrClusterIDNotFound {
+		return "", err
+	}
+
+	if err == ErrClusterIDNotFound {
+		clusterID, err = getClusterIDFromNodeLabel(kubeClientset)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !IsUUID(clusterID) {
+		return "", errors.Errorf("discovered cluster ID %q is not a valid UUID", clusterID)
+	}
+
+	return clusterID, nil
+}
+
+// clusterIDs caches the cluster IDs found by WaitForClusterIDFromKubernetes,
+// keyed by Kubernetes clientset
+var clusterIDs sync.Map
+
+// WaitForClusterIDFromKubernetes is the same as GetClusterIDFromKubernetes
+// but retries until the ID is available, since it may not be populated for a
+// few seconds on a freshly provisioned cluster. The ID is cached per
+// clientset, so later calls return immediately. ErrClusterIDNotFound is only
+// returned once the timeout has elapsed.
+func WaitForClusterIDFromKubernetes(kubeClientset kubernetes.Interface, poll, timeout time.Duration) (string, error) {
+	return WaitForClusterIDFromKubernetesWithContext(context.Background(), kubeClientset, poll, timeout)
+}
+
+// WaitForClusterIDFromKubernetesWithContext is the same as
+// WaitForClusterIDFromKubernetes but stops waiting if ctx is done
+func WaitForClusterIDFromKubernetesWithContext(ctx context.Context, kubeClientset kubernetes.Interface, poll, timeout time.Duration) (string, error) {
+	if clusterID, ok := clusterIDs.Load(kubeClientset); ok {
+		return clusterID.(string), nil
+	}
+
+	var clusterID string
+	err := PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		var err error
+		clusterID, err = GetClusterIDFromKubernetes(kubeClientset)
+		switch {
+		case err == nil:
+			return true, nil
+		case err == ErrClusterIDNotFound || IsRetryableAPIError(errors.Cause(err)):
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		return "", ErrClusterIDNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	clusterIDs.Store(kubeClientset, clusterID)
+	return clusterID, nil
+}
+
+func getClusterIDFromConfigMap(kubeClientset kubernetes.Interface) (string, error) {
+	configMap, err := kubeClientset.CoreV1().
+		ConfigMaps(constants.ClusterIDConfigMapNamespace).
+		Get(constants.ClusterIDConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) || IsAuthError(err) {
+			return "", ErrClusterIDNotFound
+		}
+
+		return "", errors.Wrap(err, "getting configmap to get cluster ID")
+	}
+
+	clusterID, ok := configMap.Data[constants.ClusterIDConfigMapKey]
+	if !ok {
+		return "", ErrClusterIDNotFound
+	}
+
+	return clusterID, nil
+}
+
+func getClusterIDFromNodeLabel(kubeClientset kubernetes.Interface) (string, error) {
 	nodeList, err := kubeClientset.CoreV1().
 		Nodes().
 		List(metav1.ListOptions{})
@@ -55,12 +210,70 @@ func GetClusterIDFromKubernetes(kubeClientset kubernetes.Interface) (string, err
 		return "", errors.Wrap(err, "listing nodes to get cluster ID")
 	}
 
+	if len(nodeList.Items) == 0 {
+		return "", ErrClusterIDNotFound
+	}
+
 	// Any node will do
 	node := nodeList.Items[0]
-	clusterID, ok := node.Labels["containership.io/cluster-id"]
+	clusterID, ok := node.Labels[constants.ClusterIDLabelKey]
 	if !ok {
-		return "", errors.Errorf("node %q is missing cluster-id label", node.Name)
+		return "", ErrClusterIDNotFound
 	}
 
 	return clusterID, nil
 }
+
+// GetNodePoolIDForNode returns the ID of the Containership node pool the
+// given node belongs to, as identified by the "containership.io/node-pool-id"
+// node label (see constants.NodePoolIDLabelKey). An error is returned if the
+// node is missing the label, since it can't be attributed to a pool.
+func GetNodePoolIDForNode(node corev1.Node) (string, error) {
+	id, ok := node.Labels[constants.NodePoolIDLabelKey]
+	if !ok || id == "" {
+		return "", errors.Errorf("node %q is missing %s label", node.Name, constants.NodePoolIDLabelKey)
+	}
+
+	return id, nil
+}
+
+// FilterNodesByPool returns the nodes belonging to the given node pool.
+// Nodes that can't be attributed to any pool are excluded.
+func FilterNodesByPool(nodes []corev1.Node, nodePoolID string) []corev1.Node {
+	var filtered []corev1.Node
+	for _, node := range nodes {
+		id, err := GetNodePoolIDForNode(node)
+		if err == nil && id == nodePoolID {
+			filtered = append(filtered, node)
+		}
+	}
+
+	return filtered
+}
+
+// NodePoolNodeCount returns the number of Kubernetes nodes belonging to the
+// given node pool (see GetNodePoolIDForNode). An error is returned if any
+// node is missing the node pool ID label, since it can't be attributed to a
+// pool.
+func NodePoolNodeCount(kubeClientset kubernetes.Interface, nodePoolID string) (int, error) {
+	nodeList, err := kubeClientset.CoreV1().
+		Nodes().
+		List(metav1.ListOptions{})
+	if err != nil {
+		return 0, errors.Wrap(err, "listing nodes to count node pool nodes")
+	}
+
+	count := 0
+	for _, node := range nodeList.Items {
+		id, err := GetNodePoolIDForNode(node)
+		if err != nil {
+			return 0, err
+		}
+
+		if id == nodePoolID {
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,346 @@
+package util
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/constants"
+)
+
+func readyCondition(status corev1.ConditionStatus) corev1.NodeCondition {
+	return corev1.NodeCondition{
+		Type:   corev1.NodeReady,
+		Status: status,
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	nodesResource := corev1.Resource("nodes")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"kubernetes forbidden", apierrs.NewForbidden(nodesResource, "node", errors.New("denied")), true},
+		{"kubernetes unauthorized", apierrs.NewUnauthorized("expired"), true},
+		{"kubernetes not found", apierrs.NewNotFound(nodesResource, "node"), false},
+		{"cloud forbidden", statusCodeError(http.StatusForbidden), true},
+		{"wrapped cloud unauthorized", errors.Wrap(statusCodeError(http.StatusUnauthorized), "listing"), true},
+		{"cloud not found", statusCodeError(http.StatusNotFound), false},
+		{"plain error", errors.New("connection refused"), false},
+	}
+
+	for _, test := range tests {
+		if got := IsAuthError(test.err); got != test.want {
+			t.Errorf("%s: expected %t, got %t", test.name, test.want, got)
+		}
+	}
+}
+
+func TestNodeSchedulingHelpers(t *testing.T) {
+	tests := []struct {
+		name        string
+		node        corev1.Node
+		cordoned    bool
+		schedulable bool
+	}{
+		{
+			name: "ready and uncordoned",
+			node: corev1.Node{
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{readyCondition(corev1.ConditionTrue)},
+				},
+			},
+			cordoned:    false,
+			schedulable: true,
+		},
+		{
+			name: "ready and cordoned",
+			node: corev1.Node{
+				Spec: corev1.NodeSpec{Unschedulable: true},
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{readyCondition(corev1.ConditionTrue)},
+				},
+			},
+			cordoned:    true,
+			schedulable: false,
+		},
+		{
+			name: "not ready and uncordoned",
+			node: corev1.Node{
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{readyCondition(corev1.ConditionFalse)},
+				},
+			},
+			cordoned:    false,
+			schedulable: false,
+		},
+		{
+			name:        "no conditions",
+			node:        corev1.Node{},
+			cordoned:    false,
+			schedulable: false,
+		},
+		{
+			name: "ready with NoSchedule taint",
+			node: corev1.Node{
+				Spec: corev1.NodeSpec{
+					Taints: []corev1.Taint{
+						{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule},
+					},
+				},
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{readyCondition(corev1.ConditionTrue)},
+				},
+			},
+			cordoned:    false,
+			schedulable: false,
+		},
+		{
+			name: "ready with PreferNoSchedule taint",
+			node: corev1.Node{
+				Spec: corev1.NodeSpec{
+					Taints: []corev1.Taint{
+						{Key: "example.com/prefer", Effect: corev1.TaintEffectPreferNoSchedule},
+					},
+				},
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{readyCondition(corev1.ConditionTrue)},
+				},
+			},
+			cordoned:    false,
+			schedulable: true,
+		},
+	}
+
+	for _, test := range tests {
+		if got := IsNodeCordoned(test.node); got != test.cordoned {
+			t.Errorf("%s: IsNodeCordoned: expected %t, got %t", test.name, test.cordoned, got)
+		}
+
+		if got := IsNodeSchedulable(test.node); got != test.schedulable {
+			t.Errorf("%s: IsNodeSchedulable: expected %t, got %t", test.name, test.schedulable, got)
+		}
+	}
+}
+
+func poolNode(name, poolID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				constants.NodePoolIDLabelKey: poolID,
+			},
+		},
+	}
+}
+
+func TestNodePoolNodeCount(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		poolNode("a-0", "pool-a"),
+		poolNode("a-1", "pool-a"),
+		poolNode("b-0", "pool-b"),
+	)
+
+	for poolID, want := range map[string]int{
+		"pool-a":  2,
+		"pool-b":  1,
+		"missing": 0,
+	} {
+		got, err := NodePoolNodeCount(kube, poolID)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", poolID, err)
+			continue
+		}
+
+		if got != want {
+			t.Errorf("%s: expected %d nodes, got %d", poolID, want, got)
+		}
+	}
+
+	unlabeled := []runtime.Object{
+		poolNode("a-0", "pool-a"),
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	}
+
+	_, err := NodePoolNodeCount(fake.NewSimpleClientset(unlabeled...), "pool-a")
+	if err == nil {
+		t.Error("expected error for node missing node pool label")
+	}
+}
+
+func TestGetNodePoolIDForNode(t *testing.T) {
+	id, err := GetNodePoolIDForNode(*poolNode("a-0", "pool-a"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != "pool-a" {
+		t.Errorf("expected node pool ID %q, got %q", "pool-a", id)
+	}
+
+	if _, err := GetNodePoolIDForNode(corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}}); err == nil {
+		t.Error("expected error for node missing node pool label")
+	}
+}
+
+func TestFilterNodesByPool(t *testing.T) {
+	nodes := []corev1.Node{
+		*poolNode("a-0", "pool-a"),
+		*poolNode("b-0", "pool-b"),
+		{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+		*poolNode("a-1", "pool-a"),
+	}
+
+	for poolID, want := range map[string][]string{
+		"pool-a":  {"a-0", "a-1"},
+		"pool-b":  {"b-0"},
+		"missing": nil,
+	} {
+		var got []string
+		for _, node := range FilterNodesByPool(nodes, poolID) {
+			got = append(got, node.Name)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected nodes %v, got %v", poolID, want, got)
+		}
+	}
+}
+
+func TestGetClusterIDFromKubernetes(t *testing.T) {
+	const (
+		configMapID = "11111111-2222-3333-4444-555555555555"
+		labelID     = "66666666-7777-8888-9999-000000000000"
+	)
+
+	configMap := func(id string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: constants.ClusterIDConfigMapNamespace,
+				Name:      constants.ClusterIDConfigMapName,
+			},
+			Data: map[string]string{
+				constants.ClusterIDConfigMapKey: id,
+			},
+		}
+	}
+
+	labeledNode := func(id string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Labels: map[string]string{
+					constants.ClusterIDLabelKey: id,
+				},
+			},
+		}
+	}
+
+	unlabeledNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    string
+		wantErr error
+		// anyErr is true if any error is acceptable
+		anyErr bool
+	}{
+		{
+			name:    "configmap takes precedence",
+			objects: []runtime.Object{configMap(configMapID), labeledNode(labelID)},
+			want:    configMapID,
+		},
+		{
+			name:    "falls back to node label",
+			objects: []runtime.Object{labeledNode(labelID)},
+			want:    labelID,
+		},
+		{
+			name:    "not a Containership cluster",
+			objects: []runtime.Object{unlabeledNode},
+			wantErr: ErrClusterIDNotFound,
+		},
+		{
+			name:    "no nodes",
+			objects: nil,
+			wantErr: ErrClusterIDNotFound,
+		},
+		{
+			name:    "invalid UUID",
+			objects: []runtime.Object{configMap("not-a-uuid")},
+			anyErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := GetClusterIDFromKubernetes(fake.NewSimpleClientset(test.objects...))
+		switch {
+		case test.anyErr:
+			if err == nil {
+				t.Errorf("%s: expected error, got cluster ID %q", test.name, got)
+			}
+		case err != test.wantErr:
+			t.Errorf("%s: expected error %v, got %v", test.name, test.wantErr, err)
+		case got != test.want:
+			t.Errorf("%s: expected cluster ID %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestWaitForClusterIDFromKubernetes(t *testing.T) {
+	const clusterID = "11111111-2222-3333-4444-555555555555"
+
+	poll := time.Millisecond
+	timeout := time.Second
+
+	kube := fake.NewSimpleClientset()
+
+	// The configmap shows up after a few polls
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		kube.CoreV1().ConfigMaps(constants.ClusterIDConfigMapNamespace).Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: constants.ClusterIDConfigMapNamespace,
+				Name:      constants.ClusterIDConfigMapName,
+			},
+			Data: map[string]string{
+				constants.ClusterIDConfigMapKey: clusterID,
+			},
+		})
+	}()
+
+	got, err := WaitForClusterIDFromKubernetes(kube, poll, timeout)
+	if err != nil || got != clusterID {
+		t.Fatalf("expected cluster ID %q once populated, got %q, %v", clusterID, got, err)
+	}
+
+	// The ID is cached for the clientset
+	err = kube.CoreV1().ConfigMaps(constants.ClusterIDConfigMapNamespace).
+		Delete(constants.ClusterIDConfigMapName, &metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = WaitForClusterIDFromKubernetes(kube, poll, timeout)
+	if err != nil || got != clusterID {
+		t.Errorf("expected cached cluster ID %q, got %q, %v", clusterID, got, err)
+	}
+
+	_, err = WaitForClusterIDFromKubernetes(fake.NewSimpleClientset(), poll, 20*time.Millisecond)
+	if err != ErrClusterIDNotFound {
+		t.Errorf("expected %v after the timeout, got %v", ErrClusterIDNotFound, err)
+	}
+}
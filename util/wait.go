@@ -0,0 +1,423 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StatusTimeoutError is returned when a status wait times out. It records
+// the last status observed so that callers know where things got stuck.
+type StatusTimeoutError struct {
+	// Subject is a human-readable name of the thing being waited on, e.g.
+	// "cluster" or "node pool <id>". It may be empty.
+	Subject    string
+	Desired    string
+	LastStatus string
+	Waited     time.Duration
+}
+
+func (e *StatusTimeoutError) Error() string {
+	waitingFor := fmt.Sprintf("status %q", e.Desired)
+	if e.Subject != "" {
+		waitingFor = fmt.Sprintf("%s to be %s", e.Subject, e.Desired)
+	}
+
+	lastObserved := "no status observed"
+	if e.LastStatus != "" {
+		lastObserved = fmt.Sprintf("last observed status %q", e.LastStatus)
+	}
+
+	return fmt.Sprintf("timed out after %s waiting for %s; %s", e.Waited, waitingFor, lastObserved)
+}
+
+// UnexpectedStatusError is returned when a status wait observes a status
+// that is neither the desired status nor one of the allowed statuses, e.g. a
+// cluster entering ERROR while provisioning
+type UnexpectedStatusError struct {
+	// Subject is a human-readable name of the thing being waited on. It may
+	// be empty.
+	Subject string
+	Desired string
+	Status  string
+}
+
+func (e *UnexpectedStatusError) Error() string {
+	if e.Subject != "" {
+		return fmt.Sprintf("%s entered unexpected status %q while waiting for %q", e.Subject, e.Status, e.Desired)
+	}
+
+	return fmt.Sprintf("entered unexpected status %q while waiting for %q", e.Status, e.Desired)
+}
+
+// ValidatePollOptions returns a descriptive error if the given poll interval
+// or timeout are not positive, since a zero value would otherwise result in
+// a wait that spins or gives up immediately
+func ValidatePollOptions(poll, timeout time.Duration) error {
+	if poll <= 0 {
+		return errors.Errorf("poll interval must be positive, got %s", poll)
+	}
+
+	if timeout <= 0 {
+		return errors.Errorf("timeout must be positive, got %s", timeout)
+	}
+
+	return nil
+}
+
+// WaitForStatus polls get every poll interval until it returns the desired
+// status or the timeout expires. While the observed status is one of the
+// allowed statuses, polling continues; any other status is an error.
+// Transient errors from get (see IsRetryableCloudError) are retried.
+func WaitForStatus(poll, timeout time.Duration, get func() (string, error), desired string, allowed ...string) error {
+	return WaitForStatusWithContext(context.Background(), poll, timeout, get, desired, allowed...)
+}
+
+// WaitForStatusWithContext is the same as WaitForStatus but stops waiting
+// if ctx is done
+func WaitForStatusWithContext(ctx context.Context, poll, timeout time.Duration, get func() (string, error), desired string, allowed ...string) error {
+	return WaitForStatusOfWithContext(ctx, "", poll, timeout, get, desired, allowed...)
+}
+
+// WaitForStatusOf is the same as WaitForStatus but names the subject being
+// waited on in any returned error. On timeout, a *StatusTimeoutError is
+// returned, and on an unexpected status, an *UnexpectedStatusError.
+func WaitForStatusOf(subject string, poll, timeout time.Duration, get func() (string, error), desired string, allowed ...string) error {
+	return WaitForStatusOfWithContext(context.Background(), subject, poll, timeout, get, desired, allowed...)
+}
+
+// WaitForStatusOfWithContext is the same as WaitForStatusOf but stops
+// waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForStatusOfWithContext(ctx context.Context, subject string, poll, timeout time.Duration, get func() (string, error), desired string, allowed ...string) error {
+	return WaitForStatusWithPoller(subject, func(condition wait.ConditionFunc) error {
+		return PollImmediateWithContext(ctx, poll, timeout, condition)
+	}, get, desired, allowed...)
+}
+
+// Poller runs condition until it returns true or an error, returning
+// wait.ErrWaitTimeout if it gives up waiting
+type Poller func(condition wait.ConditionFunc) error
+
+// WaitForStatusWithPoller is the same as WaitForStatusOf but uses the given
+// poller to determine when to check the status and when to give up
+func WaitForStatusWithPoller(subject string, poller Poller, get func() (string, error), desired string, allowed ...string) error {
+	var lastStatus string
+	start := time.Now()
+
+	err := poller(func() (bool, error) {
+		status, err := get()
+		if err != nil {
+			if IsRetryableCloudError(err) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		lastStatus = status
+		if status == desired {
+			return true, nil
+		}
+
+		for _, s := range allowed {
+			if status == s {
+				return false, nil
+			}
+		}
+
+		return false, &UnexpectedStatusError{
+			Subject: subject,
+			Desired: desired,
+			Status:  status,
+		}
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return &StatusTimeoutError{
+			Subject:    subject,
+			Desired:    desired,
+			LastStatus: lastStatus,
+			Waited:     time.Since(start).Round(time.Second),
+		}
+	}
+
+	return err
+}
+
+// PollWithBackoff runs condition immediately and then repeatedly until it
+// returns true or an error, or the timeout expires. The interval between
+// attempts starts at initial and doubles after each attempt up to max. This
+// is useful for long waits where we want to be responsive early on without
+// hammering the API for the entire duration. wait.ErrWaitTimeout is returned
+// on timeout.
+func PollWithBackoff(initial, max, timeout time.Duration, condition wait.ConditionFunc) error {
+	return PollWithBackoffWithContext(context.Background(), initial, max, timeout, condition)
+}
+
+// PollWithBackoffWithContext is the same as PollWithBackoff but stops
+// polling as soon as ctx is done, in which case ctx.Err() is returned
+func PollWithBackoffWithContext(ctx context.Context, initial, max, timeout time.Duration, condition wait.ConditionFunc) error {
+	interval := initial
+	next := func() time.Duration {
+		sleep := interval
+
+		interval *= 2
+		if interval > max {
+			interval = max
+		}
+
+		return sleep
+	}
+
+	return pollWithIntervals(ctx, timeout, next, condition)
+}
+
+// pollWithIntervals runs condition immediately and then after each interval
+// returned by next until it returns true or an error, the timeout expires,
+// or ctx is done
+func pollWithIntervals(ctx context.Context, timeout time.Duration, next func() time.Duration, condition wait.ConditionFunc) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return wait.ErrWaitTimeout
+		}
+
+		sleep := next()
+		if sleep > remaining {
+			sleep = remaining
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// JitterFactor is the largest fraction of the poll interval that
+// PollWithJitter offsets each interval by, in either direction
+const JitterFactor = 0.2
+
+// PollWithJitter is the same as wait.PollImmediate but offsets each interval
+// by a random amount of up to JitterFactor of the poll interval. When suites
+// run on parallel ginkgo nodes this keeps them from polling the cloud API in
+// synchronized bursts.
+func PollWithJitter(poll, timeout time.Duration, condition wait.ConditionFunc) error {
+	return PollWithJitterWithContext(context.Background(), poll, timeout, condition)
+}
+
+// PollWithJitterWithContext is the same as PollWithJitter but stops polling
+// as soon as ctx is done, in which case ctx.Err() is returned
+func PollWithJitterWithContext(ctx context.Context, poll, timeout time.Duration, condition wait.ConditionFunc) error {
+	return pollWithJitter(ctx, poll, timeout, jitterRandom, condition)
+}
+
+// pollWithJitter is PollWithJitterWithContext with the random source, which
+// must return values in [0, 1), injected
+func pollWithJitter(ctx context.Context, poll, timeout time.Duration, random func() float64, condition wait.ConditionFunc) error {
+	return pollWithIntervals(ctx, timeout, func() time.Duration {
+		return jitteredInterval(poll, random())
+	}, condition)
+}
+
+// jitteredInterval maps r in [0, 1) to an interval within JitterFactor of
+// poll
+func jitteredInterval(poll time.Duration, r float64) time.Duration {
+	return poll + time.Duration((2*r-1)*JitterFactor*float64(poll))
+}
+
+// JitterPollerWithContext returns a Poller that uses
+// PollWithJitterWithContext
+func JitterPollerWithContext(ctx context.Context, poll, timeout time.Duration) Poller {
+	return func(condition wait.ConditionFunc) error {
+		return PollWithJitterWithContext(ctx, poll, timeout, condition)
+	}
+}
+
+// The default math/rand source is seeded identically in every process, which
+// would give every parallel ginkgo node the same jitter, so a separately
+// seeded source is used. It's not safe for concurrent use on its own.
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano() + int64(os.Getpid())))
+)
+
+func jitterRandom() float64 {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return jitterRand.Float64()
+}
+
+// PollImmediateWithContext runs condition immediately and then every poll
+// interval until it returns true or an error, the timeout expires, or ctx is
+// done. It is the context-aware equivalent of wait.PollImmediate:
+// wait.ErrWaitTimeout is returned on timeout and ctx.Err() if ctx is done.
+func PollImmediateWithContext(ctx context.Context, poll, timeout time.Duration, condition wait.ConditionFunc) error {
+	// A backoff that never grows is a fixed interval
+	return PollWithBackoffWithContext(ctx, poll, poll, timeout, condition)
+}
+
+// BackoffPoller returns a Poller that uses PollWithBackoff
+func BackoffPoller(initial, max, timeout time.Duration) Poller {
+	return BackoffPollerWithContext(context.Background(), initial, max, timeout)
+}
+
+// BackoffPollerWithContext returns a Poller that uses
+// PollWithBackoffWithContext
+func BackoffPollerWithContext(ctx context.Context, initial, max, timeout time.Duration) Poller {
+	return func(condition wait.ConditionFunc) error {
+		return PollWithBackoffWithContext(ctx, initial, max, timeout, condition)
+	}
+}
+
+// WaitForNodePoolNodeRemoved polls the Kubernetes node list until the given
+// node pool has exactly one node fewer than before and one of the nodes in
+// before, a snapshot of the pool's node names taken before scaling it down
+// (see NodeNameSet), is gone. The name of the removed node is returned. On
+// timeout, the error lists the pool's nodes that were still present.
+func WaitForNodePoolNodeRemoved(kubeClientset kubernetes.Interface, nodePoolID string, before map[string]struct{}, poll, timeout time.Duration) (string, error) {
+	return WaitForNodePoolNodeRemovedWithContext(context.Background(), kubeClientset, nodePoolID, before, poll, timeout)
+}
+
+// WaitForNodePoolNodeRemovedWithContext is the same as
+// WaitForNodePoolNodeRemoved but stops waiting if ctx is done, in which case
+// ctx.Err() is returned
+func WaitForNodePoolNodeRemovedWithContext(ctx context.Context, kubeClientset kubernetes.Interface, nodePoolID string, before map[string]struct{}, poll, timeout time.Duration) (string, error) {
+	if len(before) == 0 {
+		return "", errors.Errorf("node pool %q had no nodes to remove", nodePoolID)
+	}
+
+	var present []string
+	var removed string
+	start := time.Now()
+
+	err := PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		nodeList, err := kubeClientset.CoreV1().
+			Nodes().
+			List(metav1.ListOptions{})
+		if err != nil {
+			if IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "listing nodes")
+		}
+
+		nodes := FilterNodesByPool(nodeList.Items, nodePoolID)
+		present = nil
+		for _, node := range nodes {
+			present = append(present, node.Name)
+		}
+
+		if len(present) != len(before)-1 {
+			return false, nil
+		}
+
+		_, gone := DiffNodeSets(before, NodeNameSet(nodes))
+		if len(gone) == 0 {
+			return false, nil
+		}
+
+		removed = gone[0]
+		return true, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return "", errors.Errorf("timed out after %s waiting for a node to be removed from node pool %q; still present: %s",
+			time.Since(start).Round(time.Second), nodePoolID, strings.Join(present, ", "))
+	}
+
+	return removed, err
+}
+
+// WaitForSystemPodsReady polls the pods in kube-system until there is at
+// least one and all of them are ready (see IsPodReady) or the timeout
+// expires. A reachable API server doesn't mean that core components such as
+// the CNI and CoreDNS are up, so this should be used before running anything
+// that depends on them. On timeout, the error lists the pods that were still
+// unready.
+func WaitForSystemPodsReady(kubeClientset kubernetes.Interface, poll, timeout time.Duration) error {
+	return WaitForSystemPodsReadyWithContext(context.Background(), kubeClientset, poll, timeout)
+}
+
+// WaitForSystemPodsReadyWithContext is the same as WaitForSystemPodsReady
+// but stops waiting if ctx is done, in which case ctx.Err() is returned
+func WaitForSystemPodsReadyWithContext(ctx context.Context, kubeClientset kubernetes.Interface, poll, timeout time.Duration) error {
+	var unready []string
+	start := time.Now()
+
+	err := PollImmediateWithContext(ctx, poll, timeout, func() (bool, error) {
+		podList, err := kubeClientset.CoreV1().
+			Pods(metav1.NamespaceSystem).
+			List(metav1.ListOptions{})
+		if err != nil {
+			if IsRetryableAPIError(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "listing pods in kube-system")
+		}
+
+		// The namespace may be briefly empty before the system components
+		// are scheduled
+		if len(podList.Items) == 0 {
+			unready = nil
+			return false, nil
+		}
+
+		unready = unreadyPods(podList.Items)
+		return len(unready) == 0, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		waited := time.Since(start).Round(time.Second)
+		if len(unready) == 0 {
+			return errors.Errorf("timed out after %s waiting for pods in kube-system: none found", waited)
+		}
+
+		return errors.Errorf("timed out after %s waiting for pods in kube-system to be ready: %s",
+			waited, strings.Join(unready, ", "))
+	}
+
+	return err
+}
+
+// unreadyPods returns a description of each pod that isn't ready
+func unreadyPods(pods []corev1.Pod) []string {
+	var unready []string
+	for _, pod := range pods {
+		if !IsPodReady(pod) {
+			unready = append(unready, fmt.Sprintf("%s (%s)", pod.Name, pod.Status.Phase))
+		}
+	}
+
+	return unready
+}
@@ -0,0 +1,307 @@
+package util
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/mattkelly/containership-test-v2-experiment/cloudfake"
+)
+
+func clusterStatusGetter(cs *cloudfake.Clientset, clusterID string) func() (string, error) {
+	return func() (string, error) {
+		cluster, err := cs.Provision().
+			CKEClusters("org").
+			Get(clusterID)
+		if err != nil {
+			return "", err
+		}
+
+		return *cluster.Status.Type, nil
+	}
+}
+
+func TestWaitForStatus(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("running", "PROVISIONING", "RUNNING")
+	cs.AddCluster("error", "PROVISIONING", "ERROR")
+	cs.AddCluster("stuck", "PROVISIONING")
+
+	poll := time.Millisecond
+	timeout := 50 * time.Millisecond
+
+	err := WaitForStatus(poll, timeout, clusterStatusGetter(cs, "running"), "RUNNING", "PROVISIONING")
+	if err != nil {
+		t.Errorf("expected success, got error: %v", err)
+	}
+
+	err = WaitForStatus(poll, timeout, clusterStatusGetter(cs, "error"), "RUNNING", "PROVISIONING")
+	if err == nil {
+		t.Error("expected error for unexpected status")
+	}
+	if _, ok := err.(*StatusTimeoutError); ok {
+		t.Error("expected unexpected status error, got timeout")
+	}
+	if statusErr, ok := err.(*UnexpectedStatusError); !ok || statusErr.Status != "ERROR" {
+		t.Errorf("expected *UnexpectedStatusError for status %q, got %v", "ERROR", err)
+	}
+
+	err = WaitForStatusOf("cluster", poll, timeout, clusterStatusGetter(cs, "stuck"), "RUNNING", "PROVISIONING")
+	timeoutErr, ok := err.(*StatusTimeoutError)
+	if !ok {
+		t.Fatalf("expected *StatusTimeoutError, got %v", err)
+	}
+	if timeoutErr.LastStatus != "PROVISIONING" {
+		t.Errorf("expected last observed status %q, got %q", "PROVISIONING", timeoutErr.LastStatus)
+	}
+
+	err = WaitForStatus(poll, timeout, clusterStatusGetter(cs, "missing"), "RUNNING", "PROVISIONING")
+	if !IsCloudNotFoundError(errors.Cause(err)) {
+		t.Errorf("expected not found error to be returned, got %v", err)
+	}
+}
+
+func TestWaitForStatusRetriesTransientErrors(t *testing.T) {
+	calls := 0
+	get := func() (string, error) {
+		calls++
+		if calls == 1 {
+			return "", errors.Wrap(statusCodeError(503), "GETing cluster")
+		}
+
+		return "RUNNING", nil
+	}
+
+	err := WaitForStatus(time.Millisecond, time.Second, get, "RUNNING", "PROVISIONING")
+	if err != nil {
+		t.Errorf("expected transient error to be retried, got error: %v", err)
+	}
+}
+
+func TestPollWithBackoff(t *testing.T) {
+	var calls []time.Time
+	err := PollWithBackoff(time.Millisecond, 8*time.Millisecond, time.Second, func() (bool, error) {
+		calls = append(calls, time.Now())
+		return len(calls) == 6, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Intervals should be 1, 2, 4, 8, 8ms
+	for i, want := range []time.Duration{1, 2, 4, 8, 8} {
+		want *= time.Millisecond
+		if interval := calls[i+1].Sub(calls[i]); interval < want {
+			t.Errorf("interval %d: expected at least %s, got %s", i, want, interval)
+		}
+	}
+
+	err = PollWithBackoff(time.Millisecond, 5*time.Millisecond, 20*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+
+	err = PollWithBackoff(time.Millisecond, 5*time.Millisecond, time.Second, func() (bool, error) {
+		return false, errors.New("condition failed")
+	})
+	if err == nil || err == wait.ErrWaitTimeout {
+		t.Errorf("expected condition error to be returned, got %v", err)
+	}
+}
+
+func TestJitteredInterval(t *testing.T) {
+	poll := 10 * time.Second
+
+	tests := []struct {
+		r    float64
+		want time.Duration
+	}{
+		{0, 8 * time.Second},
+		{0.5, 10 * time.Second},
+		{0.75, 11 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := jitteredInterval(poll, test.r); got != test.want {
+			t.Errorf("r=%v: expected %s, got %s", test.r, test.want, got)
+		}
+	}
+
+	if got := jitteredInterval(poll, 0.9999); got > 12*time.Second {
+		t.Errorf("expected at most 20%% jitter, got %s", got)
+	}
+}
+
+func TestPollWithJitter(t *testing.T) {
+	// Always jitter to the shortest interval, 8ms
+	randomCalls := 0
+	random := func() float64 {
+		randomCalls++
+		return 0
+	}
+
+	var calls []time.Time
+	err := pollWithJitter(context.Background(), 10*time.Millisecond, time.Second, random, func() (bool, error) {
+		calls = append(calls, time.Now())
+		return len(calls) == 3, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if randomCalls != 2 {
+		t.Errorf("expected jitter to be drawn once per interval, got %d draws", randomCalls)
+	}
+	for i := 0; i < len(calls)-1; i++ {
+		if interval := calls[i+1].Sub(calls[i]); interval < 8*time.Millisecond {
+			t.Errorf("interval %d: expected at least 8ms, got %s", i, interval)
+		}
+	}
+
+	err = PollWithJitter(time.Millisecond, 10*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestWaitForNodePoolNodeRemoved(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		poolNode("a-0", "pool-a"),
+		poolNode("b-0", "pool-b"),
+	)
+
+	poll := time.Millisecond
+	timeout := 20 * time.Millisecond
+
+	removed, err := WaitForNodePoolNodeRemoved(kube, "pool-a", map[string]struct{}{"a-0": {}, "a-1": {}}, poll, timeout)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if removed != "a-1" {
+		t.Errorf("expected removed node %q, got %q", "a-1", removed)
+	}
+
+	_, err = WaitForNodePoolNodeRemoved(kube, "pool-b", map[string]struct{}{"b-0": {}}, poll, timeout)
+	if err == nil {
+		t.Fatal("expected timeout error when no node was removed")
+	}
+	if !strings.Contains(err.Error(), "still present: b-0") {
+		t.Errorf("expected error to list the still present node, got %v", err)
+	}
+
+	if _, err := WaitForNodePoolNodeRemoved(kube, "missing", nil, poll, timeout); err == nil {
+		t.Error("expected error for empty snapshot")
+	}
+}
+
+func TestPollImmediateWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	start := time.Now()
+	err := PollImmediateWithContext(ctx, 10*time.Millisecond, time.Minute, func() (bool, error) {
+		calls++
+		if calls == 2 {
+			cancel()
+		}
+		return false, nil
+	})
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("expected cancellation to stop polling promptly, waited %s", waited)
+	}
+
+	// An already cancelled context must not run the condition at all
+	calls = 0
+	err = PollImmediateWithContext(ctx, time.Millisecond, time.Minute, func() (bool, error) {
+		calls++
+		return true, nil
+	})
+	if err != context.Canceled || calls != 0 {
+		t.Errorf("expected %v without running condition, got %v after %d calls", context.Canceled, err, calls)
+	}
+
+	err = PollImmediateWithContext(context.Background(), time.Millisecond, 10*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout {
+		t.Errorf("expected %v, got %v", wait.ErrWaitTimeout, err)
+	}
+}
+
+func TestWaitForStatusWithContextCancelled(t *testing.T) {
+	cs := cloudfake.New()
+	cs.AddCluster("stuck", "PROVISIONING")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := WaitForStatusOfWithContext(ctx, "cluster", time.Millisecond, time.Minute,
+		clusterStatusGetter(cs, "stuck"), "RUNNING", "PROVISIONING")
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestWaitForSystemPodsReady(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceSystem},
+			Status: corev1.PodStatus{
+				Phase: phase,
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: ready},
+				},
+			},
+		}
+	}
+
+	poll := time.Millisecond
+	timeout := 20 * time.Millisecond
+
+	kube := fake.NewSimpleClientset(
+		pod("coredns", corev1.PodRunning, corev1.ConditionTrue),
+		pod("cni-setup", corev1.PodSucceeded, corev1.ConditionFalse),
+	)
+	if err := WaitForSystemPodsReady(kube, poll, timeout); err != nil {
+		t.Errorf("expected success, got error: %v", err)
+	}
+
+	kube = fake.NewSimpleClientset(
+		pod("coredns", corev1.PodRunning, corev1.ConditionTrue),
+		pod("cni", corev1.PodRunning, corev1.ConditionFalse),
+		pod("pending", corev1.PodPending, corev1.ConditionFalse),
+		// Pods in other namespaces are ignored
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: metav1.NamespaceDefault}},
+	)
+	err := WaitForSystemPodsReady(kube, poll, timeout)
+	if err == nil {
+		t.Fatal("expected error with unready pods")
+	}
+	for _, name := range []string{"cni (Running)", "pending (Pending)"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to name %q, got: %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "coredns") || strings.Contains(err.Error(), "app") {
+		t.Errorf("expected error to only name unready system pods, got: %v", err)
+	}
+
+	if err := WaitForSystemPodsReady(fake.NewSimpleClientset(), poll, timeout); err == nil {
+		t.Error("expected error with no system pods")
+	}
+}